@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"mercuria-backend/internal/database"
 	"mercuria-backend/internal/server"
+	"mercuria-backend/internal/storage"
 	"os"
 	"strconv"
 
@@ -10,6 +13,19 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "storage" {
+		storageCommand(os.Args[2:])
+		return
+	}
+
+	// Refuse to serve against a schema this binary was not built for
+	if err := database.New().CheckSchemaVersion(); err != nil {
+		log.Fatalf("cannot start server: %s (run `migrate up`)", err)
+	}
 
 	server := server.New()
 
@@ -21,3 +37,75 @@ func main() {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
 }
+
+// Usage: main migrate up | down [steps] | status
+func migrate(args []string) {
+	db := database.New()
+	defer db.Close()
+
+	if len(args) == 0 {
+		log.Fatal("usage: migrate up | down [steps] | status")
+	}
+
+	switch args[0] {
+	case "up":
+		if err := db.MigrateUp(); err != nil {
+			log.Fatalf("migrate up: %s", err)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("migrate down: invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := db.MigrateDown(steps); err != nil {
+			log.Fatalf("migrate down: %s", err)
+		}
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			log.Fatalf("migrate status: %s", err)
+		}
+		version, dirty, err := db.SchemaVersion()
+		if err != nil {
+			log.Fatalf("migrate status: %s", err)
+		}
+		for _, m := range status {
+			mark := " "
+			if m.Applied {
+				mark = "x"
+			}
+			fmt.Printf("[%s] %06d_%s\n", mark, m.Version, m.Name)
+		}
+		fmt.Printf("version: %d, dirty: %t\n", version, dirty)
+	default:
+		log.Fatalf("unknown migrate command %q", args[0])
+	}
+}
+
+// Usage: main storage privatize
+//
+// Objects used to be uploaded public-read; privatize resets the ACL of every
+// object in the bucket so they can only be read through signed URLs.
+func storageCommand(args []string) {
+	if len(args) == 0 || args[0] != "privatize" {
+		log.Fatal("usage: storage privatize")
+	}
+
+	store := storage.New()
+	count := 0
+	err := store.ListFiles("", func(fileId string) error {
+		if err := store.MakePrivate(fileId); err != nil {
+			return fmt.Errorf("%s: %w", fileId, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("storage privatize: %s (%d objects done)", err, count)
+	}
+	fmt.Printf("%d objects private\n", count)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/jobs"
+	"mercuria-backend/internal/queue"
+	"mercuria-backend/internal/storage"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dead" {
+		dead(os.Args[2:])
+		return
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	// Same as the API, jobs are written against the latest schema
+	if err := db.CheckSchemaVersion(); err != nil {
+		log.Fatalf("cannot start worker: %s (run `migrate up`)", err)
+	}
+
+	config := queue.DefaultWorkerConfig
+	if n, err := strconv.Atoi(os.Getenv("WORKER_CONCURRENCY")); err == nil && n > 0 {
+		config.Concurrency = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("WORKER_VISIBILITY_TIMEOUT")); err == nil && d > 0 {
+		config.Visibility = d
+	}
+
+	worker := queue.NewWorker(queue.New(), config)
+	jobs.Register(worker, db, storage.New())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("worker started with %d slots", config.Concurrency)
+	worker.Run(ctx)
+	log.Print("worker stopped")
+}
+
+// Usage: worker dead list [limit] | retry <job id>
+func dead(args []string) {
+	q := queue.New()
+
+	if len(args) == 0 {
+		log.Fatal("usage: dead list [limit] | retry <job id>")
+	}
+
+	switch args[0] {
+	case "list":
+		limit := int64(50)
+		if len(args) > 1 {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || n < 1 {
+				log.Fatalf("dead list: invalid limit %q", args[1])
+			}
+			limit = n
+		}
+		jobs, err := q.DeadJobs(limit)
+		if err != nil {
+			log.Fatalf("dead list: %s", err)
+		}
+		for _, job := range jobs {
+			fmt.Printf("%s %s attempts=%d payload=%s error=%q\n", job.ID, job.Type, job.Attempts, job.Payload, job.LastError)
+		}
+	case "retry":
+		if len(args) < 2 {
+			log.Fatal("usage: dead retry <job id>")
+		}
+		if err := q.RetryDead(args[1]); err != nil {
+			log.Fatalf("dead retry: %s", err)
+		}
+	default:
+		log.Fatalf("unknown dead command %q", args[0])
+	}
+}
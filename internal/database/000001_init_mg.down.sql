@@ -0,0 +1,14 @@
+DROP FUNCTION IF EXISTS get_events(uuid);
+DROP FUNCTION IF EXISTS create_event(text, uuid);
+DROP FUNCTION IF EXISTS get_event(uuid);
+DROP TYPE IF EXISTS event_type;
+
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS photos;
+DROP TABLE IF EXISTS members;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS users;
+
+DROP FUNCTION IF EXISTS get_or_create_user(text, text, text, text);
+DROP FUNCTION IF EXISTS uuidv7();
@@ -0,0 +1,94 @@
+-- Capture time is filled in from image metadata when it is known; listings
+-- fall back to the upload time otherwise.
+ALTER TABLE photos ADD COLUMN captured_at timestamp with time zone;
+
+CREATE INDEX photos_event_id_created_at_idx ON photos (event_id, created_at, id);
+CREATE INDEX photos_event_id_sort_taken_idx ON photos (event_id, (COALESCE(captured_at, created_at)), id);
+CREATE INDEX photos_event_id_created_by_idx ON photos (event_id, created_by);
+
+-- `photos.*` is part of event_type, so the type and the functions returning
+-- it have to be recreated with the new column.
+
+DROP FUNCTION IF EXISTS get_event(uuid);
+DROP FUNCTION IF EXISTS get_events(uuid);
+DROP TYPE IF EXISTS event_type;
+
+-- TYPE: event_type
+
+CREATE TYPE event_type AS (
+    id uuid,
+    name text,
+    created_at timestamp with time zone,
+    owner uuid,
+    image_url text,
+    owner_id uuid,
+    owner_oauth_id text,
+    owner_name text,
+    owner_avatar_url text,
+    owner_email text,
+    like_id bigint,
+    like_user_id uuid,
+    like_event_id uuid,
+    like_created_at timestamp with time zone,
+    photo_id uuid,
+    photo_public_url text,
+    photo_file_name text,
+    photo_file_type text,
+    photo_created_by uuid,
+    photo_event_id uuid,
+    photo_created_at timestamp with time zone,
+    photo_captured_at timestamp with time zone,
+    member_id uuid,
+    member_oauth_id text,
+    member_name text,
+    member_avatar_url text,
+    member_email text
+);
+
+-- FUNCTION: get_event(uuid)
+
+CREATE OR REPLACE FUNCTION get_event(
+    _id uuid)
+    RETURNS SETOF event_type
+    LANGUAGE 'plpgsql'
+
+AS $BODY$
+BEGIN
+    RETURN QUERY
+    SELECT events.*, users.*, likes.*, photos.*, users_mbr.*
+    FROM events
+
+    INNER JOIN users ON users.id = events.owner
+    LEFT JOIN likes ON likes.event_id = events.id
+    LEFT JOIN photos ON photos.event_id = events.id
+    INNER JOIN members ON members.event_id = events.id
+    INNER JOIN users AS users_mbr ON users_mbr.id = members.user_id
+    WHERE events.id = _id;
+END;
+$BODY$;
+
+-- FUNCTION: get_events(uuid)
+
+CREATE OR REPLACE FUNCTION get_events(_user_id uuid)
+  RETURNS SETOF event_type
+  LANGUAGE 'plpgsql'
+
+AS $BODY$
+BEGIN
+    RETURN QUERY
+    WITH user_events AS (
+        SELECT events.*
+        FROM events
+        JOIN members ON members.event_id = events.id
+        WHERE members.user_id = _user_id
+    )
+    SELECT user_events.*, users.*, likes.*, photos.*, users_mbr.*
+    FROM user_events
+
+    INNER JOIN users ON users.id = user_events.owner
+    LEFT JOIN likes ON likes.event_id = user_events.id
+    LEFT JOIN photos ON photos.event_id = user_events.id
+    INNER JOIN members ON members.event_id = user_events.id
+    INNER JOIN users AS users_mbr ON users_mbr.id = members.user_id;
+END;
+$BODY$;
@@ -0,0 +1,2 @@
+ALTER TABLE members DROP CONSTRAINT IF EXISTS members_user_id_event_id_key;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS likes_user_id_event_id_key;
@@ -0,0 +1,16 @@
+-- Keep the oldest row of every duplicate before adding the constraints
+
+DELETE FROM likes a
+USING likes b
+WHERE a.user_id = b.user_id
+  AND a.event_id = b.event_id
+  AND a.id > b.id;
+
+DELETE FROM members a
+USING members b
+WHERE a.user_id = b.user_id
+  AND a.event_id = b.event_id
+  AND a.id > b.id;
+
+ALTER TABLE likes ADD CONSTRAINT likes_user_id_event_id_key UNIQUE (user_id, event_id);
+ALTER TABLE members ADD CONSTRAINT members_user_id_event_id_key UNIQUE (user_id, event_id);
@@ -0,0 +1,18 @@
+-- Emoji reactions to single photos. Which emoji are accepted is up to the
+-- API configuration, a user can react with several of them. The event is
+-- repeated from the photo so per-event summaries don't need the join.
+CREATE TABLE photo_reactions (
+    photo_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    emoji text NOT NULL,
+    event_id uuid NOT NULL,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    CONSTRAINT photo_reactions_pkey PRIMARY KEY (photo_id, emoji, user_id),
+    CONSTRAINT photo_reactions_emoji_check CHECK (char_length(emoji) BETWEEN 1 AND 16),
+    CONSTRAINT photo_reactions_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+    CONSTRAINT photo_reactions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT photo_reactions_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);
+
+CREATE INDEX photo_reactions_event_id_idx ON photo_reactions (event_id, photo_id, emoji);
+CREATE INDEX photo_reactions_user_id_idx ON photo_reactions (user_id);
@@ -0,0 +1,38 @@
+-- Comments on an event, or on one of its photos when photo_id is set.
+-- Replies point to the comment that started the thread, threads are one
+-- level deep. Deleted comments stay as tombstones without a body, so the
+-- replies keep their thread.
+CREATE TABLE comments (
+    id uuid DEFAULT uuidv7() PRIMARY KEY,
+    event_id uuid NOT NULL,
+    photo_id uuid,
+    parent_id uuid,
+    author_id uuid NOT NULL,
+    body text NOT NULL,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    edited_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    deleted_by uuid,
+    CONSTRAINT comments_body_check CHECK (char_length(body) <= 4000 AND (body <> '' OR deleted_at IS NOT NULL)),
+    CONSTRAINT comments_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT comments_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+    CONSTRAINT comments_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT comments_author_id_fkey FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT comments_deleted_by_fkey FOREIGN KEY (deleted_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Threads of an event or a photo, and the replies of a thread, in order
+CREATE INDEX comments_event_id_threads_idx ON comments (event_id, created_at, id) WHERE photo_id IS NULL AND parent_id IS NULL;
+CREATE INDEX comments_photo_id_threads_idx ON comments (photo_id, created_at, id) WHERE parent_id IS NULL;
+CREATE INDEX comments_parent_id_idx ON comments (parent_id, created_at, id);
+
+-- Members mentioned in a comment
+CREATE TABLE comment_mentions (
+    comment_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    CONSTRAINT comment_mentions_pkey PRIMARY KEY (comment_id, user_id),
+    CONSTRAINT comment_mentions_comment_id_fkey FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT comment_mentions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX comment_mentions_user_id_idx ON comment_mentions (user_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS album_photos;
+DROP TABLE IF EXISTS albums;
+
+ALTER TABLE members DROP CONSTRAINT IF EXISTS members_role_check;
+ALTER TABLE members DROP COLUMN IF EXISTS role;
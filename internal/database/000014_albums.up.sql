@@ -0,0 +1,37 @@
+-- Co-hosts are members the owner lets curate the event
+ALTER TABLE members ADD COLUMN role text DEFAULT 'member' NOT NULL;
+ALTER TABLE members ADD CONSTRAINT members_role_check CHECK (role IN ('member', 'cohost'));
+
+-- Albums group photos of an event, a photo can be in several of them.
+-- Without a cover photo the first photo of the album is its cover.
+CREATE TABLE albums (
+    id uuid DEFAULT uuidv7() PRIMARY KEY,
+    event_id uuid NOT NULL,
+    name text NOT NULL,
+    description text DEFAULT '' NOT NULL,
+    cover_photo_id uuid,
+    position integer DEFAULT 0 NOT NULL,
+    created_by uuid,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    CONSTRAINT albums_name_check CHECK (char_length(name) BETWEEN 1 AND 100),
+    CONSTRAINT albums_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT albums_cover_photo_id_fkey FOREIGN KEY (cover_photo_id) REFERENCES photos(id) ON DELETE SET NULL,
+    CONSTRAINT albums_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX albums_event_id_idx ON albums (event_id, position, id);
+
+CREATE TABLE album_photos (
+    album_id uuid NOT NULL,
+    photo_id uuid NOT NULL,
+    position integer DEFAULT 0 NOT NULL,
+    added_by uuid,
+    added_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    CONSTRAINT album_photos_pkey PRIMARY KEY (album_id, photo_id),
+    CONSTRAINT album_photos_album_id_fkey FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+    CONSTRAINT album_photos_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+    CONSTRAINT album_photos_added_by_fkey FOREIGN KEY (added_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX album_photos_album_id_position_idx ON album_photos (album_id, position, photo_id);
+CREATE INDEX album_photos_photo_id_idx ON album_photos (photo_id);
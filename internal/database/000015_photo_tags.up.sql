@@ -0,0 +1,45 @@
+-- Members tagged in a photo, optionally with where they are in it as a box
+-- in fractions of the upright photo's width and height
+CREATE TABLE photo_tags (
+    photo_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    event_id uuid NOT NULL,
+    tagged_by uuid,
+    box_x real,
+    box_y real,
+    box_width real,
+    box_height real,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    CONSTRAINT photo_tags_pkey PRIMARY KEY (photo_id, user_id),
+    CONSTRAINT photo_tags_box_check CHECK (
+        (box_x IS NULL AND box_y IS NULL AND box_width IS NULL AND box_height IS NULL)
+        OR (box_x >= 0 AND box_y >= 0 AND box_width > 0 AND box_height > 0
+            AND box_x + box_width <= 1 AND box_y + box_height <= 1)
+    ),
+    CONSTRAINT photo_tags_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+    CONSTRAINT photo_tags_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT photo_tags_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT photo_tags_tagged_by_fkey FOREIGN KEY (tagged_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Photos of a user across events
+CREATE INDEX photo_tags_user_id_idx ON photo_tags (user_id, event_id);
+
+-- What happened to a user's photos, tags and so on, newest first
+CREATE TABLE notifications (
+    id uuid DEFAULT uuidv7() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    type text NOT NULL,
+    actor_id uuid,
+    event_id uuid,
+    photo_id uuid,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    read_at timestamp with time zone,
+    CONSTRAINT notifications_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT notifications_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT notifications_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT notifications_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX notifications_user_id_idx ON notifications (user_id, created_at DESC, id DESC);
+CREATE INDEX notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
@@ -0,0 +1,22 @@
+-- ZIP archives of an event's photos, or of one of its albums, built by the
+-- worker and kept in storage until they expire
+CREATE TABLE exports (
+    id uuid DEFAULT uuidv7() PRIMARY KEY,
+    event_id uuid NOT NULL,
+    album_id uuid,
+    requested_by uuid,
+    status text DEFAULT 'pending' NOT NULL,
+    photo_count integer DEFAULT 0 NOT NULL,
+    size bigint DEFAULT 0 NOT NULL,
+    error text DEFAULT '' NOT NULL,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL,
+    completed_at timestamp with time zone,
+    expires_at timestamp with time zone,
+    CONSTRAINT exports_status_check CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    CONSTRAINT exports_event_id_fkey FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT exports_album_id_fkey FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+    CONSTRAINT exports_requested_by_fkey FOREIGN KEY (requested_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX exports_event_id_idx ON exports (event_id, created_at DESC);
+CREATE INDEX exports_expires_at_idx ON exports (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Storage keys whose rows are gone but whose objects could not be deleted,
+-- the worker retries them until storage lets go
+CREATE TABLE orphaned_files (
+    key text PRIMARY KEY,
+    created_at timestamp with time zone DEFAULT "now"() NOT NULL
+);
+
+CREATE INDEX orphaned_files_created_at_idx ON orphaned_files (created_at);
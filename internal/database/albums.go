@@ -0,0 +1,400 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const MaxAlbumNameLength = 100
+
+var (
+	ErrInvalidAlbum = errors.New("invalid album")
+	// Only photos of the album's event that are not in the trash can be added
+	ErrInvalidAlbumPhoto = errors.New("photo is not part of the event")
+	ErrInvalidAlbumCover = errors.New("cover photo is not in the album")
+)
+
+// Album groups photos of an event, in the order co-hosts arrange them.
+// Cover is the cover photo, or the first photo when none is chosen.
+type Album struct {
+	ID           string    `json:"id"`
+	EventID      string    `json:"event_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	CoverPhotoID *string   `json:"cover_photo_id"`
+	Cover        *Photo    `json:"cover"`
+	PhotoCount   int       `json:"photo_count"`
+	Position     int       `json:"position"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AlbumUpdate holds the fields of an album to change; nil fields are left
+// untouched and an empty CoverPhotoID goes back to the first photo.
+type AlbumUpdate struct {
+	Name         *string
+	Description  *string
+	CoverPhotoID *string
+}
+
+// AlbumPhotoQuery describes a page of an album's photos, in album order.
+type AlbumPhotoQuery struct {
+	AlbumID string
+	Cursor  string
+	Limit   int
+}
+
+// photoColumns for queries joining photos with other tables
+var qualifiedPhotoColumns = func() string {
+	columns := strings.Split(photoColumns, ",")
+	for i, column := range columns {
+		columns[i] = "photos." + strings.TrimSpace(column)
+	}
+	return strings.Join(columns, ", ")
+}()
+
+const albumSelect = `SELECT albums.id, albums.event_id, albums.name, albums.description, albums.cover_photo_id,
+		albums.position, albums.created_by, albums.created_at,
+		(SELECT count(*) FROM album_photos
+			JOIN photos ON photos.id = album_photos.photo_id
+			WHERE album_photos.album_id = albums.id AND photos.deleted_at IS NULL),
+		(SELECT album_photos.photo_id FROM album_photos
+			JOIN photos ON photos.id = album_photos.photo_id
+			WHERE album_photos.album_id = albums.id AND photos.deleted_at IS NULL
+			ORDER BY (album_photos.photo_id = albums.cover_photo_id) IS TRUE DESC, album_photos.position, album_photos.photo_id
+			LIMIT 1)
+	FROM albums`
+
+func scanAlbum(scan func(dest ...any) error) (*Album, sql.NullString, error) {
+	var a Album
+	var coverPhotoId, createdBy, coverId sql.NullString
+	err := scan(&a.ID, &a.EventID, &a.Name, &a.Description, &coverPhotoId,
+		&a.Position, &createdBy, &a.CreatedAt,
+		&a.PhotoCount, &coverId)
+	if err != nil {
+		return nil, coverId, err
+	}
+	if coverPhotoId.Valid {
+		a.CoverPhotoID = &coverPhotoId.String
+	}
+	a.CreatedBy = createdBy.String
+	return &a, coverId, nil
+}
+
+// CreateAlbum adds an album after the other albums of its event.
+func (s *service) CreateAlbum(album *Album) error {
+	err := s.q.QueryRow(
+		`INSERT INTO albums (event_id, name, description, created_by, position)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(max(position) + 1, 0) FROM albums WHERE event_id = $1))
+		RETURNING id, position, created_at`,
+		album.EventID,
+		album.Name,
+		album.Description,
+		album.CreatedBy,
+	).Scan(&album.ID, &album.Position, &album.CreatedAt)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidAlbum, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[CreateAlbum] %v", err)
+	}
+	return nil
+}
+
+func (s *service) GetAlbum(albumId string) (*Album, error) {
+	album, coverId, err := scanAlbum(s.q.QueryRow(albumSelect+" WHERE albums.id = $1", albumId).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetAlbum] %v", err)
+	}
+	covers := map[*Album]string{}
+	if coverId.Valid {
+		covers[album] = coverId.String
+	}
+	if err := s.loadAlbumCovers(covers); err != nil {
+		return nil, err
+	}
+	return album, nil
+}
+
+// ListEventAlbums returns the albums of an event in their order.
+func (s *service) ListEventAlbums(eventId string) ([]Album, error) {
+	rows, err := s.q.Query(albumSelect+" WHERE albums.event_id = $1 ORDER BY albums.position, albums.id", eventId)
+	if err != nil {
+		return nil, fmt.Errorf("[ListEventAlbums] %v", err)
+	}
+	defer rows.Close()
+
+	albums := []Album{}
+	coverIds := []sql.NullString{}
+	for rows.Next() {
+		album, coverId, err := scanAlbum(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("[ListEventAlbumsScan] %v", err)
+		}
+		albums = append(albums, *album)
+		coverIds = append(coverIds, coverId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListEventAlbums] %v", err)
+	}
+	rows.Close()
+
+	covers := map[*Album]string{}
+	for i, coverId := range coverIds {
+		if coverId.Valid {
+			covers[&albums[i]] = coverId.String
+		}
+	}
+	if err := s.loadAlbumCovers(covers); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+func (s *service) loadAlbumCovers(covers map[*Album]string) error {
+	if len(covers) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(covers))
+	for _, id := range covers {
+		ids = append(ids, id)
+	}
+
+	rows, err := s.q.Query("SELECT "+photoColumns+" FROM photos WHERE id = ANY($1::uuid[])", ids)
+	if err != nil {
+		return fmt.Errorf("[loadAlbumCovers] %v", err)
+	}
+	defer rows.Close()
+
+	photos := make(map[string]*Photo, len(ids))
+	for rows.Next() {
+		var fields photoFields
+		if err := rows.Scan(fields.dest()...); err != nil {
+			return fmt.Errorf("[loadAlbumCoversScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return fmt.Errorf("[loadAlbumCoversScan] %v", err)
+		}
+		photos[photo.ID] = photo
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("[loadAlbumCovers] %v", err)
+	}
+	for album, id := range covers {
+		album.Cover = photos[id]
+	}
+	return nil
+}
+
+// UpdateAlbum returns ErrInvalidAlbumCover unless the cover photo is in the
+// album and not in the trash.
+func (s *service) UpdateAlbum(albumId string, update AlbumUpdate) error {
+	if update.CoverPhotoID != nil && *update.CoverPhotoID != "" {
+		var ok bool
+		err := s.q.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM album_photos
+				JOIN photos ON photos.id = album_photos.photo_id
+				WHERE album_photos.album_id = $1 AND album_photos.photo_id = $2 AND photos.deleted_at IS NULL)`,
+			albumId,
+			*update.CoverPhotoID,
+		).Scan(&ok)
+		if err != nil {
+			return fmt.Errorf("[UpdateAlbum] %v", err)
+		}
+		if !ok {
+			return ErrInvalidAlbumCover
+		}
+	}
+
+	res, err := s.q.Exec(
+		`UPDATE albums SET
+			name = COALESCE($2, name),
+			description = COALESCE($3, description),
+			cover_photo_id = CASE WHEN $4::text IS NULL THEN cover_photo_id ELSE NULLIF($4, '')::uuid END
+		WHERE id = $1`,
+		albumId,
+		update.Name,
+		update.Description,
+		update.CoverPhotoID,
+	)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidAlbum, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[UpdateAlbum] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// DeleteAlbum deletes an album, its photos stay in the event.
+func (s *service) DeleteAlbum(albumId string) error {
+	res, err := s.q.Exec("DELETE FROM albums WHERE id = $1", albumId)
+	if err != nil {
+		return fmt.Errorf("[DeleteAlbum] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// OrderEventAlbums moves the given albums, in that order, before the other
+// albums of the event. IDs of other events' albums are ignored.
+func (s *service) OrderEventAlbums(eventId string, albumIds []string) error {
+	_, err := s.q.Exec(
+		`UPDATE albums SET position = ordered.position
+		FROM (
+			SELECT id, row_number() OVER (
+				ORDER BY array_position($2::uuid[], id) NULLS LAST, position, id
+			) - 1 AS position
+			FROM albums WHERE event_id = $1
+		) AS ordered
+		WHERE albums.id = ordered.id`,
+		eventId,
+		albumIds,
+	)
+	if err != nil {
+		return fmt.Errorf("[OrderEventAlbums] %v", err)
+	}
+	return nil
+}
+
+// AddAlbumPhotos appends photos to an album in the given order, skipping
+// those already in it. It returns ErrInvalidAlbumPhoto, adding nothing,
+// unless they are all photos of the album's event outside of the trash.
+func (s *service) AddAlbumPhotos(albumId string, userId string, photoIds []string) error {
+	order := photoIds
+	photoIds = slices.Compact(slices.Sorted(slices.Values(photoIds)))
+	var found int
+	err := s.q.QueryRow(
+		`SELECT count(*) FROM photos
+		JOIN albums ON albums.event_id = photos.event_id
+		WHERE albums.id = $1 AND photos.id = ANY($2::uuid[]) AND photos.deleted_at IS NULL`,
+		albumId,
+		photoIds,
+	).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("[AddAlbumPhotos] %v", err)
+	}
+	if found != len(photoIds) {
+		return ErrInvalidAlbumPhoto
+	}
+
+	_, err = s.q.Exec(
+		`INSERT INTO album_photos (album_id, photo_id, added_by, position)
+		SELECT $1, id, $3,
+			(SELECT COALESCE(max(position), -1) FROM album_photos WHERE album_id = $1) + row_number() OVER (ORDER BY array_position($4::uuid[], id))
+		FROM photos WHERE id = ANY($2::uuid[]) AND id NOT IN (SELECT photo_id FROM album_photos WHERE album_id = $1)
+		ON CONFLICT (album_id, photo_id) DO NOTHING`,
+		albumId,
+		photoIds,
+		userId,
+		order,
+	)
+	if err != nil {
+		return fmt.Errorf("[AddAlbumPhotos] %v", err)
+	}
+	return nil
+}
+
+// RemoveAlbumPhotos takes photos out of an album, and the cover if it is
+// one of them.
+func (s *service) RemoveAlbumPhotos(albumId string, photoIds []string) error {
+	_, err := s.q.Exec("DELETE FROM album_photos WHERE album_id = $1 AND photo_id = ANY($2::uuid[])", albumId, photoIds)
+	if err != nil {
+		return fmt.Errorf("[RemoveAlbumPhotos] %v", err)
+	}
+	_, err = s.q.Exec("UPDATE albums SET cover_photo_id = NULL WHERE id = $1 AND cover_photo_id = ANY($2::uuid[])", albumId, photoIds)
+	if err != nil {
+		return fmt.Errorf("[RemoveAlbumPhotos] %v", err)
+	}
+	return nil
+}
+
+// OrderAlbumPhotos moves the given photos, in that order, before the other
+// photos of the album. IDs of photos outside of the album are ignored.
+func (s *service) OrderAlbumPhotos(albumId string, photoIds []string) error {
+	_, err := s.q.Exec(
+		`UPDATE album_photos SET position = ordered.position
+		FROM (
+			SELECT photo_id, row_number() OVER (
+				ORDER BY array_position($2::uuid[], photo_id) NULLS LAST, position, photo_id
+			) - 1 AS position
+			FROM album_photos WHERE album_id = $1
+		) AS ordered
+		WHERE album_photos.album_id = $1 AND album_photos.photo_id = ordered.photo_id`,
+		albumId,
+		photoIds,
+	)
+	if err != nil {
+		return fmt.Errorf("[OrderAlbumPhotos] %v", err)
+	}
+	return nil
+}
+
+// ListAlbumPhotos returns a page of an album's photos that are not in the
+// trash, in album order.
+func (s *service) ListAlbumPhotos(q AlbumPhotoQuery) (*PhotoPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPhotoPageSize
+	}
+	if q.Limit > MaxPhotoPageSize {
+		q.Limit = MaxPhotoPageSize
+	}
+
+	args := []any{q.AlbumID}
+	where := []string{"album_photos.album_id = $1", "photos.deleted_at IS NULL"}
+	if q.Cursor != "" {
+		position, id, err := decodePositionCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, position, id)
+		where = append(where, fmt.Sprintf("(album_photos.position, album_photos.photo_id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, q.Limit+1)
+
+	query := fmt.Sprintf(`SELECT %s, album_photos.position
+		FROM album_photos
+		JOIN photos ON photos.id = album_photos.photo_id
+		WHERE %s
+		ORDER BY album_photos.position, album_photos.photo_id
+		LIMIT $%d`,
+		qualifiedPhotoColumns, strings.Join(where, " AND "), len(args),
+	)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[ListAlbumPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	page := &PhotoPage{Photos: []Photo{}}
+	var lastPosition int64
+	for rows.Next() {
+		var fields photoFields
+		var position int64
+		if err := rows.Scan(append(fields.dest(), &position)...); err != nil {
+			return nil, fmt.Errorf("[ListAlbumPhotosScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return nil, fmt.Errorf("[ListAlbumPhotosScan] %v", err)
+		}
+		if len(page.Photos) == q.Limit {
+			page.NextCursor = encodePositionCursor(lastPosition, page.Photos[len(page.Photos)-1].ID)
+			break
+		}
+		page.Photos = append(page.Photos, *photo)
+		lastPosition = position
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListAlbumPhotos] %v", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,298 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCommentPageSize = 50
+	MaxCommentPageSize     = 100
+	MaxCommentLength       = 4000
+)
+
+var (
+	ErrInvalidComment = errors.New("invalid comment")
+	// Only members of the event can be mentioned
+	ErrInvalidMention = errors.New("mentioned user is not a member of the event")
+)
+
+// Comment is on an event, or on one of its photos when PhotoID is set.
+// Replies have the comment starting their thread as ParentID. Deleted
+// comments are kept, without body, as long as their thread has replies.
+type Comment struct {
+	ID         string     `json:"id"`
+	EventID    string     `json:"event_id"`
+	PhotoID    *string    `json:"photo_id"`
+	ParentID   *string    `json:"parent_id"`
+	Author     User       `json:"author"`
+	Body       string     `json:"body"`
+	Mentions   []User     `json:"mentions"`
+	ReplyCount int        `json:"reply_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	EditedAt   *time.Time `json:"edited_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+// CommentQuery describes a page of the threads of an event (PhotoID and
+// ParentID empty), of a photo (PhotoID set) or of the replies of a thread
+// (ParentID set), oldest first.
+type CommentQuery struct {
+	EventID  string
+	PhotoID  string
+	ParentID string
+	Cursor   string
+	Limit    int
+}
+
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+const commentSelect = `SELECT comments.id, comments.event_id, comments.photo_id, comments.parent_id,
+		comments.body, comments.created_at, comments.edited_at, comments.deleted_at,
+		users.id, users.oauth_id, users.name, users.avatar_url, users.email,
+		(SELECT count(*) FROM comments AS replies WHERE replies.parent_id = comments.id AND replies.deleted_at IS NULL)
+	FROM comments
+	JOIN users ON users.id = comments.author_id`
+
+func scanComment(scan func(dest ...any) error) (*Comment, error) {
+	var c Comment
+	var photoId, parentId sql.NullString
+	var editedAt, deletedAt sql.NullTime
+	err := scan(&c.ID, &c.EventID, &photoId, &parentId,
+		&c.Body, &c.CreatedAt, &editedAt, &deletedAt,
+		&c.Author.ID, &c.Author.OAuthId, &c.Author.Name, &c.Author.AvatarUrl, &c.Author.Email,
+		&c.ReplyCount)
+	if err != nil {
+		return nil, err
+	}
+	if photoId.Valid {
+		c.PhotoID = &photoId.String
+	}
+	if parentId.Valid {
+		c.ParentID = &parentId.String
+	}
+	if editedAt.Valid {
+		c.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		c.DeletedAt = &deletedAt.Time
+	}
+	c.Mentions = []User{}
+	return &c, nil
+}
+
+func (s *service) CreateComment(comment *Comment) error {
+	err := s.q.QueryRow(
+		`INSERT INTO comments (event_id, photo_id, parent_id, author_id, body)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		comment.EventID,
+		comment.PhotoID,
+		comment.ParentID,
+		comment.Author.ID,
+		comment.Body,
+	).Scan(&comment.ID, &comment.CreatedAt)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidComment, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[CreateComment] %v", err)
+	}
+	return nil
+}
+
+func (s *service) GetComment(commentId string) (*Comment, error) {
+	comment, err := scanComment(s.q.QueryRow(commentSelect+" WHERE comments.id = $1", commentId).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetComment] %v", err)
+	}
+	if err := s.loadMentions([]*Comment{comment}); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// UpdateComment replaces the body of a comment that is not deleted.
+func (s *service) UpdateComment(commentId string, body string) error {
+	res, err := s.q.Exec(
+		"UPDATE comments SET body = $2, edited_at = now() WHERE id = $1 AND deleted_at IS NULL",
+		commentId,
+		body,
+	)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidComment, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[UpdateComment] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// DeleteComment leaves a tombstone of the comment, without body or
+// mentions.
+func (s *service) DeleteComment(commentId string, userId string) error {
+	res, err := s.q.Exec(
+		"UPDATE comments SET body = '', deleted_at = now(), deleted_by = $2 WHERE id = $1 AND deleted_at IS NULL",
+		commentId,
+		userId,
+	)
+	if err != nil {
+		return fmt.Errorf("[DeleteComment] %v", err)
+	}
+	if err := expectAffected(res); err != nil {
+		return err
+	}
+	if _, err := s.q.Exec("DELETE FROM comment_mentions WHERE comment_id = $1", commentId); err != nil {
+		return fmt.Errorf("[DeleteComment] %v", err)
+	}
+	return nil
+}
+
+// SetCommentMentions replaces who a comment mentions. It returns
+// ErrInvalidMention unless they are all members of the event.
+func (s *service) SetCommentMentions(commentId string, eventId string, userIds []string) error {
+	if _, err := s.q.Exec("DELETE FROM comment_mentions WHERE comment_id = $1", commentId); err != nil {
+		return fmt.Errorf("[SetCommentMentions] %v", err)
+	}
+	userIds = slices.Compact(slices.Sorted(slices.Values(userIds)))
+	if len(userIds) == 0 {
+		return nil
+	}
+	res, err := s.q.Exec(
+		`INSERT INTO comment_mentions (comment_id, user_id)
+		SELECT $1, user_id FROM members WHERE event_id = $2 AND user_id = ANY($3::uuid[])`,
+		commentId,
+		eventId,
+		userIds,
+	)
+	if err != nil {
+		return fmt.Errorf("[SetCommentMentions] %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[SetCommentMentions] %v", err)
+	}
+	if int(n) != len(userIds) {
+		return ErrInvalidMention
+	}
+	return nil
+}
+
+func (s *service) ListComments(q CommentQuery) (*CommentPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultCommentPageSize
+	}
+	if q.Limit > MaxCommentPageSize {
+		q.Limit = MaxCommentPageSize
+	}
+
+	var args []any
+	var where []string
+	switch {
+	case q.ParentID != "":
+		args = append(args, q.ParentID)
+		where = append(where, "comments.parent_id = $1", "comments.deleted_at IS NULL")
+	case q.PhotoID != "":
+		args = append(args, q.PhotoID)
+		where = append(where, "comments.photo_id = $1", "comments.parent_id IS NULL")
+	default:
+		args = append(args, q.EventID)
+		where = append(where, "comments.event_id = $1", "comments.photo_id IS NULL", "comments.parent_id IS NULL")
+	}
+	if q.ParentID == "" {
+		// Deleted threads disappear with their last reply
+		where = append(where, `(comments.deleted_at IS NULL OR EXISTS (
+			SELECT 1 FROM comments AS replies WHERE replies.parent_id = comments.id AND replies.deleted_at IS NULL))`)
+	}
+	if q.Cursor != "" {
+		createdAt, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(comments.created_at, comments.id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, q.Limit+1)
+
+	query := fmt.Sprintf("%s WHERE %s ORDER BY comments.created_at, comments.id LIMIT $%d",
+		commentSelect, strings.Join(where, " AND "), len(args))
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[ListComments] %v", err)
+	}
+	defer rows.Close()
+
+	page := &CommentPage{Comments: []Comment{}}
+	for rows.Next() {
+		comment, err := scanComment(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("[ListCommentsScan] %v", err)
+		}
+		if len(page.Comments) == q.Limit {
+			last := page.Comments[len(page.Comments)-1]
+			page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+			break
+		}
+		page.Comments = append(page.Comments, *comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListComments] %v", err)
+	}
+	rows.Close()
+
+	comments := make([]*Comment, 0, len(page.Comments))
+	for i := range page.Comments {
+		comments = append(comments, &page.Comments[i])
+	}
+	if err := s.loadMentions(comments); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (s *service) loadMentions(comments []*Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	byId := make(map[string]*Comment, len(comments))
+	ids := make([]string, 0, len(comments))
+	for _, c := range comments {
+		byId[c.ID] = c
+		ids = append(ids, c.ID)
+	}
+
+	rows, err := s.q.Query(
+		`SELECT comment_mentions.comment_id, users.id, users.oauth_id, users.name, users.avatar_url, users.email
+		FROM comment_mentions
+		JOIN users ON users.id = comment_mentions.user_id
+		WHERE comment_mentions.comment_id = ANY($1::uuid[])
+		ORDER BY users.name, users.id`,
+		ids,
+	)
+	if err != nil {
+		return fmt.Errorf("[loadMentions] %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commentId string
+		var user User
+		if err := rows.Scan(&commentId, &user.ID, &user.OAuthId, &user.Name, &user.AvatarUrl, &user.Email); err != nil {
+			return fmt.Errorf("[loadMentionsScan] %v", err)
+		}
+		byId[commentId].Mentions = append(byId[commentId].Mentions, user)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("[loadMentions] %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	const id = "0190a0b2-3c4d-7e5f-8a9b-0c1d2e3f4a5b"
+	tests := []time.Time{
+		time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		time.Unix(0, 0),
+		time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC),
+	}
+	for _, sortKey := range tests {
+		cursor := encodeCursor(sortKey, id)
+		gotKey, gotId, err := decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", cursor, err)
+		}
+		if !gotKey.Equal(sortKey) || gotId != id {
+			t.Errorf("decodeCursor(encodeCursor(%v)) = %v, %q", sortKey, gotKey, gotId)
+		}
+	}
+}
+
+func TestPositionCursor(t *testing.T) {
+	const id = "0190a0b2-3c4d-7e5f-8a9b-0c1d2e3f4a5b"
+	for _, position := range []int64{0, 1, -1, 1 << 40, math.MaxInt64, math.MinInt64} {
+		cursor := encodePositionCursor(position, id)
+		gotPosition, gotId, err := decodePositionCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodePositionCursor(%q): %v", cursor, err)
+		}
+		if gotPosition != position || gotId != id {
+			t.Errorf("decodePositionCursor(encodePositionCursor(%d)) = %d, %q", position, gotPosition, gotId)
+		}
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := map[string]string{
+		"empty":            "",
+		"not base64":       "not a cursor!",
+		"padded base64":    base64.URLEncoding.EncodeToString([]byte("1:0190a0b2-3c4d-7e5f-8a9b-0c1d2e3f4a5b")),
+		"no separator":     encode("12345"),
+		"sort key not int": encode("x:0190a0b2-3c4d-7e5f-8a9b-0c1d2e3f4a5b"),
+		"sort key too big": encode("99999999999999999999:0190a0b2-3c4d-7e5f-8a9b-0c1d2e3f4a5b"),
+		"id not a uuid":    encode("1:1' OR '1'='1"),
+		"no id":            encode("1:"),
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodePositionCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodePositionCursor err = %v, want ErrInvalidCursor", err)
+			}
+			if _, _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
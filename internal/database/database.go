@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"maps"
@@ -16,12 +17,46 @@ import (
 )
 
 type Photo struct {
-	ID        string `json:"id"`
-	PublicUrl string `json:"public_url"`
-	FileName  string `json:"file_name"`
-	FileType  string `json:"file_type"`
-	CreatedBy string `json:"created_by"`
-	EventID   string `json:"event_id"`
+	ID         string     `json:"id"`
+	PublicUrl  string     `json:"public_url"`
+	FileName   string     `json:"file_name"`
+	FileType   string     `json:"file_type"`
+	MediaType  MediaType  `json:"media_type"`
+	CreatedBy  string     `json:"created_by"`
+	EventID    string     `json:"event_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CapturedAt *time.Time `json:"captured_at"`
+	// Set once the capture time was fixed by hand, processing keeps it then
+	CapturedAtEdited bool   `json:"captured_at_edited"`
+	Caption          string `json:"caption"`
+	// Describes the photo for screen readers
+	AltText string `json:"alt_text"`
+	// Rendition name => object key, see imaging.Renditions. Responses carry
+	// signed URLs instead, like PublicUrl.
+	Renditions  map[string]string `json:"renditions"`
+	CameraModel string            `json:"camera_model"`
+	// EXIF orientation of the original, renditions are already upright
+	Orientation int      `json:"orientation"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	// Upright dimensions of the original, unknown until it is processed
+	Width  *int `json:"width"`
+	Height *int `json:"height"`
+	// Of videos, in milliseconds, unknown until processed
+	DurationMs *int `json:"duration_ms"`
+	// Hex SHA-256 of the uploaded bytes
+	SHA256 string `json:"sha256"`
+	// Perceptual hash, see imaging.DHash
+	DHash *int64 `json:"-"`
+	// When the signed URLs of the response stop working
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+	// Set while the photo is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+	// Filled in for listings, see CountPhotoReactions
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	// Filled in for listings, see ListPhotoTags
+	Tags []PhotoTag `json:"tags,omitempty"`
 }
 
 type InviteStatus string
@@ -42,15 +77,28 @@ type Invite struct {
 }
 
 type Event struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	OwnerID   string    `json:"owner_id"`
-	ImageURL  string    `json:"image_url"`
-	Owner     User      `json:"owner"`
-	Likes     []Like    `json:"likes"`
-	Members   []User    `json:"members"`
-	Photos    []Photo   `json:"photos"`
+	ID              string          `json:"id"`
+	Name            string          `json:"name"`
+	CreatedAt       time.Time       `json:"created_at"`
+	OwnerID         string          `json:"owner_id"`
+	ImageURL        string          `json:"image_url"`
+	ArchivedAt      *time.Time      `json:"archived_at"`
+	Description     string          `json:"description"`
+	StartsAt        *time.Time      `json:"starts_at"`
+	EndsAt          *time.Time      `json:"ends_at"`
+	Timezone        string          `json:"timezone"`
+	VenueName       string          `json:"venue_name"`
+	Latitude        *float64        `json:"latitude"`
+	Longitude       *float64        `json:"longitude"`
+	MetadataPrivacy MetadataPrivacy `json:"metadata_privacy"`
+	CoverPhotoID    *string         `json:"cover_photo_id"`
+	// Video limits of the event, in seconds and bytes; nil for the server's
+	MaxVideoDuration *int    `json:"max_video_duration"`
+	MaxVideoSize     *int64  `json:"max_video_size"`
+	Owner            User    `json:"owner"`
+	Likes            []Like  `json:"likes"`
+	Members          []User  `json:"members"`
+	Photos           []Photo `json:"photos"`
 }
 
 type Like struct {
@@ -60,6 +108,13 @@ type Like struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// LikeState is the caller's like of an event after a like/dislike, along
+// with the resulting like count.
+type LikeState struct {
+	Liked bool `json:"liked"`
+	Count int  `json:"count"`
+}
+
 type User struct {
 	ID        string `json:"id"`
 	OAuthId   string `json:"oauth_id"`
@@ -72,21 +127,94 @@ type User struct {
 // Service represents a service that interacts with a database.
 type Service interface {
 	CreatePhoto(photo *Photo) error
-	AddEventMember(userId string, eventId string) string
-	LikeEvent(userId string, eventId string) string
-	DislikeEvent(userId string, eventId string) string
+	GetPhoto(photoId string) (*Photo, error)
+	SetPhotoRenditions(photoId string, renditions map[string]string) error
+	SetPhotoMetadata(photoId string, meta PhotoMetadata) error
+	UpdatePhoto(photoId string, update PhotoUpdate) error
+	FindPhotoBySHA256(eventId string, sha256 string) (*Photo, error)
+	ListSimilarPhotos(photoId string, maxDistance int, limit int) ([]SimilarPhoto, error)
+	ListEventPhotos(query PhotoQuery) (*PhotoPage, error)
+	TrashPhoto(photoId string, userId string) error
+	RestorePhoto(photoId string) error
+	ListTrashedPhotos(eventId string) ([]TrashedPhoto, error)
+	PurgeTrashedPhotos(before time.Time, limit int) ([]string, error)
+	AddPhotoReaction(photoId string, userId string, emoji string) error
+	RemovePhotoReaction(photoId string, userId string, emoji string) error
+	CountPhotoReactions(userId string, photoIds []string) (map[string][]ReactionCount, error)
+	CountEventReactions(userId string, eventIds []string) (map[string][]ReactionCount, error)
+	ListPhotoReactions(photoId string, emoji string) ([]Reaction, error)
+	CreateComment(comment *Comment) error
+	GetComment(commentId string) (*Comment, error)
+	UpdateComment(commentId string, body string) error
+	DeleteComment(commentId string, userId string) error
+	SetCommentMentions(commentId string, eventId string, userIds []string) error
+	ListComments(query CommentQuery) (*CommentPage, error)
+	TagPhoto(tag *PhotoTag) (bool, error)
+	UntagPhoto(photoId string, userId string) error
+	ListPhotoTags(photoIds []string) (map[string][]PhotoTag, error)
+	ListUserPhotos(query UserPhotoQuery) (*PhotoPage, error)
+	CreateNotification(notification *Notification) error
+	ListNotifications(userId string, cursor string, limit int) (*NotificationPage, error)
+	MarkNotificationsRead(userId string, ids []string) error
+	CreateExport(export *Export) error
+	GetExport(exportId string) (*Export, error)
+	StartExport(exportId string) error
+	CompleteExport(exportId string, photoCount int, size int64) error
+	FailExport(exportId string, cause string) error
+	DeleteExports(eventId string, albumId string) ([]string, error)
+	PurgeExpiredExports(before time.Time, limit int) ([]string, error)
+	RecordOrphanedFiles(keys []string) error
+	ListOrphanedFiles(limit int) ([]string, error)
+	ForgetOrphanedFiles(keys []string) error
+	CreateAlbum(album *Album) error
+	GetAlbum(albumId string) (*Album, error)
+	ListEventAlbums(eventId string) ([]Album, error)
+	UpdateAlbum(albumId string, update AlbumUpdate) error
+	DeleteAlbum(albumId string) error
+	OrderEventAlbums(eventId string, albumIds []string) error
+	AddAlbumPhotos(albumId string, userId string, photoIds []string) error
+	RemoveAlbumPhotos(albumId string, photoIds []string) error
+	OrderAlbumPhotos(albumId string, photoIds []string) error
+	ListAlbumPhotos(query AlbumPhotoQuery) (*PhotoPage, error)
+	AddEventMember(userId string, eventId string) error
+	GetEventAccess(userId string, eventId string) (*EventAccess, error)
+	SetMemberRole(eventId string, userId string, role MemberRole) error
+	ListEventCohosts(eventId string) ([]User, error)
+	GetEventMediaSettings(eventId string) (*EventMediaSettings, error)
+	CheckEventWritable(eventId string) error
+	LikeEvent(userId string, eventId string) (*LikeState, error)
+	DislikeEvent(userId string, eventId string) (*LikeState, error)
 	GetUserEvents(userId string) []*Event
 	GetEvent(eventId string) (*Event, error)
-	CreateEvent(einfo Event) string
-	GetOrCreateUser(uinfo User) map[string]string
+	CreateEvent(einfo Event) (string, error)
+	UpdateEvent(eventId string, update EventUpdate) error
+	SetEventArchived(eventId string, archived bool) error
+	DeleteEvent(eventId string) ([]string, error)
+	GetOrCreateUser(uinfo User) (map[string]string, error)
+	ListUsers(userIds []string) (map[string]User, error)
+	WithTx(ctx context.Context, fn func(tx Service) error) error
 	Health() map[string]string
+	SchemaVersion() (uint, bool, error)
+	CheckSchemaVersion() error
+	MigrateUp() error
+	MigrateDown(steps int) error
+	MigrationStatus() ([]MigrationStatus, error)
 	Close() error
 }
 
 type service struct {
 	db *sql.DB
+	q  querier
 }
 
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrEventArchived = errors.New("event is archived")
+	ErrInvalidEvent  = errors.New("invalid event details")
+	// An identical photo is already part of the event
+	ErrDuplicatePhoto = errors.New("photo already uploaded to this event")
+)
+
 var (
 	database   = os.Getenv("DB_DATABASE")
 	password   = os.Getenv("DB_PASSWORD")
@@ -109,31 +237,41 @@ func New() Service {
 	}
 	dbInstance = &service{
 		db: db,
+		q:  db,
 	}
 	return dbInstance
 }
 
 func (s *service) CreatePhoto(photo *Photo) error {
-	_, err := s.db.Query("INSERT INTO photos (id, public_url, created_by, file_name, file_type, event_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *",
+	photo.MediaType = MediaTypeOf(photo.FileType)
+	_, err := s.q.Exec("INSERT INTO photos (id, public_url, created_by, file_name, file_type, event_id, captured_at, sha256, media_type, duration_ms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
 		photo.ID,
 		photo.PublicUrl,
 		photo.CreatedBy,
 		photo.FileName,
 		photo.FileType,
 		photo.EventID,
+		photo.CapturedAt,
+		sql.NullString{String: photo.SHA256, Valid: photo.SHA256 != ""},
+		photo.MediaType,
+		photo.DurationMs,
 	)
+	// The ID is taken when the same upload was recorded already
+	if isUniqueViolation(err, "photos_event_sha256_key") || isUniqueViolation(err, "photos_pkey") {
+		return ErrDuplicatePhoto
+	}
 	if err != nil {
 		return fmt.Errorf("[CreatePhoto] %v", err)
 	}
 	return nil
 }
 
-func (s *service) AddEventMember(userId string, eventId string) string {
-	_, err := s.db.Query("INSERT INTO members (user_id, event_id) VALUES ($1, $2)", userId, eventId)
+func (s *service) AddEventMember(userId string, eventId string) error {
+	_, err := s.q.Exec("INSERT INTO members (user_id, event_id) VALUES ($1, $2) ON CONFLICT (user_id, event_id) DO NOTHING", userId, eventId)
 	if err != nil {
-		log.Fatalf("[AddEventMember] %v", err)
+		return fmt.Errorf("[AddEventMember] %v", err)
 	}
-	return "success"
+	return nil
 }
 
 // func (s *service) CreateEventInvite(eventId string, createdBy string) string {
@@ -149,24 +287,37 @@ func (s *service) AddEventMember(userId string, eventId string) string {
 // 	return id
 // }
 
-func (s *service) LikeEvent(userId string, eventId string) string {
-	_, err := s.db.Query("INSERT INTO likes (user_id, event_id) VALUES ($1, $2)", userId, eventId)
+func (s *service) LikeEvent(userId string, eventId string) (*LikeState, error) {
+	_, err := s.q.Exec("INSERT INTO likes (user_id, event_id) VALUES ($1, $2) ON CONFLICT (user_id, event_id) DO NOTHING", userId, eventId)
+	if err != nil {
+		return nil, fmt.Errorf("[LikeEvent] %v", err)
+	}
+	return s.likeState(userId, eventId)
+}
+
+func (s *service) DislikeEvent(userId string, eventId string) (*LikeState, error) {
+	_, err := s.q.Exec("DELETE FROM likes WHERE user_id = $1 AND event_id = $2;", userId, eventId)
 	if err != nil {
-		log.Fatalf("[LikeEvent] %v", err)
+		return nil, fmt.Errorf("[DislikeEvent] %v", err)
 	}
-	return "success"
+	return s.likeState(userId, eventId)
 }
 
-func (s *service) DislikeEvent(userId string, eventId string) string {
-	_, err := s.db.Query("DELETE FROM likes WHERE user_id = $1 AND event_id = $2;", userId, eventId)
+func (s *service) likeState(userId string, eventId string) (*LikeState, error) {
+	state := &LikeState{}
+	err := s.q.QueryRow(
+		"SELECT count(*), COALESCE(bool_or(user_id = $1), false) FROM likes WHERE event_id = $2",
+		userId,
+		eventId,
+	).Scan(&state.Count, &state.Liked)
 	if err != nil {
-		log.Fatalf("[DislikeEvent] %v", err)
+		return nil, fmt.Errorf("[likeState] %v", err)
 	}
-	return "success"
+	return state, nil
 }
 
 func (s *service) GetUserEvents(userId string) []*Event {
-	rows, err := s.db.Query("SELECT * FROM public.get_events($1)", userId)
+	rows, err := s.q.Query("SELECT * FROM public.get_events($1)", userId)
 	if err != nil {
 		log.Fatalf("[GetUserEvents] %v", err)
 	}
@@ -185,7 +336,7 @@ func (s *service) GetUserEvents(userId string) []*Event {
 }
 
 func (s *service) GetEvent(eventId string) (*Event, error) {
-	rows, err := s.db.Query("SELECT * FROM public.get_event($1)", eventId)
+	rows, err := s.q.Query("SELECT * FROM public.get_event($1)", eventId)
 	if err != nil {
 		log.Fatalf("[GetEvent] %v", err)
 	}
@@ -202,23 +353,46 @@ func (s *service) GetEvent(eventId string) (*Event, error) {
 	return nil, fmt.Errorf("event with ID %s not found", eventId)
 }
 
-func (s *service) CreateEvent(einfo Event) string {
+// CreateEvent only inserts the event row; callers add the owner as a member
+// in the same transaction.
+func (s *service) CreateEvent(einfo Event) (string, error) {
 	var id string
-	err := s.db.QueryRow("SELECT * FROM public.create_event($1, $2)",
+	if einfo.Timezone == "" {
+		einfo.Timezone = "UTC"
+	}
+	if einfo.MetadataPrivacy == "" {
+		einfo.MetadataPrivacy = MetadataKeep
+	}
+	err := s.q.QueryRow(
+		`INSERT INTO events (id, name, created_at, owner, image_url, description, starts_at, ends_at, timezone, venue_name, latitude, longitude, metadata_privacy,
+			max_video_duration, max_video_size)
+		VALUES (uuidv7(), $1, now(), $2, '', $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
 		einfo.Name,
 		einfo.OwnerID,
+		einfo.Description,
+		einfo.StartsAt,
+		einfo.EndsAt,
+		einfo.Timezone,
+		einfo.VenueName,
+		einfo.Latitude,
+		einfo.Longitude,
+		einfo.MetadataPrivacy,
+		einfo.MaxVideoDuration,
+		einfo.MaxVideoSize,
 	).Scan(&id)
 
+	if isCheckViolation(err) {
+		return "", fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
 	if err != nil {
-		log.Fatalf("[CreateEvent] %v", err)
+		return "", fmt.Errorf("[CreateEvent] %v", err)
 	}
-	return id
+	return id, nil
 }
 
-// TODO: Refactor, check CreatePhoto
-func (s *service) GetOrCreateUser(uinfo User) map[string]string {
+func (s *service) GetOrCreateUser(uinfo User) (map[string]string, error) {
 	var id, name, avatarUrl string
-	err := s.db.QueryRow(
+	err := s.q.QueryRow(
 		"SELECT * FROM public.get_or_create_user($1, $2, $3, $4)",
 		uinfo.OAuthId,
 		uinfo.Name,
@@ -227,14 +401,39 @@ func (s *service) GetOrCreateUser(uinfo User) map[string]string {
 	).Scan(&id, &name, &avatarUrl)
 
 	if err != nil {
-		log.Fatalf("[GetOrCreateUser] %v", err)
+		return nil, fmt.Errorf("[GetOrCreateUser] %v", err)
 	}
 
 	data := make(map[string]string)
 	data["id"] = id
 	data["name"] = name
 	data["avatar_url"] = avatarUrl
-	return data
+	return data, nil
+}
+
+// ListUsers returns users by ID, unknown IDs are left out.
+func (s *service) ListUsers(userIds []string) (map[string]User, error) {
+	rows, err := s.q.Query(
+		"SELECT id, oauth_id, name, avatar_url, email FROM users WHERE id = ANY($1::uuid[])",
+		userIds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListUsers] %v", err)
+	}
+	defer rows.Close()
+
+	users := make(map[string]User, len(userIds))
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.OAuthId, &u.Name, &u.AvatarUrl, &u.Email); err != nil {
+			return nil, fmt.Errorf("[ListUsersScan] %v", err)
+		}
+		users[u.ID] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListUsers] %v", err)
+	}
+	return users, nil
 }
 
 func (s *service) Health() map[string]string {
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// EventAccess is what route guards need to know about an event and the
+// requesting user.
+type EventAccess struct {
+	EventID  string
+	OwnerID  string
+	Archived bool
+	IsMember bool
+	IsCohost bool
+}
+
+func (a *EventAccess) IsOwner(userId string) bool {
+	return a.OwnerID == userId
+}
+
+// CanCurate tells whether the user may organize the event's albums.
+func (a *EventAccess) CanCurate(userId string) bool {
+	return a.IsOwner(userId) || a.IsCohost
+}
+
+type MemberRole string
+
+const (
+	RoleMember MemberRole = "member"
+	// Co-hosts curate the event along with its owner
+	RoleCohost MemberRole = "cohost"
+)
+
+func (r MemberRole) Valid() bool {
+	return r == RoleMember || r == RoleCohost
+}
+
+type MetadataPrivacy string
+
+// What is stripped from the metadata of stored originals. The capture time
+// is read before stripping, so sorting by it keeps working. Originals are
+// stripped before their photo is recorded, files that can't be stripped are
+// refused. Processing strips them again, the setting may have changed.
+const (
+	MetadataKeep          MetadataPrivacy = "keep"
+	MetadataStripLocation MetadataPrivacy = "strip_location"
+	MetadataStripAll      MetadataPrivacy = "strip_all"
+)
+
+func (p MetadataPrivacy) Valid() bool {
+	return p == MetadataKeep || p == MetadataStripLocation || p == MetadataStripAll
+}
+
+// EventMediaSettings are the event settings that govern processing uploads.
+type EventMediaSettings struct {
+	Timezone        string
+	MetadataPrivacy MetadataPrivacy
+	// Limits the event sets on videos, zero for the server's
+	MaxVideoDuration time.Duration
+	MaxVideoSize     int64
+}
+
+// EventUpdate holds the fields an owner may change; nil fields are left
+// untouched.
+type EventUpdate struct {
+	Name            *string
+	ImageURL        *string
+	Description     *string
+	StartsAt        *time.Time
+	EndsAt          *time.Time
+	Timezone        *string
+	VenueName       *string
+	Latitude        *float64
+	Longitude       *float64
+	MetadataPrivacy *MetadataPrivacy
+	// In seconds and bytes
+	MaxVideoDuration *int
+	MaxVideoSize     *int64
+	// A cover photo replaces the image URL and the other way round
+	CoverPhotoID *string
+	// Set to clear the dates, or the latitude and longitude, of the event
+	ClearStartsAt bool
+	ClearEndsAt   bool
+	ClearLocation bool
+}
+
+func (s *service) GetEventAccess(userId string, eventId string) (*EventAccess, error) {
+	access := &EventAccess{EventID: eventId}
+	err := s.q.QueryRow(
+		`SELECT owner, archived_at IS NOT NULL,
+			EXISTS (SELECT 1 FROM members WHERE members.event_id = events.id AND members.user_id = $1),
+			EXISTS (SELECT 1 FROM members WHERE members.event_id = events.id AND members.user_id = $1 AND members.role = 'cohost')
+		FROM events WHERE id = $2`,
+		userId,
+		eventId,
+	).Scan(&access.OwnerID, &access.Archived, &access.IsMember, &access.IsCohost)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetEventAccess] %v", err)
+	}
+	return access, nil
+}
+
+// SetMemberRole changes the role of a member. It returns ErrNotFound when
+// the user is not a member of the event.
+func (s *service) SetMemberRole(eventId string, userId string, role MemberRole) error {
+	res, err := s.q.Exec("UPDATE members SET role = $3 WHERE event_id = $1 AND user_id = $2", eventId, userId, role)
+	if err != nil {
+		return fmt.Errorf("[SetMemberRole] %v", err)
+	}
+	return expectAffected(res)
+}
+
+func (s *service) ListEventCohosts(eventId string) ([]User, error) {
+	rows, err := s.q.Query(
+		`SELECT users.id, users.oauth_id, users.name, users.avatar_url, users.email
+		FROM members
+		JOIN users ON users.id = members.user_id
+		WHERE members.event_id = $1 AND members.role = 'cohost'
+		ORDER BY members.created_at, users.id`,
+		eventId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListEventCohosts] %v", err)
+	}
+	defer rows.Close()
+
+	cohosts := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.OAuthId, &u.Name, &u.AvatarUrl, &u.Email); err != nil {
+			return nil, fmt.Errorf("[ListEventCohostsScan] %v", err)
+		}
+		cohosts = append(cohosts, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListEventCohosts] %v", err)
+	}
+	return cohosts, nil
+}
+
+func (s *service) GetEventMediaSettings(eventId string) (*EventMediaSettings, error) {
+	var settings EventMediaSettings
+	var maxVideoDuration, maxVideoSize sql.NullInt64
+	err := s.q.QueryRow("SELECT timezone, metadata_privacy, max_video_duration, max_video_size FROM events WHERE id = $1", eventId).
+		Scan(&settings.Timezone, &settings.MetadataPrivacy, &maxVideoDuration, &maxVideoSize)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetEventMediaSettings] %v", err)
+	}
+	settings.MaxVideoDuration = time.Duration(maxVideoDuration.Int64) * time.Second
+	settings.MaxVideoSize = maxVideoSize.Int64
+	return &settings, nil
+}
+
+// CheckEventWritable returns ErrNotFound or ErrEventArchived unless the event
+// accepts changes.
+func (s *service) CheckEventWritable(eventId string) error {
+	var archived bool
+	err := s.q.QueryRow("SELECT archived_at IS NOT NULL FROM events WHERE id = $1", eventId).Scan(&archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("[CheckEventWritable] %v", err)
+	}
+	if archived {
+		return ErrEventArchived
+	}
+	return nil
+}
+
+func (s *service) UpdateEvent(eventId string, update EventUpdate) error {
+	res, err := s.q.Exec(
+		`UPDATE events SET
+			name = COALESCE($2, name),
+			image_url = CASE WHEN $12::uuid IS NOT NULL THEN '' ELSE COALESCE($3, image_url) END,
+			cover_photo_id = CASE WHEN $3::text IS NOT NULL THEN NULL ELSE COALESCE($12, cover_photo_id) END,
+			description = COALESCE($4, description),
+			starts_at = CASE WHEN $15 THEN NULL ELSE COALESCE($5, starts_at) END,
+			ends_at = CASE WHEN $16 THEN NULL ELSE COALESCE($6, ends_at) END,
+			timezone = COALESCE($7, timezone),
+			venue_name = COALESCE($8, venue_name),
+			latitude = CASE WHEN $17 THEN NULL ELSE COALESCE($9, latitude) END,
+			longitude = CASE WHEN $17 THEN NULL ELSE COALESCE($10, longitude) END,
+			metadata_privacy = COALESCE($11, metadata_privacy),
+			max_video_duration = COALESCE($13, max_video_duration),
+			max_video_size = COALESCE($14, max_video_size)
+		WHERE id = $1`,
+		eventId,
+		update.Name,
+		update.ImageURL,
+		update.Description,
+		update.StartsAt,
+		update.EndsAt,
+		update.Timezone,
+		update.VenueName,
+		update.Latitude,
+		update.Longitude,
+		update.MetadataPrivacy,
+		update.CoverPhotoID,
+		update.MaxVideoDuration,
+		update.MaxVideoSize,
+		update.ClearStartsAt,
+		update.ClearEndsAt,
+		update.ClearLocation,
+	)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[UpdateEvent] %v", err)
+	}
+	return expectAffected(res)
+}
+
+func (s *service) SetEventArchived(eventId string, archived bool) error {
+	query := "UPDATE events SET archived_at = COALESCE(archived_at, now()) WHERE id = $1"
+	if !archived {
+		query = "UPDATE events SET archived_at = NULL WHERE id = $1"
+	}
+	res, err := s.q.Exec(query, eventId)
+	if err != nil {
+		return fmt.Errorf("[SetEventArchived] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// DeleteEvent removes the event with everything referencing it and returns
+// the storage keys of its photos, which the caller has to clean up once the
+// surrounding transaction is committed.
+func (s *service) DeleteEvent(eventId string) ([]string, error) {
+	rows, err := s.q.Query("DELETE FROM photos WHERE event_id = $1 RETURNING id", eventId)
+	if err != nil {
+		return nil, fmt.Errorf("[DeleteEvent] %v", err)
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("[DeleteEventScan] %v", err)
+		}
+		keys = append(keys, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[DeleteEvent] %v", err)
+	}
+	rows.Close()
+
+	res, err := s.q.Exec("DELETE FROM events WHERE id = $1", eventId)
+	if err != nil {
+		return nil, fmt.Errorf("[DeleteEvent] %v", err)
+	}
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func isCheckViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23514" // check_violation
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint // unique_violation
+}
+
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
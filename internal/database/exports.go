@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportRunning ExportStatus = "running"
+	ExportDone    ExportStatus = "done"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// ExportRetention is how long a built archive can be downloaded.
+const ExportRetention = 7 * 24 * time.Hour
+
+// Export is a ZIP archive of the photos of an event, or of one of its
+// albums when AlbumID is set, built by the worker.
+type Export struct {
+	ID          string       `json:"id"`
+	EventID     string       `json:"event_id"`
+	AlbumID     *string      `json:"album_id"`
+	RequestedBy string       `json:"requested_by"`
+	Status      ExportStatus `json:"status"`
+	PhotoCount  int          `json:"photo_count"`
+	Size        int64        `json:"size"`
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at"`
+	ExpiresAt   *time.Time   `json:"expires_at"`
+	// Signed URL of the archive once it is built
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func (s *service) CreateExport(export *Export) error {
+	err := s.q.QueryRow(
+		`INSERT INTO exports (event_id, album_id, requested_by)
+		VALUES ($1, $2, $3) RETURNING id, status, created_at`,
+		export.EventID,
+		export.AlbumID,
+		export.RequestedBy,
+	).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("[CreateExport] %v", err)
+	}
+	return nil
+}
+
+func (s *service) GetExport(exportId string) (*Export, error) {
+	var e Export
+	var albumId, requestedBy sql.NullString
+	var completedAt, expiresAt sql.NullTime
+	err := s.q.QueryRow(
+		`SELECT id, event_id, album_id, requested_by, status, photo_count, size, error, created_at, completed_at, expires_at
+		FROM exports WHERE id = $1`,
+		exportId,
+	).Scan(&e.ID, &e.EventID, &albumId, &requestedBy, &e.Status, &e.PhotoCount, &e.Size, &e.Error,
+		&e.CreatedAt, &completedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetExport] %v", err)
+	}
+	if albumId.Valid {
+		e.AlbumID = &albumId.String
+	}
+	e.RequestedBy = requestedBy.String
+	if completedAt.Valid {
+		e.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		e.ExpiresAt = &expiresAt.Time
+	}
+	return &e, nil
+}
+
+// StartExport marks an export as being built, again when a failed build is
+// retried. It returns ErrNotFound for deleted or already built exports.
+func (s *service) StartExport(exportId string) error {
+	res, err := s.q.Exec(
+		"UPDATE exports SET status = 'running', error = '', expires_at = NULL WHERE id = $1 AND status <> 'done'",
+		exportId,
+	)
+	if err != nil {
+		return fmt.Errorf("[StartExport] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// CompleteExport records a built archive, downloadable for ExportRetention.
+// It returns ErrNotFound when the export was deleted in the meantime.
+func (s *service) CompleteExport(exportId string, photoCount int, size int64) error {
+	res, err := s.q.Exec(
+		`UPDATE exports SET status = 'done', photo_count = $2, size = $3,
+			completed_at = now(), expires_at = now() + $4 * interval '1 second'
+		WHERE id = $1`,
+		exportId,
+		photoCount,
+		size,
+		int64(ExportRetention/time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("[CompleteExport] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// FailExport records why building an export failed. Failed exports expire
+// like built ones unless they are retried.
+func (s *service) FailExport(exportId string, cause string) error {
+	_, err := s.q.Exec(
+		"UPDATE exports SET status = 'failed', error = $2, expires_at = now() + $3 * interval '1 second' WHERE id = $1",
+		exportId,
+		cause,
+		int64(ExportRetention/time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("[FailExport] %v", err)
+	}
+	return nil
+}
+
+// DeleteExports deletes the exports of an event, or of one of its albums
+// when albumId is set, and returns their IDs, whose archives the caller has
+// to delete.
+func (s *service) DeleteExports(eventId string, albumId string) ([]string, error) {
+	return s.deleteExports(
+		"DeleteExports",
+		"DELETE FROM exports WHERE event_id = $1 AND ($2 = '' OR album_id::text = $2) RETURNING id",
+		eventId,
+		albumId,
+	)
+}
+
+// PurgeExpiredExports deletes up to limit exports past their expiry and
+// returns their IDs, whose archives the caller has to delete.
+func (s *service) PurgeExpiredExports(before time.Time, limit int) ([]string, error) {
+	return s.deleteExports(
+		"PurgeExpiredExports",
+		`DELETE FROM exports WHERE id IN (
+			SELECT id FROM exports
+			WHERE expires_at < $1
+			ORDER BY expires_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) RETURNING id`,
+		before,
+		limit,
+	)
+}
+
+func (s *service) deleteExports(method string, query string, args ...any) ([]string, error) {
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %v", method, err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("[%sScan] %v", method, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s] %v", method, err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Migrations use the golang-migrate file layout and bookkeeping table, so a
+// database migrated with the `migrate` CLI (see Makefile) is understood by
+// the embedded runner and the other way around.
+//
+//go:embed *.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for pg_advisory_lock; it only has to be unique within the
+// database so that replicas starting at the same time run migrations one
+// after another.
+const migrationLockKey = 7_361_046_208_113_517_825
+
+var ErrSchemaDirty = errors.New("database schema is dirty")
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := cutMigrationName(name)
+		if !ok {
+			continue
+		}
+		version, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q", name)
+		}
+		body, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[uint(v)]
+		if !exists {
+			m = &Migration{Version: uint(v), Name: title}
+			byVersion[uint(v)] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return int(a.Version) - int(b.Version)
+	})
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version the embedded migrations bring the
+// database to.
+func LatestSchemaVersion() (uint, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func cutMigrationName(name string) (string, string, bool) {
+	if base, ok := strings.CutSuffix(name, ".up.sql"); ok {
+		return base, "up", true
+	}
+	if base, ok := strings.CutSuffix(name, ".down.sql"); ok {
+		return base, "down", true
+	}
+	return "", "", false
+}
+
+func (s *service) SchemaVersion() (uint, bool, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("[SchemaVersion] %v", err)
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return 0, false, fmt.Errorf("[SchemaVersion] %v", err)
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return 0, false, fmt.Errorf("[SchemaVersion] %v", err)
+	}
+	return version, dirty, nil
+}
+
+// CheckSchemaVersion fails unless the database is exactly at the version of
+// the embedded migrations.
+func (s *service) CheckSchemaVersion() error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+	}
+	if version != latest {
+		return fmt.Errorf("database schema is at version %d, expected %d", version, latest)
+	}
+	return nil
+}
+
+func (s *service) MigrateUp() error {
+	return s.withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, err := Migrations()
+		if err != nil {
+			return err
+		}
+		version, dirty, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+		}
+
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+			log.Printf("Applying migration %d_%s", m.Version, m.Name)
+			if err := applyMigration(ctx, conn, m.Up, m.Version, true); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the last `steps` applied migrations.
+func (s *service) MigrateDown(steps int) error {
+	return s.withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, err := Migrations()
+		if err != nil {
+			return err
+		}
+		version, dirty, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if m.Version > version {
+				continue
+			}
+			var previous uint
+			if i > 0 {
+				previous = migrations[i-1].Version
+			}
+			log.Printf("Reverting migration %d_%s", m.Version, m.Name)
+			if err := applyMigration(ctx, conn, m.Down, previous, previous > 0); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			version = previous
+			steps--
+		}
+		return nil
+	})
+}
+
+func (s *service) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	version, _, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{
+			Version: m.Version,
+			Name:    m.Name,
+			Applied: m.Version <= version,
+		})
+	}
+	return status, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, so concurrent replicas wait for each other.
+func (s *service) withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("[Migrate] %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(migrationLockKey)); err != nil {
+		return fmt.Errorf("[MigrateLock] %v", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", int64(migrationLockKey))
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return fmt.Errorf("[Migrate] %v", err)
+	}
+	return fn(ctx, conn)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)",
+	)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(version), dirty, nil
+}
+
+// applyMigration runs a migration body and records the resulting version in
+// one transaction, so a failed migration leaves the schema untouched.
+func applyMigration(ctx context.Context, conn *sql.Conn, body string, version uint, record bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(body) != "" {
+		if _, err := tx.ExecContext(ctx, body); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "TRUNCATE schema_migrations"); err != nil {
+		return err
+	}
+	if record {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", int64(version),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	// Actor tagged the user in a photo
+	NotificationPhotoTag NotificationType = "photo_tag"
+)
+
+const (
+	DefaultNotificationPageSize = 50
+	MaxNotificationPageSize     = 100
+)
+
+// Notification tells a user that Actor did something involving them, on
+// the event and photo it refers to.
+type Notification struct {
+	ID        string           `json:"id"`
+	UserID    string           `json:"user_id"`
+	Type      NotificationType `json:"type"`
+	Actor     *User            `json:"actor"`
+	EventID   *string          `json:"event_id"`
+	PhotoID   *string          `json:"photo_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	ReadAt    *time.Time       `json:"read_at"`
+}
+
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor"`
+	Unread        int            `json:"unread"`
+}
+
+func (s *service) CreateNotification(n *Notification) error {
+	var actorId *string
+	if n.Actor != nil {
+		actorId = &n.Actor.ID
+	}
+	err := s.q.QueryRow(
+		`INSERT INTO notifications (user_id, type, actor_id, event_id, photo_id)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		n.UserID,
+		n.Type,
+		actorId,
+		n.EventID,
+		n.PhotoID,
+	).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("[CreateNotification] %v", err)
+	}
+	return nil
+}
+
+// ListNotifications returns a page of a user's notifications, newest
+// first, with how many of all of them are unread.
+func (s *service) ListNotifications(userId string, cursor string, limit int) (*NotificationPage, error) {
+	if limit <= 0 {
+		limit = DefaultNotificationPageSize
+	}
+	if limit > MaxNotificationPageSize {
+		limit = MaxNotificationPageSize
+	}
+
+	args := []any{userId}
+	where := []string{"notifications.user_id = $1"}
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(notifications.created_at, notifications.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, limit+1)
+
+	query := fmt.Sprintf(`SELECT notifications.id, notifications.user_id, notifications.type,
+			notifications.event_id, notifications.photo_id, notifications.created_at, notifications.read_at,
+			users.id, users.oauth_id, users.name, users.avatar_url, users.email
+		FROM notifications
+		LEFT JOIN users ON users.id = notifications.actor_id
+		WHERE %s
+		ORDER BY notifications.created_at DESC, notifications.id DESC
+		LIMIT $%d`,
+		strings.Join(where, " AND "), len(args),
+	)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[ListNotifications] %v", err)
+	}
+	defer rows.Close()
+
+	page := &NotificationPage{Notifications: []Notification{}}
+	for rows.Next() {
+		var n Notification
+		var eventId, photoId sql.NullString
+		var readAt sql.NullTime
+		var actorId, actorOAuthId, actorName, actorAvatarUrl, actorEmail sql.NullString
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type,
+			&eventId, &photoId, &n.CreatedAt, &readAt,
+			&actorId, &actorOAuthId, &actorName, &actorAvatarUrl, &actorEmail)
+		if err != nil {
+			return nil, fmt.Errorf("[ListNotificationsScan] %v", err)
+		}
+		if len(page.Notifications) == limit {
+			last := page.Notifications[len(page.Notifications)-1]
+			page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+			break
+		}
+		if eventId.Valid {
+			n.EventID = &eventId.String
+		}
+		if photoId.Valid {
+			n.PhotoID = &photoId.String
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		if actorId.Valid {
+			n.Actor = &User{
+				ID:        actorId.String,
+				OAuthId:   actorOAuthId.String,
+				Name:      actorName.String,
+				AvatarUrl: actorAvatarUrl.String,
+				Email:     actorEmail.String,
+			}
+		}
+		page.Notifications = append(page.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListNotifications] %v", err)
+	}
+	rows.Close()
+
+	err = s.q.QueryRow("SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userId).Scan(&page.Unread)
+	if err != nil {
+		return nil, fmt.Errorf("[ListNotifications] %v", err)
+	}
+	return page, nil
+}
+
+// MarkNotificationsRead marks the given notifications of a user as read,
+// or all of them when ids is nil.
+func (s *service) MarkNotificationsRead(userId string, ids []string) error {
+	_, err := s.q.Exec(
+		`UPDATE notifications SET read_at = now()
+		WHERE user_id = $1 AND read_at IS NULL AND ($2::uuid[] IS NULL OR id = ANY($2::uuid[]))`,
+		userId,
+		ids,
+	)
+	if err != nil {
+		return fmt.Errorf("[MarkNotificationsRead] %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+)
+
+// RecordOrphanedFiles keeps storage keys whose objects could not be deleted
+// so they are retried later. Keys already recorded are left as they are.
+func (s *service) RecordOrphanedFiles(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.q.Exec(
+		"INSERT INTO orphaned_files (key) SELECT unnest($1::text[]) ON CONFLICT (key) DO NOTHING",
+		keys,
+	)
+	if err != nil {
+		return fmt.Errorf("[RecordOrphanedFiles] %v", err)
+	}
+	return nil
+}
+
+// ListOrphanedFiles returns up to limit recorded keys, oldest first.
+func (s *service) ListOrphanedFiles(limit int) ([]string, error) {
+	rows, err := s.q.Query("SELECT key FROM orphaned_files ORDER BY created_at, key LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("[ListOrphanedFiles] %v", err)
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("[ListOrphanedFilesScan] %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListOrphanedFiles] %v", err)
+	}
+	return keys, nil
+}
+
+// ForgetOrphanedFiles drops keys whose objects are deleted now.
+func (s *service) ForgetOrphanedFiles(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.q.Exec("DELETE FROM orphaned_files WHERE key = ANY($1::text[])", keys)
+	if err != nil {
+		return fmt.Errorf("[ForgetOrphanedFiles] %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaType tells photos from videos, which share the photos table.
+type MediaType string
+
+const (
+	MediaPhoto MediaType = "photo"
+	MediaVideo MediaType = "video"
+)
+
+// MediaTypeOf returns the media type of files of the MIME type.
+func MediaTypeOf(fileType string) MediaType {
+	if strings.HasPrefix(fileType, "video/") {
+		return MediaVideo
+	}
+	return MediaPhoto
+}
+
+type PhotoSort string
+
+const (
+	PhotoSortUploaded PhotoSort = "uploaded"
+	PhotoSortCaptured PhotoSort = "captured"
+)
+
+const (
+	DefaultPhotoPageSize = 50
+	MaxPhotoPageSize     = 200
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidPhoto  = errors.New("invalid photo details")
+)
+
+// PhotoQuery describes a page of an event's photos. Cursor is the opaque
+// NextCursor of the previous page; Since restricts the result to photos
+// uploaded after the given time, for incremental sync.
+type PhotoQuery struct {
+	EventID   string
+	CreatedBy string
+	Sort      PhotoSort
+	Ascending bool
+	Since     *time.Time
+	Cursor    string
+	Limit     int
+}
+
+type PhotoPage struct {
+	Photos     []Photo `json:"photos"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+// PhotoMetadata is what processing the original tells about a photo.
+type PhotoMetadata struct {
+	CapturedAt  *time.Time
+	CameraModel string
+	Orientation int
+	Latitude    *float64
+	Longitude   *float64
+	Width       int
+	Height      int
+	DHash       *int64
+	// Of videos, in milliseconds
+	DurationMs *int
+}
+
+// PhotoUpdate holds the fields an uploader may change; nil fields are left
+// untouched. Setting CapturedAt keeps it from being read from the original
+// again.
+type PhotoUpdate struct {
+	Caption    *string
+	AltText    *string
+	CapturedAt *time.Time
+}
+
+// SimilarPhoto is a near-duplicate of another photo. Distance is the number
+// of differing bits of their perceptual hashes, 0 means they look the same.
+type SimilarPhoto struct {
+	Photo
+	Distance int `json:"distance"`
+}
+
+// Columns scanned by photoFields
+const photoColumns = `id, public_url, file_name, file_type, created_by, event_id, created_at, captured_at, renditions,
+	camera_model, orientation, latitude, longitude, width, height, sha256, dhash, deleted_at, deleted_by,
+	caption, alt_text, captured_at_edited, media_type, duration_ms`
+
+func (q PhotoQuery) sortExpr() string {
+	if q.Sort == PhotoSortCaptured {
+		return "COALESCE(captured_at, created_at)"
+	}
+	return "created_at"
+}
+
+func (s *service) GetPhoto(photoId string) (*Photo, error) {
+	var fields photoFields
+	err := s.q.QueryRow("SELECT "+photoColumns+" FROM photos WHERE id = $1", photoId).Scan(fields.dest()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[GetPhoto] %v", err)
+	}
+	photo, err := fields.photo()
+	if err != nil {
+		return nil, fmt.Errorf("[GetPhoto] %v", err)
+	}
+	return photo, nil
+}
+
+func (s *service) SetPhotoRenditions(photoId string, renditions map[string]string) error {
+	raw, err := json.Marshal(renditions)
+	if err != nil {
+		return fmt.Errorf("[SetPhotoRenditions] %v", err)
+	}
+	res, err := s.q.Exec("UPDATE photos SET renditions = $2 WHERE id = $1", photoId, raw)
+	if err != nil {
+		return fmt.Errorf("[SetPhotoRenditions] %v", err)
+	}
+	return expectAffected(res)
+}
+
+func (s *service) SetPhotoMetadata(photoId string, meta PhotoMetadata) error {
+	orientation := max(meta.Orientation, 1)
+	var width, height *int
+	if meta.Width > 0 && meta.Height > 0 {
+		width, height = &meta.Width, &meta.Height
+	}
+	res, err := s.q.Exec(
+		`UPDATE photos SET captured_at = CASE WHEN captured_at_edited THEN captured_at ELSE $2 END,
+			camera_model = $3, orientation = $4,
+			latitude = $5, longitude = $6, width = $7, height = $8, dhash = $9,
+			duration_ms = COALESCE($10, duration_ms)
+		WHERE id = $1`,
+		photoId, meta.CapturedAt, meta.CameraModel, orientation,
+		meta.Latitude, meta.Longitude, width, height, meta.DHash, meta.DurationMs,
+	)
+	if err != nil {
+		return fmt.Errorf("[SetPhotoMetadata] %v", err)
+	}
+	return expectAffected(res)
+}
+
+func (s *service) UpdatePhoto(photoId string, update PhotoUpdate) error {
+	res, err := s.q.Exec(
+		`UPDATE photos SET
+			caption = COALESCE($2, caption),
+			alt_text = COALESCE($3, alt_text),
+			captured_at = COALESCE($4, captured_at),
+			captured_at_edited = captured_at_edited OR $4::timestamptz IS NOT NULL
+		WHERE id = $1 AND deleted_at IS NULL`,
+		photoId,
+		update.Caption,
+		update.AltText,
+		update.CapturedAt,
+	)
+	if isCheckViolation(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidPhoto, err)
+	}
+	if err != nil {
+		return fmt.Errorf("[UpdatePhoto] %v", err)
+	}
+	return expectAffected(res)
+}
+
+func (s *service) FindPhotoBySHA256(eventId string, sha256 string) (*Photo, error) {
+	var fields photoFields
+	err := s.q.QueryRow("SELECT "+photoColumns+" FROM photos WHERE event_id = $1 AND sha256 = $2 AND deleted_at IS NULL", eventId, sha256).
+		Scan(fields.dest()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[FindPhotoBySHA256] %v", err)
+	}
+	photo, err := fields.photo()
+	if err != nil {
+		return nil, fmt.Errorf("[FindPhotoBySHA256] %v", err)
+	}
+	return photo, nil
+}
+
+// ListSimilarPhotos returns the photos of the same event whose perceptual
+// hash is within maxDistance bits of the photo's, closest first. Photos
+// that are not processed yet have no hash and match nothing, trashed photos
+// are left out.
+func (s *service) ListSimilarPhotos(photoId string, maxDistance int, limit int) ([]SimilarPhoto, error) {
+	rows, err := s.q.Query(
+		`SELECT `+photoColumns+`, distance
+		FROM (
+			SELECT photos.*, bit_count((photos.dhash # target.dhash)::bit(64)) AS distance
+			FROM photos
+			JOIN photos AS target ON target.event_id = photos.event_id
+			WHERE target.id = $1 AND photos.id <> target.id AND photos.deleted_at IS NULL
+		) AS candidates
+		WHERE distance <= $2
+		ORDER BY distance, created_at, id
+		LIMIT $3`,
+		photoId, maxDistance, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListSimilarPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	similar := []SimilarPhoto{}
+	for rows.Next() {
+		var fields photoFields
+		var distance int
+		if err := rows.Scan(append(fields.dest(), &distance)...); err != nil {
+			return nil, fmt.Errorf("[ListSimilarPhotosScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return nil, fmt.Errorf("[ListSimilarPhotosScan] %v", err)
+		}
+		similar = append(similar, SimilarPhoto{Photo: *photo, Distance: distance})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListSimilarPhotos] %v", err)
+	}
+	return similar, nil
+}
+
+func (s *service) ListEventPhotos(q PhotoQuery) (*PhotoPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPhotoPageSize
+	}
+	if q.Limit > MaxPhotoPageSize {
+		q.Limit = MaxPhotoPageSize
+	}
+
+	sortExpr := q.sortExpr()
+	direction, cmp := "DESC", "<"
+	if q.Ascending {
+		direction, cmp = "ASC", ">"
+	}
+
+	args := []any{q.EventID}
+	where := []string{"event_id = $1", "deleted_at IS NULL"}
+	if q.CreatedBy != "" {
+		args = append(args, q.CreatedBy)
+		where = append(where, fmt.Sprintf("created_by = $%d", len(args)))
+	}
+	if q.Since != nil {
+		args = append(args, *q.Since)
+		where = append(where, fmt.Sprintf("created_at > $%d", len(args)))
+	}
+	if q.Cursor != "" {
+		sortKey, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, sortKey, id)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d, $%d)", sortExpr, cmp, len(args)-1, len(args)))
+	}
+	args = append(args, q.Limit+1)
+
+	query := fmt.Sprintf(`SELECT %s, %s
+		FROM photos
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT $%d`,
+		photoColumns, sortExpr, strings.Join(where, " AND "), sortExpr, direction, direction, len(args),
+	)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[ListEventPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	page := &PhotoPage{Photos: []Photo{}}
+	var lastSortKey time.Time
+	for rows.Next() {
+		var fields photoFields
+		var sortKey time.Time
+		if err := rows.Scan(append(fields.dest(), &sortKey)...); err != nil {
+			return nil, fmt.Errorf("[ListEventPhotosScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return nil, fmt.Errorf("[ListEventPhotosScan] %v", err)
+		}
+		if len(page.Photos) == q.Limit {
+			page.NextCursor = encodeCursor(lastSortKey, page.Photos[len(page.Photos)-1].ID)
+			break
+		}
+		page.Photos = append(page.Photos, *photo)
+		lastSortKey = sortKey
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListEventPhotos] %v", err)
+	}
+	return page, nil
+}
+
+// Cursors are "<sort key>:<row id>", base64 encoded so clients treat them
+// as opaque. Time ordered pages of photos and comments use unix nanos as
+// the sort key, album pages the position of the photo.
+func encodeCursor(sortKey time.Time, id string) string {
+	return encodePositionCursor(sortKey.UnixNano(), id)
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	n, id, err := decodePositionCursor(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, n), id, nil
+}
+
+func encodePositionCursor(sortKey int64, id string) string {
+	raw := strconv.FormatInt(sortKey, 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePositionCursor(cursor string) (int64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	key, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return n, id, nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MaxReactionList caps who-reacted listings.
+const MaxReactionList = 500
+
+// ReactionCount is how many users reacted to a photo with an emoji, and
+// whether the requesting user is one of them.
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+type Reaction struct {
+	Emoji     string    `json:"emoji"`
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddPhotoReaction reacts to a photo, doing nothing when the user already
+// reacted with the emoji.
+func (s *service) AddPhotoReaction(photoId string, userId string, emoji string) error {
+	_, err := s.q.Exec(
+		`INSERT INTO photo_reactions (photo_id, user_id, emoji, event_id)
+		SELECT id, $2, $3, event_id FROM photos WHERE id = $1
+		ON CONFLICT (photo_id, emoji, user_id) DO NOTHING`,
+		photoId,
+		userId,
+		emoji,
+	)
+	if err != nil {
+		return fmt.Errorf("[AddPhotoReaction] %v", err)
+	}
+	return nil
+}
+
+func (s *service) RemovePhotoReaction(photoId string, userId string, emoji string) error {
+	_, err := s.q.Exec(
+		"DELETE FROM photo_reactions WHERE photo_id = $1 AND user_id = $2 AND emoji = $3",
+		photoId,
+		userId,
+		emoji,
+	)
+	if err != nil {
+		return fmt.Errorf("[RemovePhotoReaction] %v", err)
+	}
+	return nil
+}
+
+// CountPhotoReactions returns the reaction counts of photos by photo ID,
+// emoji in the order they were first used. Photos without reactions are
+// left out.
+func (s *service) CountPhotoReactions(userId string, photoIds []string) (map[string][]ReactionCount, error) {
+	rows, err := s.q.Query(
+		`SELECT photo_id, emoji, count(*), bool_or(user_id = $1)
+		FROM photo_reactions
+		WHERE photo_id = ANY($2::uuid[])
+		GROUP BY photo_id, emoji
+		ORDER BY photo_id, min(created_at)`,
+		userId,
+		photoIds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[CountPhotoReactions] %v", err)
+	}
+	return scanReactionCounts(rows)
+}
+
+// CountEventReactions is CountPhotoReactions for every photo of the events.
+func (s *service) CountEventReactions(userId string, eventIds []string) (map[string][]ReactionCount, error) {
+	rows, err := s.q.Query(
+		`SELECT photo_id, emoji, count(*), bool_or(user_id = $1)
+		FROM photo_reactions
+		WHERE event_id = ANY($2::uuid[])
+		GROUP BY photo_id, emoji
+		ORDER BY photo_id, min(created_at)`,
+		userId,
+		eventIds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[CountEventReactions] %v", err)
+	}
+	return scanReactionCounts(rows)
+}
+
+func scanReactionCounts(rows *sql.Rows) (map[string][]ReactionCount, error) {
+	defer rows.Close()
+
+	counts := make(map[string][]ReactionCount)
+	for rows.Next() {
+		var photoId string
+		var count ReactionCount
+		if err := rows.Scan(&photoId, &count.Emoji, &count.Count, &count.Reacted); err != nil {
+			return nil, fmt.Errorf("[scanReactionCounts] %v", err)
+		}
+		counts[photoId] = append(counts[photoId], count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[scanReactionCounts] %v", err)
+	}
+	return counts, nil
+}
+
+// ListPhotoReactions returns who reacted to a photo, oldest first, with
+// the given emoji only unless it is empty.
+func (s *service) ListPhotoReactions(photoId string, emoji string) ([]Reaction, error) {
+	rows, err := s.q.Query(
+		`SELECT photo_reactions.emoji, photo_reactions.created_at,
+			users.id, users.oauth_id, users.name, users.avatar_url, users.email
+		FROM photo_reactions
+		JOIN users ON users.id = photo_reactions.user_id
+		WHERE photo_reactions.photo_id = $1 AND ($2 = '' OR photo_reactions.emoji = $2)
+		ORDER BY photo_reactions.created_at, users.id
+		LIMIT $3`,
+		photoId,
+		emoji,
+		MaxReactionList,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListPhotoReactions] %v", err)
+	}
+	defer rows.Close()
+
+	reactions := []Reaction{}
+	for rows.Next() {
+		var r Reaction
+		if err := rows.Scan(&r.Emoji, &r.CreatedAt, &r.User.ID, &r.User.OAuthId, &r.User.Name, &r.User.AvatarUrl, &r.User.Email); err != nil {
+			return nil, fmt.Errorf("[ListPhotoReactionsScan] %v", err)
+		}
+		reactions = append(reactions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListPhotoReactions] %v", err)
+	}
+	return reactions, nil
+}
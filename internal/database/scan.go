@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -44,29 +45,42 @@ func ScanEventRows(rows *sql.Rows) (map[string]*Event, error) {
 func scanNextEvent(rows *sql.Rows) (*Event, *Like, *Photo, *User, error) {
 	var id, name, owner, image string
 	var created time.Time
+	var archived, startsAt, endsAt sql.NullTime
+	var description, timezone, venueName string
+	var latitude, longitude sql.NullFloat64
+	var metadataPrivacy string
+	var coverPhotoID sql.NullString
+	var maxVideoDuration sql.NullInt32
+	var maxVideoSize sql.NullInt64
 	var ownerID, ownerAuthID, ownerName string
 	var ownerAvatar, ownerEmail string
 	var likeID sql.NullInt32
 	var likeUID, likeEID, likeCreated sql.NullString
-	var photoID uuid.NullUUID
-	var photoUrl, photoFileName, photoFileType, photoOwner, photoEventId, photoCreatedAt sql.NullString
+	var photoFields photoFields
 	var mbrID, mbrAuthID, mbrName string
 	var mbrAvatar, mbrEmail string
 
-	if err := rows.Scan(&id, &name, &created, &owner, &image,
+	dest := []any{&id, &name, &created, &owner, &image, &archived,
+		&description, &startsAt, &endsAt, &timezone, &venueName, &latitude, &longitude, &metadataPrivacy, &coverPhotoID,
+		&maxVideoDuration, &maxVideoSize,
 		&ownerID, &ownerAuthID, &ownerName, &ownerAvatar, &ownerEmail,
-		&likeID, &likeUID, &likeEID, &likeCreated,
-		&photoID, &photoUrl, &photoFileName, &photoFileType, &photoOwner, &photoEventId, &photoCreatedAt,
-		&mbrID, &mbrAuthID, &mbrName, &mbrAvatar, &mbrEmail); err != nil {
+		&likeID, &likeUID, &likeEID, &likeCreated}
+	dest = append(dest, photoFields.dest()...)
+	dest = append(dest, &mbrID, &mbrAuthID, &mbrName, &mbrAvatar, &mbrEmail)
+	if err := rows.Scan(dest...); err != nil {
 		return nil, nil, nil, nil, err
 	}
 
 	event := &Event{
-		ID:        id,
-		Name:      name,
-		CreatedAt: created,
-		OwnerID:   ownerID,
-		ImageURL:  image,
+		ID:              id,
+		Name:            name,
+		CreatedAt:       created,
+		OwnerID:         ownerID,
+		ImageURL:        image,
+		Description:     description,
+		Timezone:        timezone,
+		VenueName:       venueName,
+		MetadataPrivacy: MetadataPrivacy(metadataPrivacy),
 		Owner: User{
 			ID:        ownerID,
 			OAuthId:   ownerAuthID,
@@ -77,6 +91,29 @@ func scanNextEvent(rows *sql.Rows) (*Event, *Like, *Photo, *User, error) {
 		Likes:   []Like{},
 		Members: []User{},
 	}
+	if archived.Valid {
+		event.ArchivedAt = &archived.Time
+	}
+	if startsAt.Valid {
+		event.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		event.EndsAt = &endsAt.Time
+	}
+	if latitude.Valid && longitude.Valid {
+		event.Latitude = &latitude.Float64
+		event.Longitude = &longitude.Float64
+	}
+	if coverPhotoID.Valid {
+		event.CoverPhotoID = &coverPhotoID.String
+	}
+	if maxVideoDuration.Valid {
+		seconds := int(maxVideoDuration.Int32)
+		event.MaxVideoDuration = &seconds
+	}
+	if maxVideoSize.Valid {
+		event.MaxVideoSize = &maxVideoSize.Int64
+	}
 	var like *Like
 	if likeID.Valid {
 		like = &Like{
@@ -86,16 +123,9 @@ func scanNextEvent(rows *sql.Rows) (*Event, *Like, *Photo, *User, error) {
 			CreatedAt: likeCreated.String,
 		}
 	}
-	var photo *Photo
-	if photoID.Valid {
-		photo = &Photo{
-			ID:        photoID.UUID.String(),
-			PublicUrl: photoUrl.String,
-			FileName:  photoFileName.String,
-			FileType:  photoFileType.String,
-			CreatedBy: photoOwner.String,
-			EventID:   photoEventId.String,
-		}
+	photo, err := photoFields.photo()
+	if err != nil {
+		return nil, nil, nil, nil, err
 	}
 	member := &User{
 		ID:        mbrID,
@@ -106,3 +136,84 @@ func scanNextEvent(rows *sql.Rows) (*Event, *Like, *Photo, *User, error) {
 	}
 	return event, like, photo, member, nil
 }
+
+// photoFields receives the columns of photoColumns. They are all nullable
+// because events LEFT JOIN their photos.
+type photoFields struct {
+	id                                                uuid.NullUUID
+	publicUrl, fileName, fileType, createdBy, eventId sql.NullString
+	createdAt, capturedAt                             sql.NullTime
+	renditions                                        []byte
+	cameraModel                                       sql.NullString
+	orientation                                       sql.NullInt16
+	latitude, longitude                               sql.NullFloat64
+	width, height                                     sql.NullInt32
+	sha256                                            sql.NullString
+	dhash                                             sql.NullInt64
+	deletedAt                                         sql.NullTime
+	deletedBy                                         sql.NullString
+	caption, altText                                  sql.NullString
+	capturedAtEdited                                  sql.NullBool
+	mediaType                                         sql.NullString
+	durationMs                                        sql.NullInt32
+}
+
+func (f *photoFields) dest() []any {
+	return []any{&f.id, &f.publicUrl, &f.fileName, &f.fileType, &f.createdBy, &f.eventId,
+		&f.createdAt, &f.capturedAt, &f.renditions,
+		&f.cameraModel, &f.orientation, &f.latitude, &f.longitude, &f.width, &f.height,
+		&f.sha256, &f.dhash, &f.deletedAt, &f.deletedBy,
+		&f.caption, &f.altText, &f.capturedAtEdited, &f.mediaType, &f.durationMs}
+}
+
+// photo returns the scanned photo, or nil for an event without photos.
+func (f *photoFields) photo() (*Photo, error) {
+	if !f.id.Valid {
+		return nil, nil
+	}
+	photo := &Photo{
+		ID:               f.id.UUID.String(),
+		PublicUrl:        f.publicUrl.String,
+		FileName:         f.fileName.String,
+		FileType:         f.fileType.String,
+		CreatedBy:        f.createdBy.String,
+		EventID:          f.eventId.String,
+		CreatedAt:        f.createdAt.Time,
+		CameraModel:      f.cameraModel.String,
+		Orientation:      int(f.orientation.Int16),
+		SHA256:           f.sha256.String,
+		DeletedBy:        f.deletedBy.String,
+		Renditions:       map[string]string{},
+		Caption:          f.caption.String,
+		AltText:          f.altText.String,
+		CapturedAtEdited: f.capturedAtEdited.Bool,
+		MediaType:        MediaType(f.mediaType.String),
+	}
+	if f.capturedAt.Valid {
+		photo.CapturedAt = &f.capturedAt.Time
+	}
+	if f.latitude.Valid && f.longitude.Valid {
+		photo.Latitude = &f.latitude.Float64
+		photo.Longitude = &f.longitude.Float64
+	}
+	if f.width.Valid && f.height.Valid {
+		width, height := int(f.width.Int32), int(f.height.Int32)
+		photo.Width, photo.Height = &width, &height
+	}
+	if f.dhash.Valid {
+		photo.DHash = &f.dhash.Int64
+	}
+	if f.durationMs.Valid {
+		durationMs := int(f.durationMs.Int32)
+		photo.DurationMs = &durationMs
+	}
+	if f.deletedAt.Valid {
+		photo.DeletedAt = &f.deletedAt.Time
+	}
+	if len(f.renditions) > 0 {
+		if err := json.Unmarshal(f.renditions, &photo.Renditions); err != nil {
+			return nil, err
+		}
+	}
+	return photo, nil
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Only members of the photo's event can be tagged in it
+var ErrInvalidTag = errors.New("tagged user is not a member of the event")
+
+// TagBox is where a tagged user is in a photo, in fractions of the upright
+// photo's width and height from its top left corner.
+type TagBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (b TagBox) Valid() bool {
+	return b.X >= 0 && b.Y >= 0 && b.Width > 0 && b.Height > 0 && b.X+b.Width <= 1 && b.Y+b.Height <= 1
+}
+
+type PhotoTag struct {
+	PhotoID   string    `json:"photo_id"`
+	User      User      `json:"user"`
+	TaggedBy  string    `json:"tagged_by"`
+	Box       *TagBox   `json:"box"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserPhotoQuery describes a page of the photos a user is tagged in, most
+// recently uploaded first.
+type UserPhotoQuery struct {
+	UserID string
+	Cursor string
+	Limit  int
+}
+
+// TagPhoto tags tag.User.ID in a photo, or moves the box of an existing
+// tag. It reports whether the tag is new.
+func (s *service) TagPhoto(tag *PhotoTag) (bool, error) {
+	var x, y, width, height *float64
+	if tag.Box != nil {
+		x, y, width, height = &tag.Box.X, &tag.Box.Y, &tag.Box.Width, &tag.Box.Height
+	}
+	var created bool
+	err := s.q.QueryRow(
+		`INSERT INTO photo_tags (photo_id, user_id, event_id, tagged_by, box_x, box_y, box_width, box_height)
+		SELECT photos.id, members.user_id, photos.event_id, $3, $4, $5, $6, $7
+		FROM photos
+		JOIN members ON members.event_id = photos.event_id AND members.user_id = $2
+		WHERE photos.id = $1
+		ON CONFLICT (photo_id, user_id) DO UPDATE SET
+			box_x = EXCLUDED.box_x, box_y = EXCLUDED.box_y,
+			box_width = EXCLUDED.box_width, box_height = EXCLUDED.box_height
+		RETURNING created_at, xmax = 0`,
+		tag.PhotoID,
+		tag.User.ID,
+		tag.TaggedBy,
+		x, y, width, height,
+	).Scan(&tag.CreatedAt, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrInvalidTag
+	}
+	if isCheckViolation(err) {
+		return false, fmt.Errorf("%w: %v", ErrInvalidTag, err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("[TagPhoto] %v", err)
+	}
+	return created, nil
+}
+
+func (s *service) UntagPhoto(photoId string, userId string) error {
+	res, err := s.q.Exec("DELETE FROM photo_tags WHERE photo_id = $1 AND user_id = $2", photoId, userId)
+	if err != nil {
+		return fmt.Errorf("[UntagPhoto] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// ListPhotoTags returns the tags of photos by photo ID, oldest first.
+// Photos without tags are left out.
+func (s *service) ListPhotoTags(photoIds []string) (map[string][]PhotoTag, error) {
+	rows, err := s.q.Query(
+		`SELECT photo_tags.photo_id, photo_tags.tagged_by, photo_tags.created_at,
+			photo_tags.box_x, photo_tags.box_y, photo_tags.box_width, photo_tags.box_height,
+			users.id, users.oauth_id, users.name, users.avatar_url, users.email
+		FROM photo_tags
+		JOIN users ON users.id = photo_tags.user_id
+		WHERE photo_tags.photo_id = ANY($1::uuid[])
+		ORDER BY photo_tags.photo_id, photo_tags.created_at, users.id`,
+		photoIds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListPhotoTags] %v", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]PhotoTag)
+	for rows.Next() {
+		var t PhotoTag
+		var taggedBy sql.NullString
+		var x, y, width, height sql.NullFloat64
+		err := rows.Scan(&t.PhotoID, &taggedBy, &t.CreatedAt,
+			&x, &y, &width, &height,
+			&t.User.ID, &t.User.OAuthId, &t.User.Name, &t.User.AvatarUrl, &t.User.Email)
+		if err != nil {
+			return nil, fmt.Errorf("[ListPhotoTagsScan] %v", err)
+		}
+		t.TaggedBy = taggedBy.String
+		if x.Valid {
+			t.Box = &TagBox{X: x.Float64, Y: y.Float64, Width: width.Float64, Height: height.Float64}
+		}
+		tags[t.PhotoID] = append(tags[t.PhotoID], t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListPhotoTags] %v", err)
+	}
+	return tags, nil
+}
+
+// ListUserPhotos returns a page of the photos a user is tagged in, from the
+// events they are still a member of. Trashed photos are left out.
+func (s *service) ListUserPhotos(q UserPhotoQuery) (*PhotoPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPhotoPageSize
+	}
+	if q.Limit > MaxPhotoPageSize {
+		q.Limit = MaxPhotoPageSize
+	}
+
+	args := []any{q.UserID}
+	where := []string{"photo_tags.user_id = $1", "photos.deleted_at IS NULL"}
+	if q.Cursor != "" {
+		createdAt, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(photos.created_at, photos.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, q.Limit+1)
+
+	query := fmt.Sprintf(`SELECT %s
+		FROM photo_tags
+		JOIN photos ON photos.id = photo_tags.photo_id
+		JOIN members ON members.event_id = photo_tags.event_id AND members.user_id = photo_tags.user_id
+		WHERE %s
+		ORDER BY photos.created_at DESC, photos.id DESC
+		LIMIT $%d`,
+		qualifiedPhotoColumns, strings.Join(where, " AND "), len(args),
+	)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[ListUserPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	page := &PhotoPage{Photos: []Photo{}}
+	for rows.Next() {
+		var fields photoFields
+		if err := rows.Scan(fields.dest()...); err != nil {
+			return nil, fmt.Errorf("[ListUserPhotosScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return nil, fmt.Errorf("[ListUserPhotosScan] %v", err)
+		}
+		if len(page.Photos) == q.Limit {
+			last := page.Photos[len(page.Photos)-1]
+			page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+			break
+		}
+		page.Photos = append(page.Photos, *photo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListUserPhotos] %v", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrashRetention is how long a deleted photo can be restored before it is
+// purged along with its files.
+const TrashRetention = 30 * 24 * time.Hour
+
+// TrashedPhoto is a deleted photo with the time it is purged at.
+type TrashedPhoto struct {
+	Photo
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashPhoto moves a photo to the trash. It returns ErrNotFound for unknown
+// photos and photos already in the trash.
+func (s *service) TrashPhoto(photoId string, userId string) error {
+	res, err := s.q.Exec(
+		"UPDATE photos SET deleted_at = now(), deleted_by = $2 WHERE id = $1 AND deleted_at IS NULL",
+		photoId,
+		userId,
+	)
+	if err != nil {
+		return fmt.Errorf("[TrashPhoto] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// RestorePhoto takes a photo out of the trash. It returns ErrDuplicatePhoto
+// when the same file was uploaded again in the meantime.
+func (s *service) RestorePhoto(photoId string) error {
+	res, err := s.q.Exec(
+		"UPDATE photos SET deleted_at = NULL, deleted_by = NULL WHERE id = $1 AND deleted_at IS NOT NULL",
+		photoId,
+	)
+	if isUniqueViolation(err, "photos_event_sha256_key") {
+		return ErrDuplicatePhoto
+	}
+	if err != nil {
+		return fmt.Errorf("[RestorePhoto] %v", err)
+	}
+	return expectAffected(res)
+}
+
+// ListTrashedPhotos returns the photos in the trash of an event, most
+// recently deleted first.
+func (s *service) ListTrashedPhotos(eventId string) ([]TrashedPhoto, error) {
+	rows, err := s.q.Query(
+		"SELECT "+photoColumns+" FROM photos WHERE event_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id",
+		eventId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[ListTrashedPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	trashed := []TrashedPhoto{}
+	for rows.Next() {
+		var fields photoFields
+		if err := rows.Scan(fields.dest()...); err != nil {
+			return nil, fmt.Errorf("[ListTrashedPhotosScan] %v", err)
+		}
+		photo, err := fields.photo()
+		if err != nil {
+			return nil, fmt.Errorf("[ListTrashedPhotosScan] %v", err)
+		}
+		trashed = append(trashed, TrashedPhoto{Photo: *photo, PurgeAt: photo.DeletedAt.Add(TrashRetention)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListTrashedPhotos] %v", err)
+	}
+	return trashed, nil
+}
+
+// PurgeTrashedPhotos deletes up to limit photos trashed before the given
+// time and returns their IDs, whose files the caller has to delete.
+func (s *service) PurgeTrashedPhotos(before time.Time, limit int) ([]string, error) {
+	rows, err := s.q.Query(
+		`DELETE FROM photos WHERE id IN (
+			SELECT id FROM photos
+			WHERE deleted_at < $1
+			ORDER BY deleted_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) RETURNING id`,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[PurgeTrashedPhotos] %v", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("[PurgeTrashedPhotosScan] %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[PurgeTrashedPhotos] %v", err)
+	}
+	return ids, nil
+}
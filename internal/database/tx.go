@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// querier is the part of *sql.DB and *sql.Tx the service methods run their
+// statements on, so the same methods work inside and outside a transaction.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn with a Service bound to a single transaction. The
+// transaction is committed when fn returns nil and rolled back otherwise.
+// Calling WithTx on a Service that is already bound to a transaction reuses
+// it, so methods can be composed freely.
+func (s *service) WithTx(ctx context.Context, fn func(tx Service) error) error {
+	if _, inTx := s.q.(*sql.Tx); inTx {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[WithTx] %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&service{db: s.db, q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("[WithTx] %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/media"
+	"mercuria-backend/internal/storage"
+)
+
+const (
+	ContentType  = "application/zip"
+	ManifestName = "manifest.csv"
+)
+
+var manifestHeader = []string{"file", "photo_id", "uploader_id", "uploader_name", "uploaded_at", "captured_at", "caption", "alt_text"}
+
+// Key is where the archive of an export is stored.
+func Key(exportId string) string {
+	return "exports/" + exportId + ".zip"
+}
+
+// Source selects the photos of an archive: those of the event, or of one of
+// its albums when AlbumID is set. Trashed photos are left out.
+type Source struct {
+	EventID string
+	AlbumID string
+}
+
+// Archiver writes ZIP archives of photo originals. They are copied from
+// storage one at a time straight into the archive, which is never held in
+// memory; only the manifest is, until it is written last.
+type Archiver struct {
+	db      database.Service
+	storage storage.Service
+}
+
+func NewArchiver(db database.Service, storage storage.Service) *Archiver {
+	return &Archiver{db: db, storage: storage}
+}
+
+// Write writes the archive of the source to w, the originals in upload or
+// album order, then a manifest.csv of who uploaded them, their captions and
+// capture times. It returns how many photos are in the archive; originals
+// missing from storage are skipped.
+func (a *Archiver) Write(ctx context.Context, w io.Writer, source Source) (int, error) {
+	archive := zip.NewWriter(w)
+	var manifest bytes.Buffer
+	rows := csv.NewWriter(&manifest)
+	if err := rows.Write(manifestHeader); err != nil {
+		return 0, err
+	}
+
+	uploaders := make(map[string]database.User)
+	count := 0
+	err := a.eachPage(source, func(photos []database.Photo) error {
+		if err := a.loadUploaders(uploaders, photos); err != nil {
+			return err
+		}
+		for i := range photos {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			photo := &photos[i]
+			name := fmt.Sprintf("%05d_%s", count+1, fileName(photo))
+			err := a.copyOriginal(archive, name, photo)
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Printf("[Archiver] original of %s is missing, skipped", photo.ID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			count++
+
+			var capturedAt string
+			if photo.CapturedAt != nil {
+				capturedAt = photo.CapturedAt.Format(time.RFC3339)
+			}
+			err = rows.Write([]string{
+				name,
+				photo.ID,
+				photo.CreatedBy,
+				cell(uploaders[photo.CreatedBy].Name),
+				photo.CreatedAt.Format(time.RFC3339),
+				capturedAt,
+				cell(photo.Caption),
+				cell(photo.AltText),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	rows.Flush()
+	if err := rows.Error(); err != nil {
+		return count, err
+	}
+	mw, err := archive.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return count, err
+	}
+	if _, err := manifest.WriteTo(mw); err != nil {
+		return count, err
+	}
+	return count, archive.Close()
+}
+
+// eachPage calls fn with every page of the source's photos.
+func (a *Archiver) eachPage(source Source, fn func(photos []database.Photo) error) error {
+	cursor := ""
+	for {
+		var page *database.PhotoPage
+		var err error
+		if source.AlbumID != "" {
+			page, err = a.db.ListAlbumPhotos(database.AlbumPhotoQuery{
+				AlbumID: source.AlbumID,
+				Cursor:  cursor,
+				Limit:   database.MaxPhotoPageSize,
+			})
+		} else {
+			page, err = a.db.ListEventPhotos(database.PhotoQuery{
+				EventID:   source.EventID,
+				Sort:      database.PhotoSortUploaded,
+				Ascending: true,
+				Cursor:    cursor,
+				Limit:     database.MaxPhotoPageSize,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(page.Photos); err != nil {
+			return err
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (a *Archiver) loadUploaders(uploaders map[string]database.User, photos []database.Photo) error {
+	var ids []string
+	for _, photo := range photos {
+		if _, ok := uploaders[photo.CreatedBy]; !ok && photo.CreatedBy != "" {
+			ids = append(ids, photo.CreatedBy)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	users, err := a.db.ListUsers(ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		// Remembered even when unknown, so they are looked up once
+		uploaders[id] = users[id]
+	}
+	return nil
+}
+
+// copyOriginal stores the original of a photo as is: photos and videos are
+// compressed already.
+func (a *Archiver) copyOriginal(archive *zip.Writer, name string, photo *database.Photo) error {
+	original, err := a.storage.DownloadFile(photo.ID)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	modified := photo.CreatedAt
+	if photo.CapturedAt != nil {
+		modified = *photo.CapturedAt
+	}
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, original)
+	return err
+}
+
+func fileName(photo *database.Photo) string {
+	if name := media.SanitizeFileName(photo.FileName); name != "" {
+		return name
+	}
+	return photo.ID
+}
+
+// cell keeps user text from being read as a formula by spreadsheets.
+func cell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Build writes the archive of the source to a temporary file and uploads it
+// to storage as the export's archive. It returns how many photos are in it
+// and its size.
+func (a *Archiver) Build(ctx context.Context, exportId string, source Source) (int, int64, error) {
+	file, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	count, err := a.Write(ctx, file, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := a.storage.UploadFile(file, size, Key(exportId), ContentType); err != nil {
+		return 0, 0, err
+	}
+	return count, size, nil
+}
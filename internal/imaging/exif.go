@@ -0,0 +1,363 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Exif holds the few EXIF fields the app uses. Fields missing from the file
+// are left zero.
+type Exif struct {
+	DateTimeOriginal string
+	// Offset of DateTimeOriginal from UTC, e.g. "+02:00". Most cameras don't
+	// write it, phones usually do.
+	OffsetTimeOriginal string
+	CameraMake         string
+	CameraModel        string
+	Orientation        int
+	Latitude           *float64
+	Longitude          *float64
+}
+
+var (
+	ErrNoExif      = errors.New("no EXIF data")
+	ErrInvalidExif = errors.New("invalid EXIF data")
+)
+
+const (
+	exifDateLayout = "2006:01:02 15:04:05"
+
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+	maxIFDEntries         = 1024
+	jpegMarkerSOI         = 0xD8
+	jpegMarkerSOS         = 0xDA
+	jpegMarkerEOI         = 0xD9
+	jpegMarkerAPP1        = 0xE1
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// CaptureTime returns when the photo was taken. Times without an offset are
+// read in loc, the time zone the photo was most likely taken in.
+func (e *Exif) CaptureTime(loc *time.Location) (time.Time, bool) {
+	if e.DateTimeOriginal == "" {
+		return time.Time{}, false
+	}
+	if e.OffsetTimeOriginal != "" {
+		t, err := time.Parse(exifDateLayout+"-07:00", e.DateTimeOriginal+e.OffsetTimeOriginal)
+		if err == nil {
+			return t, true
+		}
+	}
+	t, err := time.ParseInLocation(exifDateLayout, e.DateTimeOriginal, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Camera names the camera, e.g. "Apple iPhone 15". Most models already start
+// with the make.
+func (e *Exif) Camera() string {
+	if strings.HasPrefix(strings.ToLower(e.CameraModel), strings.ToLower(e.CameraMake)) {
+		return e.CameraModel
+	}
+	return strings.TrimSpace(e.CameraMake + " " + e.CameraModel)
+}
+
+// Rotated reports whether the orientation swaps width and height.
+func (e *Exif) Rotated() bool {
+	return e.Orientation >= 5 && e.Orientation <= 8
+}
+
+// ReadExif finds the EXIF segment of a JPEG and parses it. It stops reading
+// at the image data. Other formats return ErrNoExif.
+func ReadExif(r io.Reader) (*Exif, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != jpegMarkerSOI {
+		return nil, ErrNoExif
+	}
+
+	for {
+		marker, err := nextJPEGMarker(br)
+		if err != nil {
+			return nil, ErrNoExif
+		}
+		if marker == jpegMarkerSOS || marker == jpegMarkerEOI {
+			return nil, ErrNoExif
+		}
+		if isStandaloneMarker(marker) {
+			continue
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, ErrNoExif
+		}
+		length := int(binary.BigEndian.Uint16(size[:])) - 2
+		if length < 0 {
+			return nil, ErrInvalidExif
+		}
+		if marker != jpegMarkerAPP1 {
+			if _, err := br.Discard(length); err != nil {
+				return nil, ErrNoExif
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return nil, ErrNoExif
+		}
+		// APP1 also carries XMP, keep looking for the EXIF one
+		if bytes.HasPrefix(segment, exifHeader) {
+			return ParseExif(segment[len(exifHeader):])
+		}
+	}
+}
+
+// nextJPEGMarker reads up to the next marker, skipping fill bytes.
+func nextJPEGMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, ErrInvalidExif
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// Markers without a length: TEM, RST0-7 and a stray SOI.
+func isStandaloneMarker(marker byte) bool {
+	return marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8)
+}
+
+// tiff is an EXIF TIFF structure, offsets are relative to its start.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// Raw value bytes, looked up at the offset when they did not fit inline
+	value []byte
+}
+
+// ParseExif parses the TIFF structure of an EXIF segment, without the
+// "Exif\0\0" header.
+func ParseExif(data []byte) (*Exif, error) {
+	t, ifd0, err := parseTIFFHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &Exif{}
+	entries, err := t.readIFD(ifd0)
+	if err != nil {
+		return nil, err
+	}
+	var exifIFD, gpsIFD uint32
+	var dateTime string
+	for _, e := range entries {
+		switch e.tag {
+		case tagMake:
+			exif.CameraMake = t.ascii(e)
+		case tagModel:
+			exif.CameraModel = t.ascii(e)
+		case tagOrientation:
+			if o, ok := t.uint(e); ok && o >= 1 && o <= 8 {
+				exif.Orientation = int(o)
+			}
+		case tagDateTime:
+			dateTime = t.ascii(e)
+		case tagExifIFD:
+			exifIFD, _ = t.uint(e)
+		case tagGPSIFD:
+			gpsIFD, _ = t.uint(e)
+		}
+	}
+
+	if exifIFD != 0 {
+		entries, err := t.readIFD(exifIFD)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch e.tag {
+			case tagDateTimeOriginal:
+				exif.DateTimeOriginal = t.ascii(e)
+			case tagOffsetTimeOriginal:
+				exif.OffsetTimeOriginal = t.ascii(e)
+			}
+		}
+	}
+	// The modification time is the best guess when the original is missing
+	if exif.DateTimeOriginal == "" {
+		exif.DateTimeOriginal = dateTime
+	}
+
+	if gpsIFD != 0 {
+		entries, err := t.readIFD(gpsIFD)
+		if err != nil {
+			return nil, err
+		}
+		var latRef, lonRef string
+		var lat, lon []float64
+		for _, e := range entries {
+			switch e.tag {
+			case tagGPSLatitudeRef:
+				latRef = t.ascii(e)
+			case tagGPSLatitude:
+				lat = t.rationals(e)
+			case tagGPSLongitudeRef:
+				lonRef = t.ascii(e)
+			case tagGPSLongitude:
+				lon = t.rationals(e)
+			}
+		}
+		latitude, okLat := degrees(lat, latRef, "S", 90)
+		longitude, okLon := degrees(lon, lonRef, "W", 180)
+		if okLat && okLon {
+			exif.Latitude, exif.Longitude = &latitude, &longitude
+		}
+	}
+
+	return exif, nil
+}
+
+func parseTIFFHeader(data []byte) (*tiff, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrInvalidExif
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, ErrInvalidExif
+	}
+	if t.order.Uint16(data[2:4]) != 42 {
+		return nil, 0, ErrInvalidExif
+	}
+	return t, t.order.Uint32(data[4:8]), nil
+}
+
+// Sizes of the TIFF field types by type number
+var tiffTypeSizes = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+func (t *tiff) readIFD(offset uint32) ([]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, ErrInvalidExif
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	if n > maxIFDEntries || uint64(offset)+2+uint64(n)*12 > uint64(len(t.data)) {
+		return nil, ErrInvalidExif
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := range n {
+		raw := t.data[offset+2+uint32(i)*12:]
+		e := ifdEntry{
+			tag:   t.order.Uint16(raw[0:2]),
+			typ:   t.order.Uint16(raw[2:4]),
+			count: t.order.Uint32(raw[4:8]),
+		}
+		if int(e.typ) >= len(tiffTypeSizes) || tiffTypeSizes[e.typ] == 0 {
+			continue
+		}
+		size := uint64(tiffTypeSizes[e.typ]) * uint64(e.count)
+		if size <= 4 {
+			e.value = raw[8 : 8+size]
+		} else {
+			start := uint64(t.order.Uint32(raw[8:12]))
+			if start+size > uint64(len(t.data)) {
+				continue
+			}
+			e.value = t.data[start : start+size]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (t *tiff) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+func (t *tiff) uint(e ifdEntry) (uint32, bool) {
+	if e.count < 1 {
+		return 0, false
+	}
+	switch e.typ {
+	case 1:
+		return uint32(e.value[0]), true
+	case 3:
+		return uint32(t.order.Uint16(e.value)), true
+	case 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (t *tiff) rationals(e ifdEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	values := make([]float64, e.count)
+	for i := range values {
+		num := t.order.Uint32(e.value[i*8:])
+		den := t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return nil
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values
+}
+
+// degrees turns degrees, minutes and seconds into signed decimal degrees.
+func degrees(dms []float64, ref string, negative string, limit float64) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	d := dms[0] + dms[1]/60 + dms[2]/3600
+	if d > limit || math.IsNaN(d) {
+		return 0, false
+	}
+	if strings.EqualFold(ref, negative) {
+		d = -d
+	}
+	return d, true
+}
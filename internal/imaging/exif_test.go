@@ -0,0 +1,154 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+// testTIFF builds EXIF data with an orientation, a capture time and a
+// position.
+func testTIFF(orientation int) []byte {
+	b := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	// IFD0 at 8: orientation, Exif IFD, GPS IFD
+	b = binary.BigEndian.AppendUint16(b, 3)
+	b = appendEntry(b, tagOrientation, 3, 1, uint32(orientation)<<16)
+	b = appendEntry(b, tagExifIFD, 4, 1, 50)
+	b = appendEntry(b, tagGPSIFD, 4, 1, 88)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	// Exif IFD at 50: DateTimeOriginal
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = appendEntry(b, tagDateTimeOriginal, 2, 20, 68)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = append(b, "2024:05:01 12:30:00\x00"...)
+	// GPS IFD at 88: 48°51'N 2°21'E
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = appendEntry(b, tagGPSLatitudeRef, 2, 2, 'N'<<24)
+	b = appendEntry(b, tagGPSLatitude, 5, 3, 142)
+	b = appendEntry(b, tagGPSLongitudeRef, 2, 2, 'E'<<24)
+	b = appendEntry(b, tagGPSLongitude, 5, 3, 166)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	for _, v := range []uint32{48, 1, 51, 1, 0, 1, 2, 1, 21, 1, 0, 1} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func appendEntry(b []byte, tag uint16, typ uint16, count uint32, value uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, tag)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint32(b, count)
+	return binary.BigEndian.AppendUint32(b, value)
+}
+
+func testImage() image.Image {
+	return image.NewGray(image.Rect(0, 0, 4, 4))
+}
+
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	exif := append(bytes.Clone(exifHeader), testTIFF(6)...)
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")
+	var out []byte
+	out = append(out, b[:2]...)
+	out = appendSegment(out, jpegMarkerAPP1, exif)
+	out = appendSegment(out, jpegMarkerAPP1, xmp)
+	out = appendSegment(out, testMarkerCOM, []byte("comment"))
+	return append(out, b[2:]...)
+}
+
+// Marker of JPEG comments, which ReadExif skips
+const testMarkerCOM = 0xFE
+
+func appendSegment(b []byte, marker byte, data []byte) []byte {
+	b = append(b, 0xFF, marker)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)+2))
+	return append(b, data...)
+}
+
+func TestReadExif(t *testing.T) {
+	jpeg := testJPEG(t)
+	xmpFirst := appendSegment([]byte{0xFF, jpegMarkerSOI}, jpegMarkerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	xmpFirst = appendSegment(xmpFirst, jpegMarkerAPP1, append(bytes.Clone(exifHeader), testTIFF(3)...))
+	xmpFirst = append(xmpFirst, 0xFF, jpegMarkerEOI)
+	noExif := appendSegment([]byte{0xFF, jpegMarkerSOI}, testMarkerCOM, []byte("comment"))
+	noExif = append(noExif, 0xFF, jpegMarkerSOS)
+	badTIFF := appendSegment([]byte{0xFF, jpegMarkerSOI}, jpegMarkerAPP1, append(bytes.Clone(exifHeader), "XX\x00\x2a"...))
+
+	tests := []struct {
+		name        string
+		file        []byte
+		err         error
+		orientation int
+	}{
+		{name: "jpeg", file: jpeg, orientation: 6},
+		{name: "xmp before exif", file: xmpFirst, orientation: 3},
+		{name: "no exif", file: noExif, err: ErrNoExif},
+		{name: "not a jpeg", file: []byte("\x89PNG\r\n\x1a\n"), err: ErrNoExif},
+		{name: "truncated", file: jpeg[:30], err: ErrNoExif},
+		{name: "invalid tiff", file: badTIFF, err: ErrInvalidExif},
+		{name: "empty", file: nil, err: ErrNoExif},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exif, err := ReadExif(bytes.NewReader(tt.file))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exif.Orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", exif.Orientation, tt.orientation)
+			}
+			if exif.DateTimeOriginal != "2024:05:01 12:30:00" {
+				t.Errorf("DateTimeOriginal = %q", exif.DateTimeOriginal)
+			}
+			if exif.Latitude == nil || math.Abs(*exif.Latitude-48.85) > 1e-9 {
+				t.Errorf("latitude = %v, want 48.85", exif.Latitude)
+			}
+			if exif.Longitude == nil || math.Abs(*exif.Longitude-2.35) > 1e-9 {
+				t.Errorf("longitude = %v, want 2.35", exif.Longitude)
+			}
+		})
+	}
+}
+
+func TestExifCaptureTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		name string
+		exif Exif
+		want time.Time
+		ok   bool
+	}{
+		{name: "offset", exif: Exif{DateTimeOriginal: "2024:05:01 12:30:00", OffsetTimeOriginal: "-04:00"}, want: time.Date(2024, 5, 1, 16, 30, 0, 0, time.UTC), ok: true},
+		{name: "event time zone", exif: Exif{DateTimeOriginal: "2024:05:01 12:30:00"}, want: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), ok: true},
+		{name: "invalid offset", exif: Exif{DateTimeOriginal: "2024:05:01 12:30:00", OffsetTimeOriginal: "   :  "}, want: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), ok: true},
+		{name: "invalid", exif: Exif{DateTimeOriginal: "0000:00:00 00:00:00"}},
+		{name: "missing", exif: Exif{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.exif.CaptureTime(paris)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("CaptureTime = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// GIF is a header, the screen descriptor with an optional color table, then
+// blocks: images, extensions and the trailer. The data of images and
+// extensions is a chain of sub-blocks of up to 255 bytes. GIFs have no EXIF
+// data, XMP goes in an application extension.
+
+const (
+	gifExtension      = 0x21
+	gifImage          = 0x2C
+	gifTrailer        = 0x3B
+	gifCommentLabel   = 0xFE
+	gifAppLabel       = 0xFF
+	gifColorTableFlag = 0x80
+)
+
+var gifXMPApp = []byte("\x0BXMP DataXMP")
+
+// stripGIF drops the XMP application extension. With all set, comments go
+// too.
+func stripGIF(w io.Writer, br *bufio.Reader, all bool) (*Exif, bool, error) {
+	bw := bufio.NewWriter(w)
+	// Header and logical screen descriptor
+	screen := make([]byte, 13)
+	if _, err := io.ReadFull(br, screen); err != nil {
+		return nil, false, err
+	}
+	bw.Write(screen)
+	if err := copyColorTable(bw, br, screen[10]); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return nil, false, err
+		}
+		switch block {
+		case gifTrailer:
+			bw.WriteByte(block)
+			if _, err := io.Copy(bw, br); err != nil {
+				return nil, false, err
+			}
+			return nil, changed, bw.Flush()
+		case gifImage:
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(br, descriptor); err != nil {
+				return nil, false, err
+			}
+			bw.WriteByte(block)
+			bw.Write(descriptor)
+			if err := copyColorTable(bw, br, descriptor[8]); err != nil {
+				return nil, false, err
+			}
+			// LZW minimum code size
+			if _, err := io.CopyN(bw, br, 1); err != nil {
+				return nil, false, err
+			}
+			if err := copySubBlocks(bw, br); err != nil {
+				return nil, false, err
+			}
+		case gifExtension:
+			label, err := br.ReadByte()
+			if err != nil {
+				return nil, false, err
+			}
+			drop := all && label == gifCommentLabel
+			if label == gifAppLabel {
+				id, _ := br.Peek(len(gifXMPApp))
+				drop = bytes.Equal(id, gifXMPApp)
+			}
+			if drop {
+				changed = true
+				err = copySubBlocks(io.Discard, br)
+			} else {
+				bw.Write([]byte{block, label})
+				err = copySubBlocks(bw, br)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		default:
+			return nil, false, ErrCannotStrip
+		}
+	}
+}
+
+// copyColorTable copies the color table that follows a descriptor whose
+// packed fields say there is one.
+func copyColorTable(w io.Writer, br *bufio.Reader, packed byte) error {
+	if packed&gifColorTableFlag == 0 {
+		return nil
+	}
+	_, err := io.CopyN(w, br, 3<<(packed&0x07+1))
+	return err
+}
+
+// copySubBlocks copies a chain of sub-blocks up to its terminator.
+func copySubBlocks(w io.Writer, br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{size}); err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := io.CopyN(w, br, int64(size)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	dhashWidth  = 9
+	dhashHeight = 8
+)
+
+// DHash is the difference hash of an image: shrunk to 9x8 grayscale cells,
+// each bit tells whether a cell is brighter than its right neighbour.
+// Re-encoded, resized or slightly edited copies differ in only a few bits.
+// Every pixel is read, so pass a small rendition rather than the original.
+func DHash(img image.Image) uint64 {
+	var sums [dhashHeight][dhashWidth]uint64
+	var counts [dhashHeight][dhashWidth]uint64
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * dhashHeight / h
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * dhashWidth / w
+			sums[cy][cx] += uint64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			counts[cy][cx]++
+		}
+	}
+
+	var cells [dhashHeight][dhashWidth]uint64
+	for y := range dhashHeight {
+		for x := range dhashWidth {
+			if counts[y][x] > 0 {
+				cells[y][x] = sums[y][x] / counts[y][x]
+			}
+		}
+	}
+
+	var hash uint64
+	for y := range dhashHeight {
+		for x := range dhashWidth - 1 {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
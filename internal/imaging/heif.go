@@ -0,0 +1,382 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+// HEIF and AVIF are ISO base media files: a tree of boxes of a 32-bit size,
+// a 4 character type, then the body. The top level `meta` box lists the
+// items of the file, images and metadata alike, in `iinf`, and where their
+// bytes are in `iloc`: in the `mdat` box, or in an `idat` box of its own.
+// EXIF items are a 32-bit offset to the TIFF header then the EXIF data, XMP
+// items are of the MIME type of RDF.
+
+const xmpContentType = "application/rdf+xml"
+
+// heifExtent is the bytes of a metadata item, at an offset in the file or
+// in the idat box.
+type heifExtent struct {
+	start, end int64
+	exif       bool
+	inIdat     bool
+}
+
+// stripHEIF clears the GPS data of the EXIF item and zeroes XMP items, in
+// place so no offset changes. With all set, the EXIF item is zeroed too: the
+// orientation is a property of the image, not of its EXIF data. Files whose
+// metadata items come before the `meta` box can't be stripped in one pass,
+// like those whose items can't be read.
+func stripHEIF(w io.Writer, br *bufio.Reader, all bool) (*Exif, bool, error) {
+	var pos int64
+	for {
+		header := make([]byte, 8, 16)
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		switch size {
+		case 0:
+			// The box extends to the end of the file, nothing follows
+			if _, err := w.Write(header); err != nil {
+				return nil, false, err
+			}
+			_, err := io.Copy(w, br)
+			return nil, false, err
+		case 1:
+			header = header[:16]
+			if _, err := io.ReadFull(br, header[8:]); err != nil {
+				return nil, false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < int64(len(header)) {
+			return nil, false, ErrCannotStrip
+		}
+		if _, err := w.Write(header); err != nil {
+			return nil, false, err
+		}
+		if boxType != "meta" {
+			if _, err := io.CopyN(w, br, size-int64(len(header))); err != nil {
+				return nil, false, err
+			}
+			pos += size
+			continue
+		}
+
+		if size-int64(len(header)) > maxMetadataSize {
+			return nil, false, ErrCannotStrip
+		}
+		meta := make([]byte, size-int64(len(header)))
+		if _, err := io.ReadFull(br, meta); err != nil {
+			return nil, false, err
+		}
+		pos += size
+		extents, idat, err := heifMetadataExtents(meta)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var exif *Exif
+		var inFile []heifExtent
+		for _, e := range extents {
+			if !e.inIdat {
+				inFile = append(inFile, e)
+				continue
+			}
+			if e.end > int64(len(idat)) {
+				return nil, false, ErrCannotStrip
+			}
+			if parsed := stripHEIFItem(idat[e.start:e.end], e.exif, all); parsed != nil {
+				exif = parsed
+			}
+		}
+		if _, err := w.Write(meta); err != nil {
+			return nil, false, err
+		}
+		parsed, err := stripHEIFExtents(w, br, pos, inFile, all)
+		if parsed != nil {
+			exif = parsed
+		}
+		return exif, len(extents) > 0, err
+	}
+}
+
+// stripHEIFExtents copies the rest of the file from pos, stripping the
+// metadata items at the extents on the way.
+func stripHEIFExtents(w io.Writer, br *bufio.Reader, pos int64, extents []heifExtent, all bool) (*Exif, error) {
+	slices.SortFunc(extents, func(a, b heifExtent) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	var exif *Exif
+	for _, e := range extents {
+		if e.start < pos {
+			// Already written, or overlapping the previous item
+			return nil, ErrCannotStrip
+		}
+		if e.end-e.start > maxMetadataSize {
+			return nil, ErrCannotStrip
+		}
+		if _, err := io.CopyN(w, br, e.start-pos); err != nil {
+			return nil, err
+		}
+		item := make([]byte, e.end-e.start)
+		if _, err := io.ReadFull(br, item); err != nil {
+			return nil, err
+		}
+		if parsed := stripHEIFItem(item, e.exif, all); parsed != nil {
+			exif = parsed
+		}
+		if _, err := w.Write(item); err != nil {
+			return nil, err
+		}
+		pos = e.end
+	}
+	_, err := io.Copy(w, br)
+	return exif, err
+}
+
+// stripHEIFItem strips an EXIF or XMP item in place and returns the EXIF
+// data as it was.
+func stripHEIFItem(item []byte, isExif bool, all bool) *Exif {
+	if !isExif {
+		clear(item)
+		return nil
+	}
+	var exif *Exif
+	if len(item) >= 4 {
+		offset := int64(binary.BigEndian.Uint32(item))
+		if 4+offset <= int64(len(item)) {
+			tiff := item[4+offset:]
+			// The GPS data is cleared in place
+			_, exif, _ = stripExif(tiff, false)
+		}
+	}
+	if all {
+		clear(item)
+	}
+	return exif
+}
+
+// heifMetadataExtents finds the EXIF and XMP items of a meta box body and
+// where their bytes are. It returns the body of the idat box too, extents
+// in it are relative to its start.
+func heifMetadataExtents(meta []byte) ([]heifExtent, []byte, error) {
+	// meta is a full box, a version and flags come first
+	if len(meta) < 4 {
+		return nil, nil, ErrCannotStrip
+	}
+	metadata := map[uint32]bool{}
+	var iloc, idat []byte
+	err := heifChildren(meta[4:], func(boxType string, body []byte) error {
+		switch boxType {
+		case "iinf":
+			return parseIinf(body, metadata)
+		case "iloc":
+			iloc = body
+		case "idat":
+			idat = body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, idat, nil
+	}
+	if iloc == nil {
+		return nil, nil, ErrCannotStrip
+	}
+	extents, err := parseIloc(iloc, metadata)
+	return extents, idat, err
+}
+
+// heifChildren calls fn with the type and body of every box in b.
+func heifChildren(b []byte, fn func(boxType string, body []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return ErrCannotStrip
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return ErrCannotStrip
+		}
+		if err := fn(string(b[4:8]), b[headerSize:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// parseIinf records which items are EXIF (true) or XMP (false) data.
+func parseIinf(b []byte, metadata map[uint32]bool) error {
+	r := &boxReader{b: b}
+	version := r.uint(1)
+	r.skip(3)
+	if version == 0 {
+		r.skip(2)
+	} else {
+		r.skip(4)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return heifChildren(r.rest(), func(boxType string, body []byte) error {
+		if boxType != "infe" {
+			return nil
+		}
+		r := &boxReader{b: body}
+		version := r.uint(1)
+		r.skip(3)
+		// Earlier versions have no item type
+		if version < 2 {
+			return nil
+		}
+		idSize := 2
+		if version >= 3 {
+			idSize = 4
+		}
+		id := uint32(r.uint(idSize))
+		r.skip(2)
+		itemType := string(r.bytes(4))
+		r.cstring()
+		if r.err != nil {
+			return r.err
+		}
+		switch {
+		case itemType == "Exif":
+			metadata[id] = true
+		case itemType == "mime" && r.cstring() == xmpContentType:
+			metadata[id] = false
+		}
+		return nil
+	})
+}
+
+// parseIloc returns the extents of the metadata items.
+func parseIloc(b []byte, metadata map[uint32]bool) ([]heifExtent, error) {
+	r := &boxReader{b: b}
+	version := r.uint(1)
+	r.skip(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12), int(sizes>>8&0x0F)
+	baseOffsetSize, indexSize := int(sizes>>4&0x0F), int(sizes&0x0F)
+	if version == 0 {
+		indexSize = 0
+	}
+	// Item IDs and counts grew to 32 bits in version 2
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := r.uint(idSize)
+
+	var extents []heifExtent
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := uint32(r.uint(idSize))
+		method := uint64(0)
+		if version > 0 {
+			method = r.uint(2) & 0x0F
+		}
+		r.skip(2)
+		base := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+		isExif, ok := metadata[id]
+		for j := uint64(0); j < extentCount && r.err == nil; j++ {
+			r.skip(indexSize)
+			offset, length := r.uint(offsetSize), r.uint(lengthSize)
+			if !ok {
+				continue
+			}
+			// Items built from other items, and extents running to the end
+			// of the file, can't be told apart from image data
+			if method > 1 || length == 0 || base+offset+length < base+offset {
+				return nil, ErrCannotStrip
+			}
+			start := int64(base + offset)
+			if start < 0 || start+int64(length) < start {
+				return nil, ErrCannotStrip
+			}
+			extents = append(extents, heifExtent{
+				start:  start,
+				end:    start + int64(length),
+				exif:   isExif,
+				inIdat: method == 1,
+			})
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return extents, nil
+}
+
+// boxReader reads the fields of a box body. The first read past the end
+// sets err, later reads return zero.
+type boxReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *boxReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b)-r.pos {
+		r.err = ErrCannotStrip
+		return nil
+	}
+	r.pos += n
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *boxReader) skip(n int) {
+	r.bytes(n)
+}
+
+// uint reads a big endian unsigned integer of n bytes, 0 to 8.
+func (r *boxReader) uint(n int) uint64 {
+	if n > 8 {
+		r.err = ErrCannotStrip
+		return 0
+	}
+	var value uint64
+	for _, c := range r.bytes(n) {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
+
+// cstring reads a NUL terminated string.
+func (r *boxReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	s, _, found := bytes.Cut(r.b[r.pos:], []byte{0})
+	if !found {
+		r.err = ErrCannotStrip
+		return ""
+	}
+	r.pos += len(s) + 1
+	return string(s)
+}
+
+func (r *boxReader) rest() []byte {
+	return r.b[r.pos:]
+}
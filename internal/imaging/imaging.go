@@ -0,0 +1,172 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"slices"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Rendition is a downscaled copy of a photo whose longest side is at most
+// MaxSize pixels.
+type Rendition struct {
+	Name    string
+	MaxSize int
+}
+
+// Renditions are ordered from the smallest to the largest. They are encoded
+// as JPEG: x/image can decode WebP but there is no pure-Go WebP encoder.
+var Renditions = []Rendition{
+	{Name: "thumb", MaxSize: 256},
+	{Name: "medium", MaxSize: 1024},
+	{Name: "large", MaxSize: 2048},
+}
+
+const (
+	RenditionType = "image/jpeg"
+	jpegQuality   = 82
+	// Refuse to decode anything bigger, a small file can expand into a huge
+	// bitmap
+	maxPixels = 100_000_000
+)
+
+var ErrTooLarge = errors.New("image is too large to process")
+
+// Types that can be decoded to render renditions. HEIF and AVIF need cgo
+// decoders, their photos are kept without renditions.
+var decodableTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+func CanDecode(fileType string) bool {
+	return slices.Contains(decodableTypes, fileType)
+}
+
+// RenditionKey is the storage key of a photo's rendition.
+func RenditionKey(photoId string, name string) string {
+	return fmt.Sprintf("renditions/%s/%s.jpg", photoId, name)
+}
+
+// RenditionKeys returns the keys of every rendition a photo may have.
+func RenditionKeys(photoIds ...string) []string {
+	keys := make([]string, 0, len(photoIds)*len(Renditions))
+	for _, id := range photoIds {
+		for _, r := range Renditions {
+			keys = append(keys, RenditionKey(id, r.Name))
+		}
+	}
+	return keys
+}
+
+// Decode reads an image in any of the supported formats (JPEG, PNG, GIF,
+// WebP) after checking its dimensions are sane.
+func Decode(r io.ReadSeeker) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(r)
+}
+
+// Fit scales img down so its longest side is at most maxSize. Images that
+// already fit are returned as is.
+func Fit(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*maxSize/w)
+		w = maxSize
+	} else {
+		w = max(1, w*maxSize/h)
+		h = maxSize
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Orient turns an image stored with the given EXIF orientation upright.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Needs a quarter turn clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs a quarter turn counterclockwise
+				sx, sy = w-1-y, x
+			}
+			si := src.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+// Render produces the renditions smaller than the image itself, largest
+// first, each scaled from the previous one to keep it cheap. The smallest
+// rendition is always produced so every photo has a thumbnail.
+func Render(img image.Image, fn func(r Rendition, img image.Image) error) error {
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+
+	current := img
+	for i := len(Renditions) - 1; i >= 0; i-- {
+		r := Renditions[i]
+		if r.MaxSize >= longest && i > 0 {
+			continue
+		}
+		current = Fit(current, r.MaxSize)
+		if err := fn(r, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
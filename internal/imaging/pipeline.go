@@ -0,0 +1,338 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/storage"
+	"mercuria-backend/internal/video"
+)
+
+// Pipeline turns uploaded originals into renditions.
+type Pipeline struct {
+	db      database.Service
+	storage storage.Service
+}
+
+func NewPipeline(db database.Service, storage storage.Service) *Pipeline {
+	return &Pipeline{db: db, storage: storage}
+}
+
+// Process reads the metadata of a photo, strips the original as the event's
+// privacy setting asks, then renders and stores its renditions, upright, and
+// records their keys on it. Files that can't be decoded are only stripped,
+// videos are handled by processVideo. Photos deleted in the meantime are
+// skipped, and nothing is recorded once ctx is done.
+func (p *Pipeline) Process(ctx context.Context, photoId string) error {
+	photo, err := p.db.GetPhoto(photoId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	if photo.MediaType == database.MediaVideo {
+		return p.processVideo(ctx, photo)
+	}
+
+	settings, err := p.db.GetEventMediaSettings(photo.EventID)
+	if err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	if !CanDecode(photo.FileType) {
+		if err := p.stripUndecodable(ctx, photo, settings.MetadataPrivacy); err != nil {
+			return fmt.Errorf("[Process] strip %s: %w", photo.ID, err)
+		}
+		return nil
+	}
+
+	img, exif, err := p.decodeOriginal(ctx, photo, settings.MetadataPrivacy)
+	if err != nil {
+		return fmt.Errorf("[Process] decode %s: %w", photo.ID, err)
+	}
+	meta := metadata(photo, settings, img, exif)
+
+	// Scale down first, turning the full size original is costly
+	img = Orient(Fit(img, Renditions[len(Renditions)-1].MaxSize), exif.Orientation)
+
+	renditions, err := p.storeRenditions(ctx, photo.ID, img, &meta)
+	if err != nil {
+		return fmt.Errorf("[Process] render %s: %w", photo.ID, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	if err := p.db.SetPhotoMetadata(photo.ID, meta); err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	return p.db.SetPhotoRenditions(photo.ID, renditions)
+}
+
+// processVideo reads the duration and dimensions of a video from its
+// container, strips it as the event asks, then renders the renditions of
+// its poster frame. Without ffmpeg the video is kept without renditions.
+func (p *Pipeline) processVideo(ctx context.Context, photo *database.Photo) error {
+	settings, err := p.db.GetEventMediaSettings(photo.EventID)
+	if err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	original, err := p.spoolOriginal(ctx, photo)
+	if err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	defer os.Remove(original.Name())
+	defer original.Close()
+
+	info, err := video.Probe(contextReader{ctx, original}, photo.FileType)
+	if err != nil {
+		return fmt.Errorf("[Process] probe %s: %w", photo.ID, err)
+	}
+	if settings.MetadataPrivacy != database.MetadataKeep {
+		if err := p.stripOriginal(ctx, photo, original, settings.MetadataPrivacy == database.MetadataStripAll); err != nil {
+			return fmt.Errorf("[Process] strip %s: %w", photo.ID, err)
+		}
+	}
+	meta := database.PhotoMetadata{
+		CapturedAt: photo.CapturedAt,
+		Width:      info.Width,
+		Height:     info.Height,
+		DurationMs: info.DurationMs(),
+	}
+	if info.CreatedAt != nil {
+		meta.CapturedAt = info.CreatedAt
+	}
+
+	var renditions map[string]string
+	poster, err := video.Poster(ctx, original.Name(), info.Duration)
+	switch {
+	case errors.Is(err, video.ErrNoFFmpeg):
+	case ctx.Err() != nil:
+		return fmt.Errorf("[Process] %w", ctx.Err())
+	case err != nil:
+		// The video plays without a poster, no reason to fail the job
+		log.Printf("[Process] poster %s: %v", photo.ID, err)
+	default:
+		renditions, err = p.storeRenditions(ctx, photo.ID, Fit(poster, Renditions[len(Renditions)-1].MaxSize), &meta)
+		if err != nil {
+			return fmt.Errorf("[Process] render %s: %w", photo.ID, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	if err := p.db.SetPhotoMetadata(photo.ID, meta); err != nil {
+		return fmt.Errorf("[Process] %w", err)
+	}
+	if renditions == nil {
+		return nil
+	}
+	return p.db.SetPhotoRenditions(photo.ID, renditions)
+}
+
+// storeRenditions renders and stores the renditions of an upright image and
+// returns their keys. The smallest one gives the perceptual hash.
+func (p *Pipeline) storeRenditions(ctx context.Context, photoId string, img image.Image, meta *database.PhotoMetadata) (map[string]string, error) {
+	renditions := make(map[string]string, len(Renditions))
+	err := Render(img, func(r Rendition, img image.Image) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, img); err != nil {
+			return err
+		}
+		key := RenditionKey(photoId, r.Name)
+		if _, err := p.storage.UploadFile(&buf, int64(buf.Len()), key, RenditionType); err != nil {
+			return err
+		}
+		renditions[r.Name] = key
+		if r == Renditions[0] {
+			hash := int64(DHash(img))
+			meta.DHash = &hash
+		}
+		return nil
+	})
+	return renditions, err
+}
+
+// metadata combines the EXIF data with the decoded image. Capture times
+// without an offset are taken to be in the event's time zone. Originals
+// stripped on upload no longer have one, the time recorded then is kept.
+func metadata(photo *database.Photo, settings *database.EventMediaSettings, img image.Image, exif *Exif) database.PhotoMetadata {
+	bounds := img.Bounds()
+	meta := database.PhotoMetadata{
+		CameraModel: exif.Camera(),
+		Orientation: exif.Orientation,
+		Latitude:    exif.Latitude,
+		Longitude:   exif.Longitude,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+	}
+	if exif.Rotated() {
+		meta.Width, meta.Height = meta.Height, meta.Width
+	}
+
+	switch settings.MetadataPrivacy {
+	case database.MetadataStripAll:
+		meta.CameraModel = ""
+		fallthrough
+	case database.MetadataStripLocation:
+		meta.Latitude, meta.Longitude = nil, nil
+	}
+
+	meta.CapturedAt = photo.CapturedAt
+	if capturedAt, ok := exif.CaptureTime(eventLocation(settings)); ok {
+		meta.CapturedAt = &capturedAt
+	}
+	return meta
+}
+
+func eventLocation(settings *database.EventMediaSettings) *time.Location {
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ReadCaptureTime reads when a photo was taken from its EXIF data, for
+// uploads whose original is stripped before it is stored.
+func ReadCaptureTime(exif *Exif, settings *database.EventMediaSettings) *time.Time {
+	if exif == nil {
+		return nil
+	}
+	if capturedAt, ok := exif.CaptureTime(eventLocation(settings)); ok {
+		return &capturedAt
+	}
+	return nil
+}
+
+// decodeOriginal spools the original to a temporary file, reading the EXIF
+// data and decoding need separate passes. Images without (valid) EXIF data
+// get an empty one.
+func (p *Pipeline) decodeOriginal(ctx context.Context, photo *database.Photo, privacy database.MetadataPrivacy) (image.Image, *Exif, error) {
+	tmp, err := p.spoolOriginal(ctx, photo)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	exif, err := ReadExif(contextReader{ctx, tmp})
+	if err != nil {
+		exif = &Exif{}
+	}
+	if privacy != database.MetadataKeep {
+		if err := p.stripOriginal(ctx, photo, tmp, privacy == database.MetadataStripAll); err != nil {
+			return nil, nil, fmt.Errorf("strip: %w", err)
+		}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := Decode(contextReadSeeker{contextReader{ctx, tmp}, tmp})
+	return img, exif, err
+}
+
+// stripUndecodable strips the original of a photo that gets no renditions.
+func (p *Pipeline) stripUndecodable(ctx context.Context, photo *database.Photo, privacy database.MetadataPrivacy) error {
+	if privacy == database.MetadataKeep {
+		return nil
+	}
+	tmp, err := p.spoolOriginal(ctx, photo)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	return p.stripOriginal(ctx, photo, tmp, privacy == database.MetadataStripAll)
+}
+
+// stripOriginal replaces the stored original with a stripped copy, unless
+// there is nothing to strip. Uploads are stripped before their photo is
+// recorded, this covers events whose setting changed since.
+func (p *Pipeline) stripOriginal(ctx context.Context, photo *database.Photo, original *os.File, all bool) error {
+	if _, err := original.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "stripped-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var changed bool
+	if photo.MediaType == database.MediaVideo {
+		changed, err = video.StripMetadata(tmp, contextReader{ctx, original}, photo.FileType)
+	} else {
+		_, changed, err = StripMetadata(tmp, contextReader{ctx, original}, all)
+	}
+	if err != nil || !changed {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = p.storage.UploadFile(contextReader{ctx, tmp}, size, photo.ID, photo.FileType)
+	return err
+}
+
+// spoolOriginal copies the original of a photo to a temporary file, rewound.
+// The caller closes and removes it.
+func (p *Pipeline) spoolOriginal(ctx context.Context, photo *database.Photo) (*os.File, error) {
+	src, err := p.storage.DownloadFile(photo.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "original-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tmp, contextReader{ctx, src}); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// contextReader fails reads once ctx is done. Storage and decoding take
+// no context, their readers stop them when the job is cancelled.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(b)
+}
+
+// contextReadSeeker is a contextReader for Decode, which rewinds.
+type contextReadSeeker struct {
+	contextReader
+	io.Seeker
+}
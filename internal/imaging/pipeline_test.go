@@ -0,0 +1,85 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/storage"
+)
+
+// photoDB serves a single photo and records what the pipeline saves. Other
+// database methods are not used by processing.
+type photoDB struct {
+	database.Service
+	photo    *database.Photo
+	err      error
+	recorded bool
+}
+
+func (d *photoDB) GetPhoto(photoId string) (*database.Photo, error) {
+	return d.photo, d.err
+}
+
+func (d *photoDB) GetEventMediaSettings(eventId string) (*database.EventMediaSettings, error) {
+	return &database.EventMediaSettings{MetadataPrivacy: database.MetadataKeep}, nil
+}
+
+func (d *photoDB) SetPhotoMetadata(photoId string, meta database.PhotoMetadata) error {
+	d.recorded = true
+	return nil
+}
+
+func (d *photoDB) SetPhotoRenditions(photoId string, renditions map[string]string) error {
+	d.recorded = true
+	return nil
+}
+
+func TestProcess(t *testing.T) {
+	photo := &database.Photo{ID: "p1", FileType: "image/jpeg", MediaType: database.MediaPhoto}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantErr  error
+		recorded bool
+	}{
+		{name: "rendered", ctx: context.Background(), recorded: true},
+		{name: "photo deleted", ctx: context.Background(), err: database.ErrNotFound},
+		{name: "database down", ctx: context.Background(), err: errors.New("down"), wantErr: errors.New("down")},
+		{name: "cancelled", ctx: cancelled, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := storage.NewMemory()
+			original := testJPEG(t)
+			if _, err := files.UploadFile(bytes.NewReader(original), int64(len(original)), photo.ID, photo.FileType); err != nil {
+				t.Fatal(err)
+			}
+			db := &photoDB{photo: photo, err: tt.err}
+			if tt.err != nil {
+				db.photo = nil
+			}
+
+			err := NewPipeline(db, files).Process(tt.ctx, photo.ID)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(tt.wantErr, context.Canceled) && !errors.Is(err, context.Canceled) {
+				t.Errorf("err = %v, want context.Canceled", err)
+			}
+			if db.recorded != tt.recorded {
+				t.Errorf("recorded = %v, want %v", db.recorded, tt.recorded)
+			}
+			_, err = files.HeadFile(RenditionKey(photo.ID, Renditions[0].Name))
+			if rendered := err == nil; rendered != tt.recorded {
+				t.Errorf("rendition stored = %v, want %v", rendered, tt.recorded)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// PNG is a signature then chunks: a 32-bit length, a 4 character type, the
+// data and a CRC of the type and data. EXIF data has its own chunk, XMP and
+// the raw profiles some tools write go in text chunks.
+
+var pngSignature = []byte("\x89PNG\r\n\x1A\n")
+
+// Keywords of text chunks that hold XMP, or EXIF written by ImageMagick
+var pngMetadataKeywords = []string{"XML:com.adobe.xmp", "Raw profile type"}
+
+// stripPNG clears the GPS data of the eXIf chunk and drops the text chunks
+// holding XMP or raw profiles. With all set, every text chunk, the
+// modification time and the EXIF data, but for the orientation, go too.
+func stripPNG(w io.Writer, br *bufio.Reader, all bool) (*Exif, bool, error) {
+	if _, err := br.Discard(len(pngSignature)); err != nil {
+		return nil, false, err
+	}
+	if _, err := w.Write(pngSignature); err != nil {
+		return nil, false, err
+	}
+
+	var exif *Exif
+	changed := false
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return exif, changed, nil
+			}
+			return nil, false, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		switch {
+		case chunkType == "eXIf":
+			data, err := readChunkData(br, length)
+			if err != nil {
+				return nil, false, err
+			}
+			stripped, parsed, cleared := stripExif(data, all)
+			if parsed != nil {
+				exif = parsed
+			}
+			changed = changed || cleared
+			if stripped == nil {
+				continue
+			}
+			if err := writePNGChunk(w, chunkType, stripped); err != nil {
+				return nil, false, err
+			}
+		case isPNGText(chunkType) && all, chunkType == "tIME" && all:
+			changed = true
+			if _, err := br.Discard(int(length) + 4); err != nil {
+				return nil, false, err
+			}
+		case isPNGText(chunkType):
+			// The keyword ends at the first NUL, at most 79 bytes in
+			keyword, _ := br.Peek(int(min(length, 80)))
+			keyword, _, _ = bytes.Cut(keyword, []byte{0})
+			if !hasMetadataKeyword(string(keyword)) {
+				if err := copyChunk(w, br, header[:], length); err != nil {
+					return nil, false, err
+				}
+				continue
+			}
+			changed = true
+			if _, err := br.Discard(int(length) + 4); err != nil {
+				return nil, false, err
+			}
+		default:
+			if err := copyChunk(w, br, header[:], length); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+}
+
+func isPNGText(chunkType string) bool {
+	return chunkType == "tEXt" || chunkType == "iTXt" || chunkType == "zTXt"
+}
+
+func hasMetadataKeyword(keyword string) bool {
+	for _, prefix := range pngMetadataKeywords {
+		if strings.HasPrefix(keyword, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readChunkData reads the data of a chunk and skips its CRC, the chunk is
+// written again with a new one.
+func readChunkData(br *bufio.Reader, length int64) ([]byte, error) {
+	if length > maxMetadataSize {
+		return nil, ErrCannotStrip
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+	if _, err := br.Discard(4); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// copyChunk copies a chunk whose header was read, its data and CRC follow.
+func copyChunk(w io.Writer, br *bufio.Reader, header []byte, length int64) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, br, length+4)
+	return err
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	_, err := w.Write(chunk)
+	return err
+}
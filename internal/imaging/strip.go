@@ -0,0 +1,201 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	jpegMarkerAPP0  = 0xE0
+	jpegMarkerAPP2  = 0xE2
+	jpegMarkerAPP14 = 0xEE
+	jpegMarkerAPP15 = 0xEF
+	jpegMarkerCOM   = 0xFE
+)
+
+// ErrCannotStrip is returned by StripMetadata for files whose metadata it
+// can't remove without rewriting the image, they have to be refused.
+var ErrCannotStrip = errors.New("metadata can't be removed from this file")
+
+// Largest metadata block read into memory
+const maxMetadataSize = 16 << 20
+
+// StripMetadata copies an image from r to w without its location metadata:
+// the GPS IFD of the EXIF data is emptied and XMP, which may repeat the
+// position, is dropped. With all set, every metadata block goes, and only
+// the orientation survives so the image still displays upright. Blocks
+// describing the image itself (JFIF, ICC profile, Adobe) are kept.
+//
+// It handles JPEG, PNG, GIF, WebP and HEIF/AVIF, see the strip functions of
+// each for what they remove. It returns the EXIF data as it was before
+// stripping, and whether anything was removed. Other files are copied
+// unchanged.
+func StripMetadata(w io.Writer, r io.Reader, all bool) (*Exif, bool, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(12)
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		return stripPNG(w, br, all)
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		return stripGIF(w, br, all)
+	case len(head) == 12 && string(head[:4]) == "RIFF" && string(head[8:]) == "WEBP":
+		return stripWebP(w, br, all)
+	case len(head) == 12 && string(head[4:8]) == "ftyp":
+		return stripHEIF(w, br, all)
+	case len(head) < 2 || head[0] != 0xFF || head[1] != jpegMarkerSOI:
+		_, err := io.Copy(w, br)
+		return nil, false, err
+	}
+	return stripJPEG(w, br, all)
+}
+
+// stripJPEG strips the APP segments of a JPEG, see StripMetadata.
+func stripJPEG(w io.Writer, br *bufio.Reader, all bool) (*Exif, bool, error) {
+	if _, err := br.Discard(2); err != nil {
+		return nil, false, err
+	}
+	if _, err := w.Write([]byte{0xFF, jpegMarkerSOI}); err != nil {
+		return nil, false, err
+	}
+
+	var exif *Exif
+	changed := false
+	for {
+		marker, err := nextJPEGMarker(br)
+		if err != nil {
+			return nil, false, err
+		}
+		if isStandaloneMarker(marker) {
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if marker == jpegMarkerSOS || marker == jpegMarkerEOI {
+			// No metadata follows, the rest is image data
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return nil, false, err
+			}
+			_, err := io.Copy(w, br)
+			return exif, changed, err
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, false, err
+		}
+		length := int(binary.BigEndian.Uint16(size[:])) - 2
+		if length < 0 {
+			return nil, false, ErrInvalidExif
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return nil, false, err
+		}
+
+		keep := true
+		switch {
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(segment, exifHeader):
+			stripped, parsed, cleared := stripExif(segment[len(exifHeader):], all)
+			if parsed != nil {
+				exif = parsed
+			}
+			if stripped == nil {
+				keep = false
+			} else {
+				segment = append(bytes.Clone(exifHeader), stripped...)
+				changed = changed || cleared
+			}
+		case marker == jpegMarkerAPP1:
+			// XMP
+			keep = false
+		case all && marker >= jpegMarkerAPP0 && marker <= jpegMarkerAPP15:
+			keep = marker == jpegMarkerAPP0 || marker == jpegMarkerAPP2 || marker == jpegMarkerAPP14
+		case all && marker == jpegMarkerCOM:
+			keep = false
+		}
+		if !keep {
+			changed = true
+			continue
+		}
+
+		header := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+		if _, err := w.Write(header); err != nil {
+			return nil, false, err
+		}
+		if _, err := w.Write(segment); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// clearGPS empties the GPS IFD of a TIFF structure in place, so offsets
+// elsewhere stay valid. It reports whether there was anything to clear.
+func clearGPS(data []byte) bool {
+	t, ifd0, err := parseTIFFHeader(data)
+	if err != nil {
+		return false
+	}
+	entries, err := t.readIFD(ifd0)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.tag != tagGPSIFD {
+			continue
+		}
+		offset, ok := t.uint(e)
+		if !ok {
+			return false
+		}
+		gps, err := t.readIFD(offset)
+		if err != nil || len(gps) == 0 {
+			return false
+		}
+		for _, g := range gps {
+			clear(g.value)
+		}
+		// Zero entries and the next IFD offset, leaving an empty IFD
+		n := int(t.order.Uint16(data[offset:]))
+		clear(data[offset:min(int(offset)+2+n*12+4, len(data))])
+		return true
+	}
+	return false
+}
+
+// stripExif strips the TIFF structure of EXIF data: with all set it is
+// replaced by one holding only the orientation, nil when there is none to
+// keep, otherwise its GPS IFD is emptied in place. It returns the data as
+// it was before, parsed, and whether anything changed.
+func stripExif(data []byte, all bool) ([]byte, *Exif, bool) {
+	exif, err := ParseExif(data)
+	if err != nil {
+		exif = nil
+	}
+	if !all {
+		return data, exif, clearGPS(data)
+	}
+	if exif == nil || exif.Orientation <= 1 {
+		return nil, exif, true
+	}
+	minimal := orientationTIFF(exif.Orientation)
+	return minimal, exif, !bytes.Equal(data, minimal)
+}
+
+// orientationTIFF builds EXIF data with nothing but the orientation.
+func orientationTIFF(orientation int) []byte {
+	segment := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	// IFD0 with a single SHORT entry and no next IFD
+	segment = binary.BigEndian.AppendUint16(segment, 1)
+	segment = binary.BigEndian.AppendUint16(segment, tagOrientation)
+	segment = binary.BigEndian.AppendUint16(segment, 3)
+	segment = binary.BigEndian.AppendUint32(segment, 1)
+	segment = binary.BigEndian.AppendUint16(segment, uint16(orientation))
+	segment = binary.BigEndian.AppendUint16(segment, 0)
+	segment = binary.BigEndian.AppendUint32(segment, 0)
+	return segment
+}
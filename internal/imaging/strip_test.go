@@ -0,0 +1,263 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// After the signature and IHDR
+	ihdrEnd := 8 + 8 + 13 + 4
+	out := bytes.Clone(b[:ihdrEnd])
+	out = appendChunk(out, "eXIf", testTIFF(6))
+	out = appendChunk(out, "tEXt", []byte("Comment\x00hello"))
+	out = appendChunk(out, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+	out = appendChunk(out, "tIME", []byte{0x07, 0xE8, 5, 1, 12, 30, 0})
+	return append(out, b[ihdrEnd:]...)
+}
+
+func appendChunk(b []byte, chunkType string, data []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, chunkType...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start+4:]))
+}
+
+func testGIF(t *testing.T) []byte {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// After the header, screen descriptor and its two color table
+	headerEnd := 13 + 3*2
+	out := bytes.Clone(b[:headerEnd])
+	out = append(out, gifExtension, gifAppLabel)
+	out = append(out, gifXMPApp...)
+	out = append(out, 5, '<', 'x', 'm', 'p', '>', 0)
+	out = append(out, gifExtension, gifCommentLabel, 5, 'h', 'e', 'l', 'l', 'o', 0)
+	return append(out, b[headerEnd:]...)
+}
+
+// testWebP builds an extended WebP with EXIF and XMP chunks. The image data
+// is not a valid bitstream, stripping does not read it.
+func testWebP() []byte {
+	var chunks []byte
+	chunks = appendRIFFChunk(chunks, "VP8X", []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 3, 0, 0, 3, 0, 0})
+	chunks = appendRIFFChunk(chunks, "VP8 ", []byte("not really an image"))
+	chunks = appendRIFFChunk(chunks, "EXIF", testTIFF(1))
+	chunks = appendRIFFChunk(chunks, "XMP ", []byte("<x:xmpmeta/>"))
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(chunks)+4))
+	out = append(out, "WEBP"...)
+	return append(out, chunks...)
+}
+
+func appendRIFFChunk(b []byte, chunkType string, data []byte) []byte {
+	b = append(b, chunkType...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// testHEIF builds a HEIF file whose EXIF and XMP items are in the mdat box,
+// after the meta box.
+func testHEIF() []byte {
+	exifItem := binary.BigEndian.AppendUint32(nil, uint32(len(exifHeader)))
+	exifItem = append(exifItem, exifHeader...)
+	exifItem = append(exifItem, testTIFF(1)...)
+	xmpItem := []byte("<x:xmpmeta>48.85,2.35</x:xmpmeta>")
+
+	infe := func(id uint16, itemType string, contentType string) []byte {
+		b := []byte{2, 0, 0, 0}
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0)
+		b = append(b, itemType...)
+		b = append(b, 0)
+		if contentType != "" {
+			b = append(b, contentType...)
+			b = append(b, 0)
+		}
+		return box("infe", b)
+	}
+	iinf := []byte{0, 0, 0, 0, 0, 3}
+	iinf = append(iinf, infe(1, "av01", "")...)
+	iinf = append(iinf, infe(2, "Exif", "")...)
+	iinf = append(iinf, infe(3, "mime", xmpContentType)...)
+
+	ftyp := box("ftyp", []byte("avif\x00\x00\x00\x00mif1avif"))
+	// The meta box size doesn't depend on the offsets, build it twice
+	buildMeta := func(mdatStart uint32) []byte {
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00}
+		iloc = binary.BigEndian.AppendUint16(iloc, 3)
+		offsets := []uint32{mdatStart, mdatStart + 4, mdatStart + 4 + uint32(len(exifItem))}
+		lengths := []uint32{4, uint32(len(exifItem)), uint32(len(xmpItem))}
+		for i := range offsets {
+			iloc = binary.BigEndian.AppendUint16(iloc, uint16(i+1))
+			iloc = binary.BigEndian.AppendUint16(iloc, 0)
+			iloc = binary.BigEndian.AppendUint16(iloc, 1)
+			iloc = binary.BigEndian.AppendUint32(iloc, offsets[i])
+			iloc = binary.BigEndian.AppendUint32(iloc, lengths[i])
+		}
+		body := []byte{0, 0, 0, 0}
+		body = append(body, box("hdlr", make([]byte, 24))...)
+		body = append(body, box("iinf", iinf)...)
+		body = append(body, box("iloc", iloc)...)
+		return box("meta", body)
+	}
+	meta := buildMeta(0)
+	mdatStart := uint32(len(ftyp) + len(meta) + 8)
+	meta = buildMeta(mdatStart)
+
+	mdat := []byte("AV1!")
+	mdat = append(mdat, exifItem...)
+	mdat = append(mdat, xmpItem...)
+
+	out := append(ftyp, meta...)
+	return append(out, box("mdat", mdat)...)
+}
+
+func box(boxType string, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(body)+8))
+	b = append(b, boxType...)
+	return append(b, body...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		all  bool
+		// What must be gone, and what must be kept
+		gone []string
+		kept []string
+		// Orientation kept in the EXIF data of the output, 0 for no EXIF
+		// data left
+		exifOrientation int
+	}{
+		{name: "jpeg location", file: testJPEG(t), gone: []string{"xmpmeta"}, kept: []string{"2024:05:01", "comment"}, exifOrientation: 6},
+		{name: "jpeg all", file: testJPEG(t), all: true, gone: []string{"xmpmeta", "2024:05:01", "comment"}, exifOrientation: 6},
+		{name: "png location", file: testPNG(t), gone: []string{"xmpmeta"}, kept: []string{"2024:05:01", "Comment", "tIME"}, exifOrientation: 6},
+		{name: "png all", file: testPNG(t), all: true, gone: []string{"xmpmeta", "2024:05:01", "Comment", "tIME"}, exifOrientation: 6},
+		{name: "gif location", file: testGIF(t), gone: []string{"XMP Data"}, kept: []string{"hello"}},
+		{name: "gif all", file: testGIF(t), all: true, gone: []string{"XMP Data", "hello"}},
+		{name: "webp location", file: testWebP(), gone: []string{"xmpmeta"}, kept: []string{"2024:05:01"}, exifOrientation: 1},
+		{name: "webp all", file: testWebP(), all: true, gone: []string{"xmpmeta", "2024:05:01"}},
+		{name: "heif location", file: testHEIF(), gone: []string{"48.85"}, kept: []string{"2024:05:01"}, exifOrientation: 1},
+		{name: "heif all", file: testHEIF(), all: true, gone: []string{"48.85", "2024:05:01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			exif, changed, err := StripMetadata(&out, bytes.NewReader(tt.file), tt.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Error("changed = false")
+			}
+			if tt.exifOrientation > 0 && (exif == nil || exif.Latitude == nil) {
+				t.Errorf("exif = %+v, want the position as it was", exif)
+			}
+			for _, s := range tt.gone {
+				if bytes.Contains(out.Bytes(), []byte(s)) {
+					t.Errorf("%q is still there", s)
+				}
+			}
+			for _, s := range tt.kept {
+				if !bytes.Contains(out.Bytes(), []byte(s)) {
+					t.Errorf("%q is gone", s)
+				}
+			}
+
+			// The position is gone from what EXIF data is left
+			var again bytes.Buffer
+			left, _, err := StripMetadata(&again, bytes.NewReader(out.Bytes()), tt.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left != nil && left.Latitude != nil {
+				t.Errorf("latitude = %v, want none", *left.Latitude)
+			}
+			if tt.exifOrientation > 0 && (left == nil || left.Orientation != tt.exifOrientation) {
+				t.Errorf("exif = %+v, want orientation %d", left, tt.exifOrientation)
+			}
+			if tt.exifOrientation == 0 && left != nil {
+				t.Errorf("exif = %+v, want none", left)
+			}
+		})
+	}
+}
+
+func TestStripMetadataDecodes(t *testing.T) {
+	for name, file := range map[string][]byte{"jpeg": testJPEG(t), "png": testPNG(t), "gif": testGIF(t)} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, _, err := StripMetadata(&out, bytes.NewReader(file), true); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := image.Decode(&out); err != nil {
+				t.Errorf("decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataKeepsSize(t *testing.T) {
+	for name, file := range map[string][]byte{"webp": testWebP(), "heif": testHEIF()} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, _, err := StripMetadata(&out, bytes.NewReader(file), true); err != nil {
+				t.Fatal(err)
+			}
+			if out.Len() != len(file) {
+				t.Errorf("size = %d, want %d", out.Len(), len(file))
+			}
+		})
+	}
+}
+
+func TestStripMetadataHEIFMetaLast(t *testing.T) {
+	file := testHEIF()
+	// Move the meta box after mdat, its offsets now point back
+	ftyp := file[:24]
+	metaSize := binary.BigEndian.Uint32(file[24:])
+	meta := file[24 : 24+metaSize]
+	mdat := file[24+metaSize:]
+	moved := append(append(bytes.Clone(ftyp), mdat...), meta...)
+
+	var out bytes.Buffer
+	_, _, err := StripMetadata(&out, bytes.NewReader(moved), false)
+	if !errors.Is(err, ErrCannotStrip) {
+		t.Errorf("err = %v, want ErrCannotStrip", err)
+	}
+}
+
+func TestStripMetadataOtherFiles(t *testing.T) {
+	file := []byte("plain text, not an image")
+	var out bytes.Buffer
+	exif, changed, err := StripMetadata(&out, bytes.NewReader(file), true)
+	if err != nil || changed || exif != nil {
+		t.Fatalf("StripMetadata = %v, %v, %v", exif, changed, err)
+	}
+	if !bytes.Equal(out.Bytes(), file) {
+		t.Error("file was changed")
+	}
+}
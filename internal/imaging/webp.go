@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// WebP is a RIFF file: "RIFF", the 32-bit little endian size of the rest,
+// "WEBP", then chunks of a 4 character type, a size and the data, padded to
+// an even length. EXIF and XMP chunks follow the image data, their presence
+// is flagged in the VP8X chunk up front.
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+// stripWebP clears the GPS data of the EXIF chunk and drops the XMP chunk.
+// With all set, the EXIF chunk goes too: WebP decoders ignore its
+// orientation. The file size is written before the chunks are read, so
+// dropped chunks become zeroed JUNK chunks of the same size, which readers
+// skip.
+func stripWebP(w io.Writer, br *bufio.Reader, all bool) (*Exif, bool, error) {
+	if _, err := io.CopyN(w, br, 12); err != nil {
+		return nil, false, err
+	}
+
+	var exif *Exif
+	changed := false
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return exif, changed, nil
+			}
+			return nil, false, err
+		}
+		chunkType := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:])) + int64(header[4]&1)
+
+		if chunkType != "VP8X" && chunkType != "EXIF" && chunkType != "XMP " {
+			if _, err := w.Write(header); err != nil {
+				return nil, false, err
+			}
+			if _, err := io.CopyN(w, br, size); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		if size > maxMetadataSize {
+			return nil, false, ErrCannotStrip
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, false, err
+		}
+		switch chunkType {
+		case "VP8X":
+			if len(data) > 0 {
+				flags := data[0] &^ webpFlagXMP
+				if all {
+					flags &^= webpFlagEXIF
+				}
+				changed = changed || flags != data[0]
+				data[0] = flags
+			}
+		case "EXIF":
+			// Some writers keep the JPEG header
+			tiff := bytes.TrimPrefix(data, exifHeader)
+			// The GPS data is cleared in place
+			_, parsed, cleared := stripExif(tiff, false)
+			if parsed != nil {
+				exif = parsed
+			}
+			changed = changed || cleared || all
+			if all {
+				copy(header, "JUNK")
+				clear(data)
+			}
+		case "XMP ":
+			changed = true
+			copy(header, "JUNK")
+			clear(data)
+		}
+		if _, err := w.Write(header); err != nil {
+			return nil, false, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, false, err
+		}
+	}
+}
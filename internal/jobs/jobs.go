@@ -0,0 +1,203 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/export"
+	"mercuria-backend/internal/imaging"
+	"mercuria-backend/internal/queue"
+	"mercuria-backend/internal/storage"
+)
+
+// Job types shared by the API, which enqueues them, and the worker, which
+// runs them.
+const (
+	PhotoRenditions = "photo.renditions"
+	// Periodic, deletes photos past their trash retention
+	PhotoPurge = "photo.purge"
+	// Builds the ZIP archive of an event or album
+	EventExport = "event.export"
+	// Periodic, deletes exports past their retention
+	ExportPurge = "export.purge"
+	// Periodic, retries the files purges failed to delete
+	FilePurge = "file.purge"
+)
+
+const (
+	purgeInterval  = time.Hour
+	purgeBatchSize = 100
+)
+
+type PhotoPayload struct {
+	PhotoID string `json:"photo_id"`
+}
+
+type ExportPayload struct {
+	ExportID string `json:"export_id"`
+}
+
+// Register adds the handlers of every job type to the worker.
+func Register(w *queue.Worker, db database.Service, storage storage.Service) {
+	pipeline := imaging.NewPipeline(db, storage)
+	archiver := export.NewArchiver(db, storage)
+
+	w.Handle(PhotoRenditions, func(ctx context.Context, job *queue.Job) error {
+		var payload PhotoPayload
+		if err := job.Decode(&payload); err != nil {
+			return fmt.Errorf("[PhotoRenditions] %v", err)
+		}
+		return pipeline.Process(ctx, payload.PhotoID)
+	})
+
+	w.Handle(PhotoPurge, func(ctx context.Context, job *queue.Job) error {
+		return purgeTrash(ctx, db, storage)
+	})
+	w.Every(PhotoPurge, purgeInterval)
+
+	w.Handle(EventExport, func(ctx context.Context, job *queue.Job) error {
+		var payload ExportPayload
+		if err := job.Decode(&payload); err != nil {
+			return fmt.Errorf("[EventExport] %v", err)
+		}
+		return buildExport(ctx, db, storage, archiver, payload.ExportID, job.Attempts >= job.MaxAttempts)
+	})
+
+	w.Handle(ExportPurge, func(ctx context.Context, job *queue.Job) error {
+		return purgeExports(ctx, db, storage)
+	})
+	w.Every(ExportPurge, purgeInterval)
+
+	w.Handle(FilePurge, func(ctx context.Context, job *queue.Job) error {
+		return purgeOrphanedFiles(ctx, db, storage)
+	})
+	w.Every(FilePurge, purgeInterval)
+}
+
+// purgeTrash deletes the photos trashed for longer than the retention
+// period, then their files. The rows go first so no photo points at
+// nothing, files that fail to delete are recorded for FilePurge to retry.
+func purgeTrash(ctx context.Context, db database.Service, storage storage.Service) error {
+	before := time.Now().Add(-database.TrashRetention)
+	for ctx.Err() == nil {
+		ids, err := db.PurgeTrashedPhotos(before, purgeBatchSize)
+		if err != nil {
+			return fmt.Errorf("[PhotoPurge] %v", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		keys := append(ids, imaging.RenditionKeys(ids...)...)
+		if err := deleteFiles(db, storage, keys); err != nil {
+			return fmt.Errorf("[PhotoPurge] %v", err)
+		}
+		log.Printf("[PhotoPurge] purged %d photos", len(ids))
+	}
+	return ctx.Err()
+}
+
+// buildExport builds the archive of an export. Exports deleted or built in
+// the meantime are skipped. The export is marked failed on the last attempt
+// only, it is still running as far as members know until then.
+func buildExport(ctx context.Context, db database.Service, storage storage.Service, archiver *export.Archiver, exportId string, lastAttempt bool) error {
+	e, err := db.GetExport(exportId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[EventExport] %v", err)
+	}
+	err = db.StartExport(e.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[EventExport] %v", err)
+	}
+
+	source := export.Source{EventID: e.EventID}
+	if e.AlbumID != nil {
+		source.AlbumID = *e.AlbumID
+	}
+	count, size, err := archiver.Build(ctx, e.ID, source)
+	if err != nil {
+		// The cause stays in the logs, members only learn that it failed
+		if lastAttempt {
+			if err := db.FailExport(e.ID, "building the archive failed"); err != nil {
+				log.Printf("[EventExport] %v", err)
+			}
+		}
+		return fmt.Errorf("[EventExport] %v", err)
+	}
+
+	err = db.CompleteExport(e.ID, count, size)
+	if errors.Is(err, database.ErrNotFound) {
+		return storage.DeleteFiles(export.Key(e.ID))
+	}
+	if err != nil {
+		return fmt.Errorf("[EventExport] %v", err)
+	}
+	log.Printf("[EventExport] built %s with %d photos", e.ID, count)
+	return nil
+}
+
+// purgeExports deletes the exports past their retention, then their
+// archives, recording those that fail to delete like purgeTrash.
+func purgeExports(ctx context.Context, db database.Service, storage storage.Service) error {
+	for ctx.Err() == nil {
+		ids, err := db.PurgeExpiredExports(time.Now(), purgeBatchSize)
+		if err != nil {
+			return fmt.Errorf("[ExportPurge] %v", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(ids))
+		for _, id := range ids {
+			keys = append(keys, export.Key(id))
+		}
+		if err := deleteFiles(db, storage, keys); err != nil {
+			return fmt.Errorf("[ExportPurge] %v", err)
+		}
+		log.Printf("[ExportPurge] purged %d exports", len(ids))
+	}
+	return ctx.Err()
+}
+
+// purgeOrphanedFiles deletes the files recorded by deleteFiles. Keys stay
+// recorded while storage keeps failing, for the next run.
+func purgeOrphanedFiles(ctx context.Context, db database.Service, storage storage.Service) error {
+	for ctx.Err() == nil {
+		keys, err := db.ListOrphanedFiles(purgeBatchSize)
+		if err != nil {
+			return fmt.Errorf("[FilePurge] %v", err)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := storage.DeleteFiles(keys...); err != nil {
+			return fmt.Errorf("[FilePurge] %v", err)
+		}
+		if err := db.ForgetOrphanedFiles(keys); err != nil {
+			return fmt.Errorf("[FilePurge] %v", err)
+		}
+		log.Printf("[FilePurge] deleted %d orphaned files", len(keys))
+	}
+	return ctx.Err()
+}
+
+// deleteFiles deletes the files of purged rows. On failure the keys are
+// recorded, the rows are gone and nothing else would point at them. An
+// error means they could not be recorded either.
+func deleteFiles(db database.Service, storage storage.Service, keys []string) error {
+	err := storage.DeleteFiles(keys...)
+	if err == nil {
+		return nil
+	}
+	log.Printf("[deleteFiles] %v", err)
+	return db.RecordOrphanedFiles(keys)
+}
@@ -0,0 +1,146 @@
+package media
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SniffLen is how many leading bytes Sniff needs at most.
+const SniffLen = 512
+
+// Types are the formats accepted for upload.
+var Types = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/heic",
+	"image/heif",
+	"image/avif",
+	"video/mp4",
+	"video/quicktime",
+	"video/webm",
+}
+
+// Other names clients send for the accepted types
+var aliases = map[string]string{
+	"image/jpg":           "image/jpeg",
+	"image/pjpeg":         "image/jpeg",
+	"image/x-png":         "image/png",
+	"image/heic-sequence": "image/heic",
+	"image/heif-sequence": "image/heif",
+	"video/x-m4v":         "video/mp4",
+	"video/mov":           "video/quicktime",
+}
+
+const maxFileNameLen = 255
+
+// Allowed reports whether files of the type may be uploaded.
+func Allowed(fileType string) bool {
+	return slices.Contains(Types, fileType)
+}
+
+// Normalize lowercases a media type, drops its parameters and resolves
+// aliases, so declared types compare to sniffed ones.
+func Normalize(fileType string) string {
+	t, _, err := mime.ParseMediaType(fileType)
+	if err != nil {
+		t = strings.ToLower(strings.TrimSpace(fileType))
+	}
+	if alias, ok := aliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+// Sniff detects the type of a file from its first bytes. It returns "" for
+// anything that is not one of Types.
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1A\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return "image/webp"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return sniffFtyp(head)
+	case bytes.HasPrefix(head, []byte("\x1A\x45\xDF\xA3")):
+		// EBML, WebM unless the doc type says Matroska
+		if bytes.Contains(head, []byte("webm")) {
+			return "video/webm"
+		}
+	}
+	return ""
+}
+
+// sniffFtyp reads the brands of an ISO base media file (HEIF, AVIF, MP4,
+// QuickTime). The major brand decides, then the compatible ones in order.
+func sniffFtyp(head []byte) string {
+	size := int(uint32(head[0])<<24 | uint32(head[1])<<16 | uint32(head[2])<<8 | uint32(head[3]))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		case "qt  ":
+			return "video/quicktime"
+		case "isom", "iso2", "iso3", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "mmp4", "dash":
+			return "video/mp4"
+		}
+	}
+	return ""
+}
+
+// SanitizeFileName turns a client supplied name into a plain file name: no
+// directories, control characters or characters that are special in common
+// file systems, and at most 255 bytes, keeping the extension.
+func SanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r) || r == unicode.ReplacementChar:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	if len(name) > maxFileNameLen {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := name[:len(name)-len(ext)]
+		cut := maxFileNameLen - len(ext)
+		for cut > 0 && !utf8.RuneStart(base[cut]) {
+			cut--
+		}
+		name = base[:cut] + ext
+	}
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
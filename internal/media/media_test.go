@@ -0,0 +1,102 @@
+package media
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSniff(t *testing.T) {
+	ftyp := func(brands string) []byte {
+		b := []byte{0, 0, 0, byte(8 + len(brands))}
+		return append(append(b, "ftyp"...), brands...)
+	}
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "jpeg", head: []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"), want: "image/jpeg"},
+		{name: "png", head: []byte("\x89PNG\r\n\x1A\n\x00\x00\x00\x0DIHDR"), want: "image/png"},
+		{name: "gif87a", head: []byte("GIF87a"), want: "image/gif"},
+		{name: "gif89a", head: []byte("GIF89a"), want: "image/gif"},
+		{name: "webp", head: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), want: "image/webp"},
+		{name: "wav", head: []byte("RIFF\x24\x00\x00\x00WAVEfmt "), want: ""},
+		{name: "heic", head: ftyp("heic\x00\x00\x00\x00mif1heic"), want: "image/heic"},
+		{name: "heif", head: ftyp("mif1\x00\x00\x00\x00mif1"), want: "image/heif"},
+		{name: "avif", head: ftyp("avif\x00\x00\x00\x00mif1avif"), want: "image/avif"},
+		{name: "avif compatible brand", head: ftyp("mif1\x00\x00\x00\x00avifmif1"), want: "image/heif"},
+		{name: "mp4", head: ftyp("isom\x00\x00\x02\x00isomiso2mp41"), want: "video/mp4"},
+		{name: "quicktime", head: ftyp("qt  \x00\x00\x02\x00qt  "), want: "video/quicktime"},
+		{name: "unknown brand", head: ftyp("3gp4\x00\x00\x00\x00"), want: ""},
+		{name: "unknown major, known compatible", head: ftyp("XXXX\x00\x00\x00\x00XXXXmp42"), want: "video/mp4"},
+		{name: "ftyp size past the head", head: []byte("\x00\x00\x01\x00ftypisom"), want: "video/mp4"},
+		{name: "webm", head: []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm"), want: "video/webm"},
+		{name: "matroska", head: []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x88matroska"), want: ""},
+		{name: "text", head: []byte("<svg xmlns=\"http://www.w3.org/2000/svg\">"), want: ""},
+		{name: "short", head: []byte("\xFF\xD8"), want: ""},
+		{name: "empty", head: nil, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sniff(tt.head); got != tt.want {
+				t.Errorf("Sniff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"image/jpeg":                 "image/jpeg",
+		"IMAGE/JPEG":                 "image/jpeg",
+		"image/jpg":                  "image/jpeg",
+		"video/mp4; codecs=avc1":     "video/mp4",
+		" video/quicktime ":          "video/quicktime",
+		"video/mov":                  "video/quicktime",
+		"image/heic-sequence":        "image/heic",
+		"application/octet-stream":   "application/octet-stream",
+		"not a ; valid = media type": "not a ; valid = media type",
+	}
+	for fileType, want := range tests {
+		if got := Normalize(fileType); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", fileType, got, want)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	long := strings.Repeat("a", 300) + ".jpeg"
+	longUnicode := strings.Repeat("é", 200) + ".jpeg"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "IMG_0001.JPG", want: "IMG_0001.JPG"},
+		{name: "unicode", in: "été à Paris.heic", want: "été à Paris.heic"},
+		{name: "directories", in: "../../etc/passwd", want: "passwd"},
+		{name: "windows directories", in: `C:\Users\me\photo.png`, want: "photo.png"},
+		{name: "special characters", in: `a<b>c:d"e|f?g*h.jpg`, want: "a_b_c_d_e_f_g_h.jpg"},
+		{name: "control characters", in: "a\x00b\nc\x7F.jpg", want: "abc.jpg"},
+		{name: "invalid utf-8", in: "a\xFFb.jpg", want: "ab.jpg"},
+		{name: "leading and trailing dots and spaces", in: " . photo.jpg . ", want: "photo.jpg"},
+		{name: "dots only", in: "..", want: "untitled"},
+		{name: "empty", in: "", want: "untitled"},
+		{name: "root", in: "/", want: "_"},
+		{name: "too long", in: long, want: strings.Repeat("a", 250) + ".jpeg"},
+		{name: "too long, long extension", in: "a." + strings.Repeat("b", 300), want: ("a." + strings.Repeat("b", 300))[:255]},
+		{name: "too long, multibyte", in: longUnicode, want: strings.Repeat("é", 125) + ".jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeFileName(tt.in)
+			if got != tt.want {
+				t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if len(got) > maxFileNameLen || !utf8.ValidString(got) {
+				t.Errorf("SanitizeFileName(%q) = %q, too long or invalid", tt.in, got)
+			}
+		})
+	}
+}
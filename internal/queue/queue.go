@@ -0,0 +1,411 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	redisService "mercuria-backend/internal/redis"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+// Jobs are stored in Redis:
+//
+//	queue:jobs      hash of job ID => job JSON
+//	queue:attempts  hash of job ID => times the job was handed out
+//	queue:ready     list of job IDs waiting for a worker
+//	queue:delayed   sorted set of job IDs scored by when they may run
+//	queue:inflight  sorted set of job IDs scored by their visibility deadline
+//	queue:dead      list of job IDs that ran out of attempts
+//	queue:every:*   set while a periodic job of the type is not due again
+//
+// A dequeued job stays invisible until its deadline, which its worker
+// extends while the job runs. Jobs that are neither acknowledged nor failed
+// by then, e.g. because the worker crashed, are handed out again.
+const (
+	jobsKey     = "queue:jobs"
+	attemptsKey = "queue:attempts"
+	readyKey    = "queue:ready"
+	delayedKey  = "queue:delayed"
+	inflightKey = "queue:inflight"
+	deadKey     = "queue:dead"
+	everyPrefix = "queue:every:"
+
+	DefaultMaxAttempts = 5
+	baseBackoff        = 10 * time.Second
+	maxBackoff         = time.Hour
+)
+
+var (
+	ErrEmpty = errors.New("queue is empty")
+	// The job is no longer in flight: it timed out and was handed out again,
+	// or it was acknowledged or failed already
+	ErrNotInFlight = errors.New("job is not in flight")
+)
+
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Decode unmarshals the job payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+type Option func(*Job, *time.Duration)
+
+// WithMaxAttempts sets how many times the job runs before it is dead-lettered.
+func WithMaxAttempts(n int) Option {
+	return func(j *Job, _ *time.Duration) { j.MaxAttempts = n }
+}
+
+// WithDelay keeps the job from running before the delay has passed.
+func WithDelay(d time.Duration) Option {
+	return func(_ *Job, delay *time.Duration) { *delay = d }
+}
+
+type Service interface {
+	Enqueue(jobType string, payload any, opts ...Option) (*Job, error)
+	EnqueueEvery(jobType string, payload any, interval time.Duration) (*Job, error)
+	Dequeue(visibility time.Duration) (*Job, error)
+	Extend(job *Job, visibility time.Duration) error
+	Ack(job *Job) error
+	Fail(job *Job, cause error) error
+	Promote() error
+	DeadJobs(limit int64) ([]*Job, error)
+	RetryDead(jobId string) error
+}
+
+type service struct {
+	redis *redis.Client
+}
+
+var queueInstance *service
+
+func New() Service {
+	// Reuse Connection
+	if queueInstance != nil {
+		return queueInstance
+	}
+	queueInstance = &service{
+		redis: redisService.New().GetClient(),
+	}
+	return queueInstance
+}
+
+var (
+	// Pops the next ready job, hides it until ARGV[1] and counts the attempt
+	dequeueScript = redis.NewScript(`
+local id = redis.call('RPOP', KEYS[1])
+if not id then return false end
+redis.call('ZADD', KEYS[2], ARGV[1], id)
+local job = redis.call('HGET', KEYS[3], id)
+if not job then return false end
+return {job, redis.call('HINCRBY', KEYS[4], id, 1)}`)
+
+	// Moves ARGV[1] from the sorted set KEYS[1] to the list KEYS[2], unless
+	// another process got to it first
+	moveScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+  redis.call('LPUSH', KEYS[2], ARGV[1])
+  return 1
+end
+return 0`)
+
+	// Moves the deadline of ARGV[1] to ARGV[2], if it is still in flight
+	extendScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+  redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+  return 1
+end
+return 0`)
+
+	// Forgets an in-flight job
+	ackScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+  redis.call('HDEL', KEYS[2], ARGV[1])
+  redis.call('HDEL', KEYS[3], ARGV[1])
+  return 1
+end
+return 0`)
+
+	// Schedules an in-flight job to run again at ARGV[2]
+	retryScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+  redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+  redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+  return 1
+end
+return 0`)
+
+	// Moves an in-flight job to the dead-letter list
+	buryScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+  redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+  redis.call('LPUSH', KEYS[2], ARGV[1])
+  return 1
+end
+return 0`)
+)
+
+func (s *service) Enqueue(jobType string, payload any, opts ...Option) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("[Enqueue] %v", err)
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("[Enqueue] %v", err)
+	}
+
+	job := &Job{
+		ID:          id.String(),
+		Type:        jobType,
+		Payload:     raw,
+		MaxAttempts: DefaultMaxAttempts,
+		CreatedAt:   time.Now(),
+	}
+	var delay time.Duration
+	for _, opt := range opts {
+		opt(job, &delay)
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("[Enqueue] %v", err)
+	}
+	_, err = s.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(jobsKey, job.ID, data)
+		if delay > 0 {
+			pipe.ZAdd(delayedKey, &redis.Z{Score: score(time.Now().Add(delay)), Member: job.ID})
+		} else {
+			pipe.LPush(readyKey, job.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Enqueue] %v", err)
+	}
+	return job, nil
+}
+
+// EnqueueEvery enqueues a periodic job, unless any process did within the
+// last interval; it then returns a nil job.
+func (s *service) EnqueueEvery(jobType string, payload any, interval time.Duration) (*Job, error) {
+	due, err := s.redis.SetNX(everyPrefix+jobType, time.Now().Unix(), interval).Result()
+	if err != nil {
+		return nil, fmt.Errorf("[EnqueueEvery] %v", err)
+	}
+	if !due {
+		return nil, nil
+	}
+	return s.Enqueue(jobType, payload)
+}
+
+// Dequeue hands out the oldest ready job, invisible to other workers for
+// the visibility timeout. It returns ErrEmpty when nothing is ready.
+func (s *service) Dequeue(visibility time.Duration) (*Job, error) {
+	deadline := score(time.Now().Add(visibility))
+	// The attempt is counted as the job is handed out, so a job that keeps
+	// crashing its worker still runs out of attempts
+	keys := []string{readyKey, inflightKey, jobsKey, attemptsKey}
+	res, err := dequeueScript.Run(s.redis, keys, deadline).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[Dequeue] %v", err)
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return nil, fmt.Errorf("[Dequeue] unexpected script result %v", res)
+	}
+	raw, _ := vals[0].(string)
+	attempts, _ := vals[1].(int64)
+
+	var job Job
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		return nil, fmt.Errorf("[Dequeue] %v", err)
+	}
+	job.Attempts = int(attempts)
+	return &job, nil
+}
+
+// Extend keeps an in-flight job invisible for the visibility timeout from
+// now. It returns ErrNotInFlight when the job was handed out again.
+func (s *service) Extend(job *Job, visibility time.Duration) error {
+	deadline := score(time.Now().Add(visibility))
+	extended, err := extendScript.Run(s.redis, []string{inflightKey}, job.ID, deadline).Int()
+	if err != nil {
+		return fmt.Errorf("[Extend] %v", err)
+	}
+	if extended == 0 {
+		return ErrNotInFlight
+	}
+	return nil
+}
+
+func (s *service) Ack(job *Job) error {
+	if err := ackScript.Run(s.redis, []string{inflightKey, jobsKey, attemptsKey}, job.ID).Err(); err != nil {
+		return fmt.Errorf("[Ack] %v", err)
+	}
+	return nil
+}
+
+// Fail schedules the job to run again with exponential backoff, or moves it
+// to the dead-letter list once it is out of attempts.
+func (s *service) Fail(job *Job, cause error) error {
+	job.LastError = cause.Error()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("[Fail] %v", err)
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		err = buryScript.Run(s.redis, []string{inflightKey, deadKey, jobsKey}, job.ID, data).Err()
+	} else {
+		runAt := score(time.Now().Add(backoff(job.Attempts)))
+		err = retryScript.Run(s.redis, []string{inflightKey, delayedKey, jobsKey}, job.ID, runAt, data).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("[Fail] %v", err)
+	}
+	return nil
+}
+
+// Promote makes delayed jobs that are due ready, and hands out again
+// in-flight jobs whose visibility timeout passed. Workers call it
+// periodically.
+func (s *service) Promote() error {
+	now := strconv.FormatFloat(score(time.Now()), 'f', -1, 64)
+	due := &redis.ZRangeBy{Min: "-inf", Max: now, Count: 100}
+
+	ids, err := s.redis.ZRangeByScore(delayedKey, due).Result()
+	if err != nil {
+		return fmt.Errorf("[Promote] %v", err)
+	}
+	for _, id := range ids {
+		if err := moveScript.Run(s.redis, []string{delayedKey, readyKey}, id).Err(); err != nil {
+			return fmt.Errorf("[Promote] %v", err)
+		}
+	}
+
+	ids, err = s.redis.ZRangeByScore(inflightKey, due).Result()
+	if err != nil {
+		return fmt.Errorf("[Promote] %v", err)
+	}
+	for _, id := range ids {
+		job, err := s.load(id)
+		if err != nil {
+			return fmt.Errorf("[Promote] %v", err)
+		}
+		target := readyKey
+		if job == nil || job.Attempts >= job.MaxAttempts {
+			target = deadKey
+		}
+		if err := moveScript.Run(s.redis, []string{inflightKey, target}, id).Err(); err != nil {
+			return fmt.Errorf("[Promote] %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *service) DeadJobs(limit int64) ([]*Job, error) {
+	ids, err := s.redis.LRange(deadKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("[DeadJobs] %v", err)
+	}
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := s.load(id)
+		if err != nil {
+			return nil, fmt.Errorf("[DeadJobs] %v", err)
+		}
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// RetryDead gives a dead job a fresh set of attempts.
+func (s *service) RetryDead(jobId string) error {
+	job, err := s.load(jobId)
+	if err != nil {
+		return fmt.Errorf("[RetryDead] %v", err)
+	}
+	if job == nil {
+		return fmt.Errorf("[RetryDead] job %s not found", jobId)
+	}
+	removed, err := s.redis.LRem(deadKey, 1, jobId).Result()
+	if err != nil {
+		return fmt.Errorf("[RetryDead] %v", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("[RetryDead] job %s is not dead", jobId)
+	}
+
+	job.Attempts = 0
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("[RetryDead] %v", err)
+	}
+	_, err = s.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(jobsKey, job.ID, data)
+		pipe.HDel(attemptsKey, job.ID)
+		pipe.LPush(readyKey, job.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[RetryDead] %v", err)
+	}
+	return nil
+}
+
+// load reads a job with the attempts counted by Dequeue, or nil when the job
+// is gone.
+func (s *service) load(id string) (*Job, error) {
+	raw, err := s.redis.HGet(jobsKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	attempts, err := s.redis.HGet(attemptsKey, id).Int()
+	if err == nil {
+		job.Attempts = attempts
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// backoff doubles the delay with every attempt, with some jitter so failed
+// batches don't retry in lockstep.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff << min(attempts-1, 16)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + rand.N(d/2)
+}
+
+func score(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
@@ -0,0 +1,258 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// newTestQueue connects to the Redis at TEST_REDIS_HOST, whose database 15
+// is flushed.
+func newTestQueue(t *testing.T) *service {
+	host := os.Getenv("TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("TEST_REDIS_HOST is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: host, Password: os.Getenv("TEST_REDIS_PASSWORD"), DB: 15})
+	t.Cleanup(func() { client.Close() })
+	if err := client.FlushDB().Err(); err != nil {
+		t.Fatal(err)
+	}
+	return &service{redis: client}
+}
+
+func enqueue(t *testing.T, q *service, payload any, opts ...Option) *Job {
+	t.Helper()
+	job, err := q.Enqueue("test", payload, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// dequeue hands out the next job, which has to be j.
+func dequeue(t *testing.T, q *service, j *Job, visibility time.Duration) *Job {
+	t.Helper()
+	job, err := q.Dequeue(visibility)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != j.ID {
+		t.Fatalf("dequeued %s, want %s", job.ID, j.ID)
+	}
+	return job
+}
+
+// where tells which of the queue's structures hold the job.
+func where(t *testing.T, q *service, id string) []string {
+	t.Helper()
+	var in []string
+	for _, key := range []string{readyKey, deadKey} {
+		ids, err := q.redis.LRange(key, 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, member := range ids {
+			if member == id {
+				in = append(in, key)
+			}
+		}
+	}
+	for _, key := range []string{delayedKey, inflightKey} {
+		if err := q.redis.ZScore(key, id).Err(); err == nil {
+			in = append(in, key)
+		} else if !errors.Is(err, redis.Nil) {
+			t.Fatal(err)
+		}
+	}
+	return in
+}
+
+func expectIn(t *testing.T, q *service, id string, keys ...string) {
+	t.Helper()
+	if got := where(t, q, id); !slices.Equal(got, keys) {
+		t.Errorf("job is in %v, want %v", got, keys)
+	}
+}
+
+func TestDequeue(t *testing.T) {
+	q := newTestQueue(t)
+	if _, err := q.Dequeue(time.Minute); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("err = %v, want ErrEmpty", err)
+	}
+	first := enqueue(t, q, 1)
+	second := enqueue(t, q, 2)
+	delayed := enqueue(t, q, 3, WithDelay(time.Hour))
+
+	job := dequeue(t, q, first, time.Minute)
+	if job.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", job.Attempts)
+	}
+	expectIn(t, q, first.ID, inflightKey)
+	dequeue(t, q, second, time.Minute)
+	expectIn(t, q, delayed.ID, delayedKey)
+	if _, err := q.Dequeue(time.Minute); !errors.Is(err, ErrEmpty) {
+		t.Errorf("err = %v, want ErrEmpty", err)
+	}
+
+	// The attempt is counted in Redis too
+	stored, err := q.load(first.ID)
+	if err != nil || stored.Attempts != 1 {
+		t.Errorf("stored job = %+v, %v", stored, err)
+	}
+}
+
+func TestFail(t *testing.T) {
+	cause := errors.New("boom")
+	tests := []struct {
+		name        string
+		maxAttempts int
+		// Whether the lease ran out and the job was handed out again
+		lost bool
+		want string
+	}{
+		{name: "retried", maxAttempts: 2, want: delayedKey},
+		{name: "out of attempts", maxAttempts: 1, want: deadKey},
+		{name: "lease lost", maxAttempts: 2, lost: true, want: readyKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t)
+			enqueued := enqueue(t, q, nil, WithMaxAttempts(tt.maxAttempts))
+			job := dequeue(t, q, enqueued, time.Minute)
+			if tt.lost {
+				q.redis.ZRem(inflightKey, job.ID)
+				q.redis.LPush(readyKey, job.ID)
+			}
+
+			before := time.Now()
+			if err := q.Fail(job, cause); err != nil {
+				t.Fatal(err)
+			}
+			expectIn(t, q, job.ID, tt.want)
+			stored, err := q.load(job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.lost && stored.LastError != "boom" {
+				t.Errorf("last error = %q, want boom", stored.LastError)
+			}
+			if tt.want == delayedKey {
+				runAt, _ := q.redis.ZScore(delayedKey, job.ID).Result()
+				delay := time.Duration(runAt-score(before)) * time.Millisecond
+				if delay < baseBackoff/2 || delay > baseBackoff+time.Second {
+					t.Errorf("retried in %v, want about %v", delay, baseBackoff)
+				}
+			}
+		})
+	}
+}
+
+func TestAck(t *testing.T) {
+	q := newTestQueue(t)
+	enqueued := enqueue(t, q, nil)
+	job := dequeue(t, q, enqueued, time.Minute)
+	if err := q.Ack(job); err != nil {
+		t.Fatal(err)
+	}
+	expectIn(t, q, job.ID)
+	if stored, err := q.load(job.ID); stored != nil || err != nil {
+		t.Errorf("stored job = %+v, %v, want none", stored, err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	q := newTestQueue(t)
+	enqueued := enqueue(t, q, nil)
+	job := dequeue(t, q, enqueued, time.Second)
+
+	if err := q.Extend(job, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	deadline, _ := q.redis.ZScore(inflightKey, job.ID).Result()
+	if deadline < score(time.Now().Add(59*time.Minute)) {
+		t.Errorf("deadline not extended")
+	}
+
+	if err := q.Ack(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Extend(job, time.Hour); !errors.Is(err, ErrNotInFlight) {
+		t.Errorf("err = %v, want ErrNotInFlight", err)
+	}
+	expectIn(t, q, job.ID)
+}
+
+func TestPromote(t *testing.T) {
+	q := newTestQueue(t)
+	due := enqueue(t, q, nil, WithDelay(time.Millisecond))
+	later := enqueue(t, q, nil, WithDelay(time.Hour))
+	time.Sleep(10 * time.Millisecond)
+	if err := q.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	expectIn(t, q, due.ID, readyKey)
+	expectIn(t, q, later.ID, delayedKey)
+
+	// In-flight jobs past their deadline run again, or are dead-lettered
+	// once out of attempts
+	retried := dequeue(t, q, due, -time.Second)
+	last := enqueue(t, q, nil, WithMaxAttempts(1))
+	dequeue(t, q, last, -time.Second)
+	running := enqueue(t, q, nil)
+	dequeue(t, q, running, time.Minute)
+	if err := q.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	expectIn(t, q, retried.ID, readyKey)
+	expectIn(t, q, last.ID, deadKey)
+	expectIn(t, q, running.ID, inflightKey)
+}
+
+func TestRetryDead(t *testing.T) {
+	q := newTestQueue(t)
+	enqueued := enqueue(t, q, nil, WithMaxAttempts(1))
+	job := dequeue(t, q, enqueued, time.Minute)
+	if err := q.RetryDead(job.ID); err == nil {
+		t.Error("RetryDead of a job that is not dead succeeded")
+	}
+	if err := q.Fail(job, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, err := q.DeadJobs(10)
+	if err != nil || len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "boom" {
+		t.Fatalf("DeadJobs = %+v, %v", dead, err)
+	}
+	if err := q.RetryDead(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectIn(t, q, job.ID, readyKey)
+	if job = dequeue(t, q, job, time.Minute); job.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", job.Attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{attempts: 1, max: baseBackoff},
+		{attempts: 2, max: 2 * baseBackoff},
+		{attempts: 5, max: 16 * baseBackoff},
+		{attempts: 20, max: maxBackoff},
+		{attempts: 1000, max: maxBackoff},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if d := backoff(tt.attempts); d < tt.max/2 || d >= tt.max {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v)", tt.attempts, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
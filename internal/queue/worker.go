@@ -0,0 +1,214 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Handler runs a job. Returning an error fails the attempt, the job is then
+// retried with backoff until it runs out of attempts.
+type Handler func(ctx context.Context, job *Job) error
+
+type WorkerConfig struct {
+	// Number of jobs run at the same time
+	Concurrency int
+	// How long a job stays hidden from other workers without news from the
+	// worker running it, i.e. how soon the jobs of a crashed worker run
+	// again. Running jobs extend it every third of the timeout, handlers are
+	// cancelled when that fails for too long.
+	Visibility time.Duration
+	// How long to wait before polling an empty queue again
+	PollInterval time.Duration
+}
+
+var DefaultWorkerConfig = WorkerConfig{
+	Concurrency:  4,
+	Visibility:   5 * time.Minute,
+	PollInterval: time.Second,
+}
+
+type Worker struct {
+	queue     Service
+	config    WorkerConfig
+	handlers  map[string]Handler
+	schedules map[string]time.Duration
+}
+
+func NewWorker(queue Service, config WorkerConfig) *Worker {
+	if config.Concurrency < 1 {
+		config.Concurrency = DefaultWorkerConfig.Concurrency
+	}
+	if config.Visibility <= 0 {
+		config.Visibility = DefaultWorkerConfig.Visibility
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultWorkerConfig.PollInterval
+	}
+	return &Worker{
+		queue:     queue,
+		config:    config,
+		handlers:  make(map[string]Handler),
+		schedules: make(map[string]time.Duration),
+	}
+}
+
+// Handle registers the handler of a job type.
+func (w *Worker) Handle(jobType string, handler Handler) {
+	w.handlers[jobType] = handler
+}
+
+// Every enqueues a job of the type, without payload, once per interval
+// across all workers.
+func (w *Worker) Every(jobType string, interval time.Duration) {
+	w.schedules[jobType] = interval
+}
+
+// Run processes jobs until ctx is cancelled, then waits for the jobs in
+// progress to finish.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.promote(ctx)
+	}()
+
+	for jobType, interval := range w.schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.schedule(ctx, jobType, interval)
+		}()
+	}
+
+	for range w.config.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (w *Worker) promote(ctx context.Context) {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.queue.Promote(); err != nil {
+			log.Printf("[Worker] %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) schedule(ctx context.Context, jobType string, interval time.Duration) {
+	// Check more often than the interval, the worker that enqueued the last
+	// job may be gone
+	ticker := time.NewTicker(min(interval, time.Minute))
+	defer ticker.Stop()
+	for {
+		if _, err := w.queue.EnqueueEvery(jobType, struct{}{}, interval); err != nil {
+			log.Printf("[Worker] %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := w.queue.Dequeue(w.config.Visibility)
+		if err != nil {
+			if !errors.Is(err, ErrEmpty) {
+				log.Printf("[Worker] %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.config.PollInterval):
+			}
+			continue
+		}
+		w.run(ctx, job)
+	}
+}
+
+func (w *Worker) run(ctx context.Context, job *Job) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	beating := make(chan struct{})
+	go func() {
+		defer close(beating)
+		w.heartbeat(ctx, cancel, job)
+	}()
+
+	err := w.call(ctx, job)
+	// The heartbeat stops before the job is settled
+	cancel()
+	<-beating
+	if err == nil {
+		if err := w.queue.Ack(job); err != nil {
+			log.Printf("[Worker] %v", err)
+		}
+		return
+	}
+
+	log.Printf("[Worker] job %s (%s) attempt %d/%d: %v", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+	if err := w.queue.Fail(job, err); err != nil {
+		log.Printf("[Worker] %v", err)
+	}
+}
+
+// heartbeat extends the visibility timeout of a running job until ctx is
+// done. The job is cancelled once it was handed out again, or a little
+// before that can happen, so it doesn't run twice at the same time.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelFunc, job *Job) {
+	interval := w.config.Visibility / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expires := time.Now().Add(w.config.Visibility)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := w.queue.Extend(job, w.config.Visibility)
+		if err == nil {
+			expires = time.Now().Add(w.config.Visibility)
+			continue
+		}
+		log.Printf("[Worker] job %s (%s): %v", job.ID, job.Type, err)
+		// The next try would come too late
+		if errors.Is(err, ErrNotInFlight) || time.Until(expires) < interval*3/2 {
+			cancel()
+			return
+		}
+	}
+}
+
+// call runs the job's handler, turning panics into failed attempts.
+func (w *Worker) call(ctx context.Context, job *Job) (err error) {
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
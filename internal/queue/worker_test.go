@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQueue records how the worker settles jobs. Extend returns the errors
+// of extendErrs in turn, then nil.
+type fakeQueue struct {
+	mu         sync.Mutex
+	extendErrs []error
+	extends    int
+	// Extends after the job was settled
+	lateExtends int
+	acked       []string
+	failed      map[string]error
+}
+
+func (q *fakeQueue) Enqueue(jobType string, payload any, opts ...Option) (*Job, error) {
+	return nil, nil
+}
+
+func (q *fakeQueue) EnqueueEvery(jobType string, payload any, interval time.Duration) (*Job, error) {
+	return nil, nil
+}
+
+func (q *fakeQueue) Dequeue(visibility time.Duration) (*Job, error) { return nil, ErrEmpty }
+func (q *fakeQueue) Promote() error                                 { return nil }
+func (q *fakeQueue) DeadJobs(limit int64) ([]*Job, error)           { return nil, nil }
+func (q *fakeQueue) RetryDead(jobId string) error                   { return nil }
+
+func (q *fakeQueue) Extend(job *Job, visibility time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.acked)+len(q.failed) > 0 {
+		q.lateExtends++
+	}
+	q.extends++
+	if len(q.extendErrs) == 0 {
+		return nil
+	}
+	err := q.extendErrs[0]
+	q.extendErrs = q.extendErrs[1:]
+	return err
+}
+
+func (q *fakeQueue) Ack(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.acked = append(q.acked, job.ID)
+	return nil
+}
+
+func (q *fakeQueue) Fail(job *Job, cause error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.failed == nil {
+		q.failed = make(map[string]error)
+	}
+	q.failed[job.ID] = cause
+	return nil
+}
+
+func TestWorkerRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+		// Substring of the failure, "" when the job is acknowledged
+		fails string
+	}{
+		{name: "success", handler: func(ctx context.Context, job *Job) error { return nil }},
+		{name: "error", handler: func(ctx context.Context, job *Job) error { return errors.New("boom") }, fails: "boom"},
+		{name: "panic", handler: func(ctx context.Context, job *Job) error { panic("oops") }, fails: "panic: oops"},
+		{name: "no handler", fails: "no handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{}
+			w := NewWorker(q, WorkerConfig{Visibility: time.Minute})
+			if tt.handler != nil {
+				w.Handle("test", tt.handler)
+			}
+			w.run(context.Background(), &Job{ID: "1", Type: "test", Attempts: 1, MaxAttempts: 5})
+
+			if tt.fails == "" {
+				if len(q.acked) != 1 || len(q.failed) != 0 {
+					t.Errorf("acked %v, failed %v, want acked", q.acked, q.failed)
+				}
+				return
+			}
+			if len(q.acked) != 0 || q.failed["1"] == nil || !strings.Contains(q.failed["1"].Error(), tt.fails) {
+				t.Errorf("acked %v, failed %v, want failed with %q", q.acked, q.failed, tt.fails)
+			}
+		})
+	}
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	const visibility = 150 * time.Millisecond
+	unreachable := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		extendErrs []error
+		// Whether the handler is cancelled before it is done
+		cancelled bool
+	}{
+		{name: "extended"},
+		{name: "one failed extend", extendErrs: []error{unreachable}},
+		{name: "handed out again", extendErrs: []error{ErrNotInFlight}, cancelled: true},
+		{name: "queue unreachable", extendErrs: []error{unreachable, unreachable, unreachable}, cancelled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{extendErrs: tt.extendErrs}
+			w := NewWorker(q, WorkerConfig{Visibility: visibility})
+			start := time.Now()
+			var stopped time.Duration
+			w.Handle("test", func(ctx context.Context, job *Job) error {
+				select {
+				case <-ctx.Done():
+					stopped = time.Since(start)
+					return ctx.Err()
+				case <-time.After(3 * visibility):
+					return nil
+				}
+			})
+			w.run(context.Background(), &Job{ID: "1", Type: "test", Attempts: 1, MaxAttempts: 5})
+			// A heartbeat still running would extend the settled job
+			time.Sleep(visibility)
+
+			if q.lateExtends > 0 {
+				t.Errorf("extended %d times after the job was settled", q.lateExtends)
+			}
+			if !tt.cancelled {
+				if len(q.acked) != 1 {
+					t.Errorf("job outlived its visibility timeout but was not acknowledged: failed %v", q.failed)
+				}
+				if q.extends < 3 {
+					t.Errorf("extended %d times, want at least 3", q.extends)
+				}
+				return
+			}
+			if len(q.failed) != 1 {
+				t.Fatalf("acked %v, want the job failed", q.acked)
+			}
+			if stopped == 0 || stopped >= visibility {
+				t.Errorf("handler stopped after %v, want before %v", stopped, visibility)
+			}
+		})
+	}
+}
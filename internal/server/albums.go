@@ -0,0 +1,312 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/export"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Members browse albums, the owner and co-hosts curate them.
+
+const (
+	maxAlbumDescriptionLen = 2000
+	// Photo or album IDs per request
+	maxAlbumBatch = 500
+)
+
+type albumInput struct {
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	CoverPhotoID *string `json:"cover_photo_id"`
+}
+
+func (in *albumInput) validate() error {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" || utf8.RuneCountInString(name) > database.MaxAlbumNameLength {
+			return errors.New("`name` must be 1 to 100 characters")
+		}
+		in.Name = &name
+	}
+	if in.Description != nil && utf8.RuneCountInString(*in.Description) > maxAlbumDescriptionLen {
+		return errors.New("`description` is too long")
+	}
+	if in.CoverPhotoID != nil && *in.CoverPhotoID != "" {
+		if _, err := uuid.Parse(*in.CoverPhotoID); err != nil {
+			return errors.New("invalid `cover_photo_id`")
+		}
+	}
+	return nil
+}
+
+// parseIDs reads the list of IDs in the `field` of the body.
+func parseIDs(c *fiber.Ctx, field string) ([]string, error) {
+	invalid := fmt.Errorf("`%s` must be a list of at most %d IDs", field, maxAlbumBatch)
+	var body map[string][]string
+	if err := c.BodyParser(&body); err != nil {
+		return nil, invalid
+	}
+	ids, ok := body[field]
+	if !ok || len(ids) > maxAlbumBatch {
+		return nil, invalid
+	}
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, invalid
+		}
+	}
+	return ids, nil
+}
+
+func (s *FiberServer) ListAlbums(c *fiber.Ctx) error {
+	albums, err := s.db.ListEventAlbums(c.Params("id"))
+	if err != nil {
+		return ErrResp(c, 500, "List albums error", err)
+	}
+	for i := range albums {
+		s.signAlbum(&albums[i])
+	}
+	return c.JSON(fiber.Map{
+		"data": albums,
+	})
+}
+
+func (s *FiberServer) GetAlbum(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	s.signAlbum(album)
+	return c.JSON(fiber.Map{
+		"data": album,
+	})
+}
+
+func (s *FiberServer) CreateAlbum(c *fiber.Ctx) error {
+	var body albumInput
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if body.Name == nil {
+		return ErrResp(c, 400, "Required `name`")
+	}
+	if body.CoverPhotoID != nil {
+		return ErrResp(c, 400, "`cover_photo_id` needs photos in the album")
+	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+
+	album := &database.Album{
+		EventID:   c.Params("id"),
+		Name:      *body.Name,
+		CreatedBy: c.Locals("user_id").(string),
+	}
+	if body.Description != nil {
+		album.Description = *body.Description
+	}
+	if err := s.db.CreateAlbum(album); err != nil {
+		return albumErrResp(c, err)
+	}
+
+	return s.respondAlbum(c, album.ID)
+}
+
+func (s *FiberServer) UpdateAlbum(c *fiber.Ctx) error {
+	var body albumInput
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	err = s.db.UpdateAlbum(album.ID, database.AlbumUpdate{
+		Name:         body.Name,
+		Description:  body.Description,
+		CoverPhotoID: body.CoverPhotoID,
+	})
+	if err != nil {
+		return albumErrResp(c, err)
+	}
+
+	return s.respondAlbum(c, album.ID)
+}
+
+// DeleteAlbum deletes the album only, its photos stay in the event.
+func (s *FiberServer) DeleteAlbum(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	var exportIds []string
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		var err error
+		if exportIds, err = tx.DeleteExports(album.EventID, album.ID); err != nil {
+			return err
+		}
+		return tx.DeleteAlbum(album.ID)
+	})
+	if err != nil {
+		return albumErrResp(c, err)
+	}
+
+	keys := make([]string, 0, len(exportIds))
+	for _, id := range exportIds {
+		keys = append(keys, export.Key(id))
+	}
+	if err := s.storage.DeleteFiles(keys...); err != nil {
+		log.Printf("[DeleteAlbum] %v", err)
+	}
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
+
+// OrderAlbums puts the `album_ids` albums first, in that order, and
+// responds with the reordered albums.
+func (s *FiberServer) OrderAlbums(c *fiber.Ctx) error {
+	albumIds, err := parseIDs(c, "album_ids")
+	if err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+	if err := s.db.OrderEventAlbums(c.Params("id"), albumIds); err != nil {
+		return ErrResp(c, 500, "Order albums error", err)
+	}
+	return s.ListAlbums(c)
+}
+
+func (s *FiberServer) ListAlbumPhotos(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+
+	page, err := s.db.ListAlbumPhotos(database.AlbumPhotoQuery{
+		AlbumID: album.ID,
+		Cursor:  c.Query("cursor"),
+		Limit:   c.QueryInt("limit", database.DefaultPhotoPageSize),
+	})
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return ErrResp(c, 400, "Invalid `cursor`")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "List photos error", err)
+	}
+	photos := make([]*database.Photo, 0, len(page.Photos))
+	for i := range page.Photos {
+		s.signPhoto(&page.Photos[i])
+		photos = append(photos, &page.Photos[i])
+	}
+	s.countPhotoReactions(c.Locals("user_id").(string), photos)
+	s.loadPhotoTags(photos)
+
+	return c.JSON(fiber.Map{
+		"data":        page.Photos,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (s *FiberServer) AddAlbumPhotos(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	photoIds, err := parseIDs(c, "photo_ids")
+	if err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+	if err := s.db.AddAlbumPhotos(album.ID, c.Locals("user_id").(string), photoIds); err != nil {
+		return albumErrResp(c, err)
+	}
+	return s.respondAlbum(c, album.ID)
+}
+
+func (s *FiberServer) RemoveAlbumPhotos(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	photoIds, err := parseIDs(c, "photo_ids")
+	if err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+	if err := s.db.RemoveAlbumPhotos(album.ID, photoIds); err != nil {
+		return albumErrResp(c, err)
+	}
+	return s.respondAlbum(c, album.ID)
+}
+
+// OrderAlbumPhotos puts the `photo_ids` photos first in the album, in that
+// order.
+func (s *FiberServer) OrderAlbumPhotos(c *fiber.Ctx) error {
+	album, status, err := s.findEventAlbum(c)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+	photoIds, err := parseIDs(c, "photo_ids")
+	if err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+	if err := s.db.OrderAlbumPhotos(album.ID, photoIds); err != nil {
+		return albumErrResp(c, err)
+	}
+	return s.respondAlbum(c, album.ID)
+}
+
+func (s *FiberServer) respondAlbum(c *fiber.Ctx, albumId string) error {
+	album, err := s.db.GetAlbum(albumId)
+	if err != nil {
+		return ErrResp(c, 500, "Get album error", err)
+	}
+	s.signAlbum(album)
+	return c.JSON(fiber.Map{
+		"data": album,
+	})
+}
+
+func albumErrResp(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return ErrResp(c, 404, "Album not found")
+	case errors.Is(err, database.ErrInvalidAlbum):
+		return ErrResp(c, 400, "`name` must be 1 to 100 characters")
+	case errors.Is(err, database.ErrInvalidAlbumPhoto), errors.Is(err, database.ErrInvalidAlbumCover):
+		return ErrResp(c, 400, err.Error())
+	default:
+		return ErrResp(c, 500, "Album error", err)
+	}
+}
+
+// findEventAlbum loads the `albumId` album of the `id` event.
+func (s *FiberServer) findEventAlbum(c *fiber.Ctx) (*database.Album, int, error) {
+	albumId := c.Params("albumId")
+	if _, err := uuid.Parse(albumId); err != nil {
+		return nil, 404, err
+	}
+	album, err := s.db.GetAlbum(albumId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, 404, err
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	if album.EventID != c.Params("id") {
+		return nil, 404, errors.New("album of another event")
+	}
+	return album, 200, nil
+}
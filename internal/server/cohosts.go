@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func (s *FiberServer) ListCohosts(c *fiber.Ctx) error {
+	cohosts, err := s.db.ListEventCohosts(c.Params("id"))
+	if err != nil {
+		return ErrResp(c, 500, "List co-hosts error", err)
+	}
+	return c.JSON(fiber.Map{
+		"data": cohosts,
+	})
+}
+
+func (s *FiberServer) AddCohost(c *fiber.Ctx) error {
+	return s.setMemberRole(c, database.RoleCohost)
+}
+
+func (s *FiberServer) RemoveCohost(c *fiber.Ctx) error {
+	return s.setMemberRole(c, database.RoleMember)
+}
+
+// setMemberRole makes the `userId` member a co-host of the event or a plain
+// member again.
+func (s *FiberServer) setMemberRole(c *fiber.Ctx, role database.MemberRole) error {
+	userId := c.Params("userId")
+	if _, err := uuid.Parse(userId); err != nil {
+		return ErrResp(c, 404, "Member not found")
+	}
+	access := c.Locals("event_access").(*database.EventAccess)
+	if access.IsOwner(userId) {
+		return ErrResp(c, 400, "The event owner can't be a co-host")
+	}
+
+	err := s.db.SetMemberRole(access.EventID, userId, role)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Member not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Set member role error", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Comments follow the event routes: members read and write them, authors
+// edit and delete their own, and the event owner deletes anyone's.
+
+type commentInput struct {
+	Body     *string   `json:"body"`
+	ParentID *string   `json:"parent_id"`
+	Mentions *[]string `json:"mentions"`
+}
+
+func (in *commentInput) validate() error {
+	if in.Body != nil {
+		body := strings.TrimSpace(*in.Body)
+		if body == "" {
+			return errors.New("`body` can't be empty")
+		}
+		if utf8.RuneCountInString(body) > database.MaxCommentLength {
+			return fmt.Errorf("`body` must be at most %d characters", database.MaxCommentLength)
+		}
+		in.Body = &body
+	}
+	if in.ParentID != nil {
+		if _, err := uuid.Parse(*in.ParentID); err != nil {
+			return errors.New("invalid `parent_id`")
+		}
+	}
+	if in.Mentions != nil {
+		for _, id := range *in.Mentions {
+			if _, err := uuid.Parse(id); err != nil {
+				return errors.New("`mentions` must be user ids")
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FiberServer) ListEventComments(c *fiber.Ctx) error {
+	return s.listComments(c, database.CommentQuery{EventID: c.Params("id")})
+}
+
+func (s *FiberServer) ListPhotoComments(c *fiber.Ctx) error {
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+	return s.listComments(c, database.CommentQuery{EventID: photo.EventID, PhotoID: photo.ID})
+}
+
+// ListCommentReplies lists the replies of the thread a comment is part of.
+func (s *FiberServer) ListCommentReplies(c *fiber.Ctx) error {
+	comment, status, err := s.findEventComment(c)
+	if err != nil {
+		return ErrResp(c, status, "Comment not found", err)
+	}
+	root := comment.ID
+	if comment.ParentID != nil {
+		root = *comment.ParentID
+	}
+	return s.listComments(c, database.CommentQuery{EventID: comment.EventID, ParentID: root})
+}
+
+func (s *FiberServer) listComments(c *fiber.Ctx, query database.CommentQuery) error {
+	query.Cursor = c.Query("cursor")
+	query.Limit = c.QueryInt("limit", database.DefaultCommentPageSize)
+
+	page, err := s.db.ListComments(query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return ErrResp(c, 400, "Invalid `cursor`")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "List comments error", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"data":        page.Comments,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (s *FiberServer) CreateEventComment(c *fiber.Ctx) error {
+	return s.createComment(c, nil)
+}
+
+func (s *FiberServer) CreatePhotoComment(c *fiber.Ctx) error {
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+	return s.createComment(c, &photo.ID)
+}
+
+// createComment starts a thread on the event or photo, or replies to one
+// with `parent_id`. Replies to a reply go to the thread it is part of.
+func (s *FiberServer) createComment(c *fiber.Ctx, photoId *string) error {
+	var body commentInput
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if body.Body == nil {
+		return ErrResp(c, 400, "Required `body`")
+	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+
+	comment := &database.Comment{
+		EventID: c.Params("id"),
+		PhotoID: photoId,
+		Author:  database.User{ID: c.Locals("user_id").(string)},
+		Body:    *body.Body,
+	}
+	if body.ParentID != nil {
+		parent, err := s.db.GetComment(*body.ParentID)
+		if errors.Is(err, database.ErrNotFound) || (err == nil && (parent.EventID != comment.EventID || !samePhoto(parent.PhotoID, photoId))) {
+			return ErrResp(c, 400, "`parent_id` is not a comment of this thread")
+		}
+		if err != nil {
+			return ErrResp(c, 500, "Get comment error", err)
+		}
+		comment.ParentID = &parent.ID
+		if parent.ParentID != nil {
+			comment.ParentID = parent.ParentID
+		}
+	}
+
+	err := s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		if err := tx.CreateComment(comment); err != nil {
+			return err
+		}
+		if body.Mentions == nil {
+			return nil
+		}
+		return tx.SetCommentMentions(comment.ID, comment.EventID, *body.Mentions)
+	})
+	if err != nil {
+		return commentErrResp(c, err)
+	}
+
+	return s.respondComment(c, comment.ID)
+}
+
+// UpdateComment lets the author change the body and, if given, the
+// mentions of a comment.
+func (s *FiberServer) UpdateComment(c *fiber.Ctx) error {
+	var body commentInput
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if body.ParentID != nil {
+		return ErrResp(c, 400, "Comments can't move to another thread")
+	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+
+	comment, status, err := s.findEventComment(c)
+	if err != nil {
+		return ErrResp(c, status, "Comment not found", err)
+	}
+	if comment.Author.ID != c.Locals("user_id").(string) {
+		return ErrResp(c, 403, "Only the author can edit this comment")
+	}
+
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		if body.Body != nil {
+			if err := tx.UpdateComment(comment.ID, *body.Body); err != nil {
+				return err
+			}
+		}
+		if body.Mentions == nil {
+			return nil
+		}
+		return tx.SetCommentMentions(comment.ID, comment.EventID, *body.Mentions)
+	})
+	if err != nil {
+		return commentErrResp(c, err)
+	}
+
+	return s.respondComment(c, comment.ID)
+}
+
+// DeleteComment removes a comment for its author, or as moderation by the
+// event owner.
+func (s *FiberServer) DeleteComment(c *fiber.Ctx) error {
+	comment, status, err := s.findEventComment(c)
+	if err != nil {
+		return ErrResp(c, status, "Comment not found", err)
+	}
+	userId := c.Locals("user_id").(string)
+	access := c.Locals("event_access").(*database.EventAccess)
+	if comment.Author.ID != userId && !access.IsOwner(userId) {
+		return ErrResp(c, 403, "Only the author or the event owner can delete this comment")
+	}
+
+	if err := s.db.DeleteComment(comment.ID, userId); err != nil {
+		return commentErrResp(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
+
+func (s *FiberServer) respondComment(c *fiber.Ctx, commentId string) error {
+	comment, err := s.db.GetComment(commentId)
+	if err != nil {
+		return ErrResp(c, 500, "Get comment error", err)
+	}
+	return c.JSON(fiber.Map{
+		"data": comment,
+	})
+}
+
+func commentErrResp(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return ErrResp(c, 404, "Comment not found")
+	case errors.Is(err, database.ErrInvalidComment), errors.Is(err, database.ErrInvalidMention):
+		return ErrResp(c, 400, err.Error())
+	default:
+		return ErrResp(c, 500, "Comment error", err)
+	}
+}
+
+// findEventComment loads the `commentId` comment of the `id` event. Deleted
+// comments are not found.
+func (s *FiberServer) findEventComment(c *fiber.Ctx) (*database.Comment, int, error) {
+	commentId := c.Params("commentId")
+	if _, err := uuid.Parse(commentId); err != nil {
+		return nil, 404, err
+	}
+	comment, err := s.db.GetComment(commentId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, 404, err
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	if comment.EventID != c.Params("id") || comment.DeletedAt != nil {
+		return nil, 404, database.ErrNotFound
+	}
+	return comment, 200, nil
+}
+
+func samePhoto(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
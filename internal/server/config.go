@@ -0,0 +1,67 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// Largest single file accepted by any upload route, in bytes
+	maxUploadFileSize = envInt64("UPLOAD_MAX_FILE_SIZE", 100<<20)
+	// Largest multipart upload, in bytes, which caps how many files fit in
+	// one. Bodies are streamed, its files are spooled to disk.
+	maxUploadRequestSize = envInt64("UPLOAD_MAX_REQUEST_SIZE", 512<<20)
+	// Largest body of the routes that take JSON, which are read in memory
+	maxRequestSize = envInt64("MAX_REQUEST_SIZE", 1<<20)
+	// Longest and largest video accepted, events may set lower limits
+	maxVideoDuration = envDuration("VIDEO_MAX_DURATION", time.Minute)
+	maxVideoFileSize = envInt64("VIDEO_MAX_FILE_SIZE", 100<<20)
+	// How long signed photo URLs in responses stay valid
+	downloadURLExpiry = envDuration("DOWNLOAD_URL_EXPIRY", time.Hour)
+	// Emoji users can react to photos with, comma separated
+	reactionEmojis = envList("REACTION_EMOJIS", []string{"❤️", "😂", "😮", "😢", "🔥", "👍"})
+)
+
+func envInt64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Fatalf("invalid %s %q", key, value)
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q", key, value)
+	}
+	return d
+}
+
+func envList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || utf8.RuneCountInString(item) > 16 {
+			log.Fatalf("invalid %s %q", key, value)
+		}
+		list = append(list, item)
+	}
+	return list
+}
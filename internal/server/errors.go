@@ -1,6 +1,12 @@
 package server
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"errors"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+)
 
 func ErrResp(c *fiber.Ctx, status int, msg string, err ...error) error {
 	details := ""
@@ -13,3 +19,27 @@ func ErrResp(c *fiber.Ctx, status int, msg string, err ...error) error {
 		"details": details,
 	})
 }
+
+// EventErrResp maps the database errors about a missing or read-only event.
+func EventErrResp(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return ErrResp(c, 404, "Event not found")
+	case errors.Is(err, database.ErrEventArchived):
+		return ErrResp(c, 409, err.Error())
+	default:
+		return ErrResp(c, 500, "Event error", err)
+	}
+}
+
+// DuplicatePhotoResp rejects an upload that is already part of the event,
+// pointing at the photo uploaded first, without its URLs.
+func DuplicatePhotoResp(c *fiber.Ctx, existing *database.Photo) error {
+	hidePhoto(existing)
+	return c.Status(409).JSON(fiber.Map{
+		"error":   true,
+		"message": database.ErrDuplicatePhoto.Error(),
+		"details": existing.FileName,
+		"data":    existing,
+	})
+}
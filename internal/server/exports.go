@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/export"
+	"mercuria-backend/internal/jobs"
+	"mercuria-backend/internal/media"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// The owner and co-hosts download the originals of an event, or of one of
+// its albums, as a ZIP archive. Archived events can be exported too.
+
+// DownloadEvent streams the archive of the event, or of the `album_id`
+// album, as it is written. Large events are better exported with
+// CreateExport, which does not tie up a request.
+func (s *FiberServer) DownloadEvent(c *fiber.Ctx) error {
+	source, name, status, err := s.exportSource(c, c.Query("album_id"))
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+
+	archiver := export.NewArchiver(s.db, s.storage)
+	c.Set(fiber.HeaderContentType, export.ContentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The status is sent by now, a failure can only cut the archive short
+		if _, err := archiver.Write(context.Background(), w, source); err != nil {
+			log.Printf("[DownloadEvent] %s: %v", source.EventID, err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("[DownloadEvent] %s: %v", source.EventID, err)
+		}
+	})
+	return nil
+}
+
+// CreateExport has the worker build the archive of the event, or of the
+// `album_id` album. Its progress is then followed with GetExport.
+func (s *FiberServer) CreateExport(c *fiber.Ctx) error {
+	var body struct {
+		AlbumID string `json:"album_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return ErrResp(c, 400, "Body parse error")
+		}
+	}
+
+	source, _, status, err := s.exportSource(c, body.AlbumID)
+	if err != nil {
+		return ErrResp(c, status, "Album not found", err)
+	}
+
+	e := &database.Export{
+		EventID:     source.EventID,
+		RequestedBy: c.Locals("user_id").(string),
+	}
+	if source.AlbumID != "" {
+		e.AlbumID = &source.AlbumID
+	}
+	if err := s.db.CreateExport(e); err != nil {
+		return ErrResp(c, 500, "Create export error", err)
+	}
+	if _, err := s.queue.Enqueue(jobs.EventExport, jobs.ExportPayload{ExportID: e.ID}); err != nil {
+		return ErrResp(c, 500, "Enqueue export error", err)
+	}
+
+	return c.Status(202).JSON(fiber.Map{
+		"data": e,
+	})
+}
+
+// GetExport responds with an export, and a download URL of its archive once
+// it is built.
+func (s *FiberServer) GetExport(c *fiber.Ctx) error {
+	exportId := c.Params("exportId")
+	if _, err := uuid.Parse(exportId); err != nil {
+		return ErrResp(c, 404, "Export not found")
+	}
+	e, err := s.db.GetExport(exportId)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Export not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Get export error", err)
+	}
+	if e.EventID != c.Params("id") {
+		return ErrResp(c, 404, "Export not found")
+	}
+
+	if e.Status == database.ExportDone {
+		if e.DownloadURL, err = s.storage.PresignDownload(export.Key(e.ID), downloadURLExpiry); err != nil {
+			return ErrResp(c, 500, "Sign export error", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"data": e,
+	})
+}
+
+// exportSource checks that the album, if any, is one of the event's and
+// returns the source of its archive with a file name for it.
+func (s *FiberServer) exportSource(c *fiber.Ctx, albumId string) (export.Source, string, int, error) {
+	source := export.Source{EventID: c.Params("id")}
+	if albumId == "" {
+		return source, "event-" + source.EventID, 0, nil
+	}
+	if _, err := uuid.Parse(albumId); err != nil {
+		return source, "", 404, err
+	}
+	album, err := s.db.GetAlbum(albumId)
+	if errors.Is(err, database.ErrNotFound) {
+		return source, "", 404, err
+	}
+	if err != nil {
+		return source, "", 500, err
+	}
+	if album.EventID != source.EventID {
+		return source, "", 404, errors.New("album of another event")
+	}
+
+	source.AlbumID = album.ID
+	name := media.SanitizeFileName(album.Name)
+	if name == "" {
+		name = "album-" + album.ID
+	}
+	return source, name, 0, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"mercuria-backend/internal/media"
+	"mercuria-backend/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Backends without an HTTP endpoint of their own (local disk, memory) have
+// their presigned URLs point here. The signature in the query is all the
+// authorization there is.
+
+// servedFile checks the presigned URL of the request against the storage
+// backend and returns the file key with the upload it allows.
+func (s *FiberServer) servedFile(c *fiber.Ctx) (string, *storage.SignedUpload, int, error) {
+	served, ok := s.storage.(storage.Served)
+	if !ok {
+		return "", nil, 404, errors.New("storage is not served by the API")
+	}
+	fileId, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return "", nil, 404, err
+	}
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return "", nil, 403, err
+	}
+	upload, err := served.VerifyURL(c.Method(), fileId, query)
+	if err != nil {
+		return "", nil, 403, err
+	}
+	return fileId, upload, 0, nil
+}
+
+// ServeFile answers a presigned GET.
+func (s *FiberServer) ServeFile(c *fiber.Ctx) error {
+	fileId, _, status, err := s.servedFile(c)
+	if err != nil {
+		return ErrResp(c, status, "File not found", err)
+	}
+
+	info, err := s.storage.HeadFile(fileId)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrResp(c, 404, "File not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Get file error", err)
+	}
+	body, err := s.storage.DownloadFile(fileId)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrResp(c, 404, "File not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Get file error", err)
+	}
+
+	c.Set(fiber.HeaderContentType, info.ContentType)
+	// Signed URLs are not shared, nor worth caching past their expiry
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(downloadURLExpiry.Seconds())))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	// Players seek in videos with single byte ranges. Malformed and multiple
+	// ranges get the whole file, which the RFC allows.
+	if c.Get(fiber.HeaderRange) == "" {
+		return c.SendStream(body, int(info.Size))
+	}
+	ranges, err := c.Range(int(info.Size))
+	if errors.Is(err, fiber.ErrRangeUnsatisfiable) {
+		body.Close()
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+	if err != nil || ranges.Type != "bytes" || len(ranges.Ranges) != 1 {
+		return c.SendStream(body, int(info.Size))
+	}
+
+	start, end := int64(ranges.Ranges[0].Start), int64(ranges.Ranges[0].End)
+	if err := skip(body, start); err != nil {
+		body.Close()
+		return ErrResp(c, 500, "Get file error", err)
+	}
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size))
+	c.Status(fiber.StatusPartialContent)
+	part := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, end-start+1), body}
+	return c.SendStream(part, int(end-start+1))
+}
+
+// skip moves r n bytes ahead, seeking when it can.
+func skip(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// ReceiveFile answers a presigned PUT, storing the body if it is what the
+// URL was signed for.
+func (s *FiberServer) ReceiveFile(c *fiber.Ctx) error {
+	fileId, upload, status, err := s.servedFile(c)
+	if err == nil && upload == nil {
+		status, err = 403, storage.ErrInvalidSignature
+	}
+	if err != nil {
+		return ErrResp(c, status, "Upload not allowed", err)
+	}
+
+	if media.Normalize(c.Get(fiber.HeaderContentType)) != media.Normalize(upload.FileType) {
+		return ErrResp(c, 403, "`Content-Type` does not match the signed upload")
+	}
+	if int64(c.Request().Header.ContentLength()) != upload.Size {
+		return ErrResp(c, 400, "Body size does not match the signed upload")
+	}
+
+	// The body is checked before it is stored, it waits on disk meanwhile
+	tmp, err := os.CreateTemp("", "mercuria-upload-*")
+	if err != nil {
+		return ErrResp(c, 500, "Receive file error", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), requestBody(c))
+	if err != nil {
+		return ErrResp(c, 400, "Read body error", err)
+	}
+	if size != upload.Size {
+		return ErrResp(c, 400, "Body size does not match the signed upload")
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != upload.SHA256 {
+		return ErrResp(c, 400, "Body checksum does not match the signed upload")
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return ErrResp(c, 500, "Receive file error", err)
+	}
+
+	if _, err := s.storage.UploadFile(tmp, upload.Size, fileId, upload.FileType); err != nil {
+		return ErrResp(c, 500, "Upload file to storage error", err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
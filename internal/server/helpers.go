@@ -1,6 +1,13 @@
 package server
 
-import "github.com/google/uuid"
+import (
+	"log"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/jobs"
+
+	"github.com/google/uuid"
+)
 
 func UUID() uuid.UUID {
 	u, err := uuid.NewV7()
@@ -9,3 +16,14 @@ func UUID() uuid.UUID {
 	}
 	return u
 }
+
+// processPhotos queues the renditions of freshly recorded photos for the
+// worker. The photos are already saved, so a failure here only leaves them
+// without renditions.
+func (s *FiberServer) processPhotos(photos ...*database.Photo) {
+	for _, photo := range photos {
+		if _, err := s.queue.Enqueue(jobs.PhotoRenditions, jobs.PhotoPayload{PhotoID: photo.ID}); err != nil {
+			log.Printf("[processPhotos] %v", err)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"os"
 
+	"mercuria-backend/internal/database"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 
 	jwtMiddleware "github.com/gofiber/contrib/jwt"
 )
@@ -37,3 +45,161 @@ func jwtError(c *fiber.Ctx, err error) error {
 		"message": "EXPIRED_TOKEN",
 	})
 }
+
+// EventMember only lets members of the event referenced by the `param` route
+// parameter through. The caller's ID is stored in c.Locals("user_id") and
+// the event's *database.EventAccess in c.Locals("event_access").
+func (s *FiberServer) EventMember(param string) fiber.Handler {
+	return s.eventGuard(param, func(userId string, access *database.EventAccess) *fiber.Error {
+		if !access.IsMember {
+			return fiber.NewError(403, "Not a member of this event")
+		}
+		return nil
+	})
+}
+
+// EventOwner is EventMember restricted to the owner of the event.
+func (s *FiberServer) EventOwner(param string) fiber.Handler {
+	return s.eventGuard(param, func(userId string, access *database.EventAccess) *fiber.Error {
+		if !access.IsOwner(userId) {
+			return fiber.NewError(403, "Only the event owner can do this")
+		}
+		return nil
+	})
+}
+
+// EventCurator is EventMember restricted to the owner and co-hosts of the
+// event.
+func (s *FiberServer) EventCurator(param string) fiber.Handler {
+	return s.eventGuard(param, func(userId string, access *database.EventAccess) *fiber.Error {
+		if !access.CanCurate(userId) {
+			return fiber.NewError(403, "Only the event owner or co-hosts can do this")
+		}
+		return nil
+	})
+}
+
+// EventNotArchived rejects changes to archived events. It has to run after
+// EventMember or EventOwner.
+func (s *FiberServer) EventNotArchived() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if access, ok := c.Locals("event_access").(*database.EventAccess); ok && access.Archived {
+			return ErrResp(c, 409, database.ErrEventArchived.Error())
+		}
+		return c.Next()
+	}
+}
+
+func (s *FiberServer) eventGuard(param string, check func(userId string, access *database.EventAccess) *fiber.Error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		au, err := ExtractTokenMetadata(c)
+		if err != nil {
+			return ErrResp(c, 401, "Invalid authorization")
+		}
+		eventId := c.Params(param)
+		if _, err := uuid.Parse(eventId); err != nil {
+			return ErrResp(c, 400, "Invalid event id")
+		}
+
+		access, err := s.db.GetEventAccess(au.UserID, eventId)
+		if errors.Is(err, database.ErrNotFound) {
+			return ErrResp(c, 404, "Event not found")
+		}
+		if err != nil {
+			return ErrResp(c, 500, "Check membership error", err)
+		}
+		if err := check(au.UserID, access); err != nil {
+			return ErrResp(c, err.Code, err.Message)
+		}
+
+		c.Locals("user_id", au.UserID)
+		c.Locals("event_access", access)
+		return c.Next()
+	}
+}
+
+// LimitBody refuses request bodies larger than limit. The length a request
+// declares is checked up front; chunked bodies, whose length isn't known
+// until they are read, fail once they run over, see requestBody.
+func LimitBody(limit int64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if length := c.Request().Header.ContentLength(); int64(length) > limit {
+			c.Context().SetConnectionClose()
+			return ErrResp(c, 413, fmt.Sprintf("Request body is larger than %d bytes", limit))
+		}
+		c.Locals("body_limit", limit)
+
+		err := c.Next()
+		// Whatever is left of a body the handler didn't read would be taken
+		// for the next request of the connection
+		if body := c.Request().BodyStream(); body != nil {
+			if _, readErr := body.Read(make([]byte, 1)); readErr != io.EOF {
+				c.Context().SetConnectionClose()
+			}
+		}
+		return err
+	}
+}
+
+// ReadBody reads a chunked body whole, within the limit of LimitBody, for
+// the handlers that parse the body from memory.
+func ReadBody() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Request().Header.ContentLength() != -1 {
+			return c.Next()
+		}
+		body, err := io.ReadAll(requestBody(c))
+		if errors.Is(err, errBodyTooLarge) {
+			return ErrResp(c, 413, fmt.Sprintf("Request body is larger than %d bytes", bodyLimit(c)))
+		}
+		if err != nil {
+			return ErrResp(c, 400, "Read body error", err)
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
+
+var errBodyTooLarge = errors.New("request body is too large")
+
+// bodyLimit is the limit LimitBody set for the request.
+func bodyLimit(c *fiber.Ctx) int64 {
+	if limit, ok := c.Locals("body_limit").(int64); ok {
+		return limit
+	}
+	return maxRequestSize
+}
+
+// requestBody returns the body of the request as it arrives. Reading past
+// the body limit fails with errBodyTooLarge.
+func requestBody(c *fiber.Ctx) io.Reader {
+	if body := c.Request().BodyStream(); body != nil {
+		return &limitedBody{io.LimitedReader{R: body, N: bodyLimit(c) + 1}}
+	}
+	return bytes.NewReader(c.Body())
+}
+
+// limitedBody is an io.LimitedReader that fails when the limit is reached,
+// rather than ending the body there. The limit is one byte past the largest
+// body allowed.
+type limitedBody struct {
+	io.LimitedReader
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.LimitedReader.Read(p)
+	if b.N == 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// multipartForm parses the multipart form of the request as it arrives,
+// within the body limit. The caller removes the files of the form.
+func multipartForm(c *fiber.Ctx) (*multipart.Form, error) {
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, errors.New("request is not multipart/form-data")
+	}
+	return multipart.NewReader(requestBody(c), boundary).ReadForm(8 << 10)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLimitBody(t *testing.T) {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, DisablePreParseMultipartForm: true})
+	app.Put("/stream", LimitBody(8), func(c *fiber.Ctx) error {
+		body, err := io.ReadAll(requestBody(c))
+		if err != nil {
+			return ErrResp(c, 413, "Read body error", err)
+		}
+		return c.Send(body)
+	})
+	app.Use(LimitBody(8), ReadBody())
+	app.Put("/whole", func(c *fiber.Ctx) error {
+		return c.Send(c.Body())
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "streamed", path: "/stream", body: "12345678", status: 200},
+		{name: "streamed too large", path: "/stream", body: "123456789", status: 413},
+		{name: "streamed chunked", path: "/stream", body: "12345678", chunked: true, status: 200},
+		{name: "streamed chunked too large", path: "/stream", body: "123456789", chunked: true, status: 413},
+		{name: "whole", path: "/whole", body: "12345678", status: 200},
+		{name: "whole too large", path: "/whole", body: "123456789", status: 413},
+		{name: "whole chunked", path: "/whole", body: "12345678", chunked: true, status: 200},
+		{name: "whole chunked too large", path: "/whole", body: "123456789", chunked: true, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = 0
+				req.TransferEncoding = []string{"chunked"}
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got, _ := io.ReadAll(resp.Body); tt.status == 200 && string(got) != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func (s *FiberServer) ListNotifications(c *fiber.Ctx) error {
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return ErrResp(c, 401, "Invalid authorization")
+	}
+
+	page, err := s.db.ListNotifications(au.UserID, c.Query("cursor"), c.QueryInt("limit", database.DefaultNotificationPageSize))
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return ErrResp(c, 400, "Invalid `cursor`")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "List notifications error", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"data":        page.Notifications,
+		"next_cursor": page.NextCursor,
+		"unread":      page.Unread,
+	})
+}
+
+// MarkNotificationsRead marks the `ids` notifications as read, or all of
+// them without `ids`.
+func (s *FiberServer) MarkNotificationsRead(c *fiber.Ctx) error {
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return ErrResp(c, 401, "Invalid authorization")
+	}
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return ErrResp(c, 400, "Body parse error")
+		}
+	}
+	for _, id := range body.IDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return ErrResp(c, 400, "Invalid `ids`")
+		}
+	}
+
+	if err := s.db.MarkNotificationsRead(au.UserID, body.IDs); err != nil {
+		return ErrResp(c, 500, "Mark notifications read error", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"log"
+	"strings"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// reactionEmoji returns the configured form of an emoji, ignoring the
+// variation selector clients add or leave out, e.g. ❤ and ❤️.
+func reactionEmoji(emoji string) (string, bool) {
+	bare := strings.ReplaceAll(emoji, "\uFE0F", "")
+	for _, allowed := range reactionEmojis {
+		if strings.ReplaceAll(allowed, "\uFE0F", "") == bare {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+func (s *FiberServer) ListReactionEmojis(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"data": reactionEmojis,
+	})
+}
+
+func (s *FiberServer) ReactToPhoto(c *fiber.Ctx) error {
+	return s.setPhotoReaction(c, true)
+}
+
+func (s *FiberServer) UnreactToPhoto(c *fiber.Ctx) error {
+	return s.setPhotoReaction(c, false)
+}
+
+// setPhotoReaction adds or removes the caller's reaction and responds with
+// the photo's reaction counts.
+func (s *FiberServer) setPhotoReaction(c *fiber.Ctx, react bool) error {
+	var body struct {
+		Emoji string `json:"emoji"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	emoji, ok := reactionEmoji(body.Emoji)
+	if !ok {
+		return ErrResp(c, 400, "`emoji` must be one of "+strings.Join(reactionEmojis, " "))
+	}
+
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	userId := c.Locals("user_id").(string)
+	if react {
+		err = s.db.AddPhotoReaction(photo.ID, userId, emoji)
+	} else {
+		err = s.db.RemovePhotoReaction(photo.ID, userId, emoji)
+	}
+	if err != nil {
+		return ErrResp(c, 500, "React to photo error", err)
+	}
+
+	counts, err := s.db.CountPhotoReactions(userId, []string{photo.ID})
+	if err != nil {
+		return ErrResp(c, 500, "Count reactions error", err)
+	}
+	return c.JSON(fiber.Map{
+		"data": append([]database.ReactionCount{}, counts[photo.ID]...),
+	})
+}
+
+// ListPhotoReactions tells who reacted to a photo, with the `emoji` one
+// only if given.
+func (s *FiberServer) ListPhotoReactions(c *fiber.Ctx) error {
+	emoji := c.Query("emoji")
+	if emoji != "" {
+		var ok bool
+		if emoji, ok = reactionEmoji(emoji); !ok {
+			return ErrResp(c, 400, "`emoji` must be one of "+strings.Join(reactionEmojis, " "))
+		}
+	}
+
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	reactions, err := s.db.ListPhotoReactions(photo.ID, emoji)
+	if err != nil {
+		return ErrResp(c, 500, "List reactions error", err)
+	}
+	return c.JSON(fiber.Map{
+		"data": reactions,
+	})
+}
+
+// countPhotoReactions fills in the reaction counts of photos. Failing only
+// leaves them out.
+func (s *FiberServer) countPhotoReactions(userId string, photos []*database.Photo) {
+	if len(photos) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		ids = append(ids, photo.ID)
+	}
+	counts, err := s.db.CountPhotoReactions(userId, ids)
+	if err != nil {
+		log.Printf("[countPhotoReactions] %v", err)
+		return
+	}
+	for _, photo := range photos {
+		photo.Reactions = counts[photo.ID]
+	}
+}
+
+// countEventReactions fills in the reaction counts of the photos of events.
+func (s *FiberServer) countEventReactions(userId string, events []*database.Event) {
+	if len(events) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	counts, err := s.db.CountEventReactions(userId, ids)
+	if err != nil {
+		log.Printf("[countEventReactions] %v", err)
+		return
+	}
+	for _, event := range events {
+		for i := range event.Photos {
+			event.Photos[i].Reactions = counts[event.Photos[i].ID]
+		}
+	}
+}
@@ -2,15 +2,30 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/export"
+	"mercuria-backend/internal/imaging"
+	"mercuria-backend/internal/media"
+	"mercuria-backend/internal/video"
 
 	"github.com/Timothylock/go-signin-with-apple/apple"
 	"github.com/gofiber/fiber/v2"
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"google.golang.org/api/idtoken"
 )
 
@@ -23,22 +38,92 @@ func PublicRoutes(s *FiberServer) {
 	route.Post("/auth/google/login", s.GoogleLoginHandler) // oauth2, return Access & Refresh tokens
 	route.Post("/auth/apple/login", s.AppleLoginHandler)   // oauth2, return Access & Refresh tokens
 	route.Post("/auth/refresh-token", s.RefreshToken)
+
+	// Presigned URLs of storage backends served by the API, see files.go
+	route.Get("/files/*", s.ServeFile)
 }
 
 func PrivateRoutes(s *FiberServer) {
 	route := s.App.Group("/api/v1")
 
 	route.Get("events/:id", JWTProtected(), s.GetEvent)
+	route.Get("events/:id/photos", JWTProtected(), s.EventMember("id"), s.ListEventPhotos)
+	route.Get("events/:id/photos/:photoId/similar", JWTProtected(), s.EventMember("id"), s.ListSimilarPhotos)
+	route.Delete("events/:id/photos/:photoId", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.DeletePhoto)
+	route.Post("events/:id/photos/:photoId/restore", JWTProtected(), s.EventOwner("id"), s.EventNotArchived(), s.RestorePhoto)
+	route.Patch("photos/:id", JWTProtected(), s.UpdatePhoto)
+	route.Get("events/:id/trash", JWTProtected(), s.EventOwner("id"), s.ListTrash)
+	route.Get("events/:id/photos/:photoId/reactions", JWTProtected(), s.EventMember("id"), s.ListPhotoReactions)
+	route.Post("events/:id/photos/:photoId/reactions", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.ReactToPhoto)
+	route.Delete("events/:id/photos/:photoId/reactions", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.UnreactToPhoto)
+	route.Get("reactions", JWTProtected(), s.ListReactionEmojis)
+	route.Get("events/:id/photos/:photoId/tags", JWTProtected(), s.EventMember("id"), s.ListPhotoTags)
+	route.Post("events/:id/photos/:photoId/tags", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.TagPhoto)
+	route.Delete("events/:id/photos/:photoId/tags/:userId", JWTProtected(), s.EventMember("id"), s.UntagPhoto)
+	route.Get("me/photos", JWTProtected(), s.ListMyPhotos)
+	route.Get("me/notifications", JWTProtected(), s.ListNotifications)
+	route.Post("me/notifications/read", JWTProtected(), s.MarkNotificationsRead)
+	route.Get("events/:id/comments", JWTProtected(), s.EventMember("id"), s.ListEventComments)
+	route.Post("events/:id/comments", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.CreateEventComment)
+	route.Get("events/:id/comments/:commentId/replies", JWTProtected(), s.EventMember("id"), s.ListCommentReplies)
+	route.Patch("events/:id/comments/:commentId", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.UpdateComment)
+	route.Delete("events/:id/comments/:commentId", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.DeleteComment)
+	route.Get("events/:id/photos/:photoId/comments", JWTProtected(), s.EventMember("id"), s.ListPhotoComments)
+	route.Post("events/:id/photos/:photoId/comments", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.CreatePhotoComment)
+	route.Get("events/:id/cohosts", JWTProtected(), s.EventMember("id"), s.ListCohosts)
+	route.Put("events/:id/cohosts/:userId", JWTProtected(), s.EventOwner("id"), s.AddCohost)
+	route.Delete("events/:id/cohosts/:userId", JWTProtected(), s.EventOwner("id"), s.RemoveCohost)
+	route.Get("events/:id/albums", JWTProtected(), s.EventMember("id"), s.ListAlbums)
+	route.Post("events/:id/albums", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.CreateAlbum)
+	route.Put("events/:id/albums/order", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.OrderAlbums)
+	route.Get("events/:id/albums/:albumId", JWTProtected(), s.EventMember("id"), s.GetAlbum)
+	route.Patch("events/:id/albums/:albumId", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.UpdateAlbum)
+	route.Delete("events/:id/albums/:albumId", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.DeleteAlbum)
+	route.Get("events/:id/albums/:albumId/photos", JWTProtected(), s.EventMember("id"), s.ListAlbumPhotos)
+	route.Post("events/:id/albums/:albumId/photos", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.AddAlbumPhotos)
+	route.Delete("events/:id/albums/:albumId/photos", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.RemoveAlbumPhotos)
+	route.Put("events/:id/albums/:albumId/photos/order", JWTProtected(), s.EventCurator("id"), s.EventNotArchived(), s.OrderAlbumPhotos)
+	route.Get("events/:id/export", JWTProtected(), s.EventCurator("id"), s.DownloadEvent)
+	route.Post("events/:id/exports", JWTProtected(), s.EventCurator("id"), s.CreateExport)
+	route.Get("events/:id/exports/:exportId", JWTProtected(), s.EventCurator("id"), s.GetExport)
+	route.Patch("events/:id", JWTProtected(), s.EventOwner("id"), s.EventNotArchived(), s.UpdateEvent)
+	// Routes match in registration order, the literal path has to come first
+	route.Delete("events/dislike", JWTProtected(), s.DislikeEvent)
+	route.Delete("events/:id", JWTProtected(), s.EventOwner("id"), s.DeleteEvent)
+	route.Post("events/:id/archive", JWTProtected(), s.EventOwner("id"), s.ArchiveEvent)
+	route.Post("events/:id/unarchive", JWTProtected(), s.EventOwner("id"), s.UnarchiveEvent)
 	route.Get("events/user/:id", JWTProtected(), s.GetUserEvents)
 	route.Post("events/create", JWTProtected(), s.CreateEvent)
 	route.Post("events/like", JWTProtected(), s.LikeEvent)
 	route.Post("events/create-invite", JWTProtected(), s.CreateEventInvite)
 	route.Post("events/verify-invite", JWTProtected(), s.VerifyEventInvite)
-	route.Post("events/upload-photos", JWTProtected(), s.UploadPhotos)
-	route.Delete("events/dislike", JWTProtected(), s.DislikeEvent)
+	route.Post("events/:id/uploads", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.CreateUploadIntents)
+	route.Post("events/:id/uploads/confirm", JWTProtected(), s.EventMember("id"), s.EventNotArchived(), s.ConfirmUploads)
+}
+
+// UploadRoutes stream their bodies to the handlers, each under a body limit
+// of its own.
+func UploadRoutes(s *FiberServer) {
+	route := s.App.Group("/api/v1")
+
+	// Presigned URLs of storage backends served by the API, see files.go
+	route.Put("/files/*", LimitBody(maxUploadFileSize), s.ReceiveFile)
+	route.Post("events/upload-photos", LimitBody(maxUploadRequestSize), JWTProtected(), s.UploadPhotos)
+
+	// Resumable uploads, see tus.go
+	uploads := route.Group("uploads", TusResumable())
+	uploads.Options("", LimitBody(maxRequestSize), s.TusOptions)
+	uploads.Post("", LimitBody(maxRequestSize), JWTProtected(), s.TusCreate)
+	uploads.Head(":id", LimitBody(maxRequestSize), JWTProtected(), s.TusHead)
+	uploads.Patch(":id", LimitBody(maxUploadFileSize), JWTProtected(), s.TusPatch)
+	uploads.Delete(":id", LimitBody(maxRequestSize), JWTProtected(), s.TusTerminate)
 }
 
 func (s *FiberServer) RegisterFiberRoutes() {
+	// Routes match in registration order: the upload routes come ahead of
+	// the default limit, which the other routes read their bodies under
+	UploadRoutes(s)
+	s.App.Use(LimitBody(maxRequestSize), ReadBody())
 	PublicRoutes(s)
 	PrivateRoutes(s)
 }
@@ -143,13 +228,34 @@ func (s *FiberServer) GoogleLoginHandler(c *fiber.Ctx) error {
 		return ErrResp(c, 401, "Validation Failed")
 	}
 
-	// TODO: handle error and return 500
-	user := s.db.GetOrCreateUser(database.User{
-		OAuthId:   payload.Claims["sub"].(string),
-		Name:      payload.Claims["name"].(string),
-		AvatarUrl: payload.Claims["picture"].(string),
-		Email:     payload.Claims["email"].(string),
+	// Create the user and accept the invite together, so a failed invite
+	// doesn't leave a half-registered account behind. An invite that can't be
+	// accepted doesn't stop the login.
+	var user map[string]string
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		var err error
+		user, err = tx.GetOrCreateUser(database.User{
+			OAuthId:   payload.Claims["sub"].(string),
+			Name:      payload.Claims["name"].(string),
+			AvatarUrl: payload.Claims["picture"].(string),
+			Email:     payload.Claims["email"].(string),
+		})
+		if err != nil {
+			return err
+		}
+		if body.Invite == "" {
+			return nil
+		}
+		err = acceptInvite(tx, user["id"], body.Invite)
+		if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrEventArchived) {
+			log.Printf("[GoogleLoginHandler] invite %s: %v", body.Invite, err)
+			return nil
+		}
+		return err
 	})
+	if err != nil {
+		return ErrResp(c, 500, "Login error", err)
+	}
 	userId := user["id"]
 
 	// Create JWT and save to Redis
@@ -161,11 +267,6 @@ func (s *FiberServer) GoogleLoginHandler(c *fiber.Ctx) error {
 		return ErrResp(c, 500, "Save Token Details error", err)
 	}
 
-	// TODO: handle error and return 500
-	if body.Invite != "" {
-		s.db.AddEventMember(userId, body.Invite)
-	}
-
 	return c.JSON(fiber.Map{
 		"access_token":  tokenDetails.AccessToken,
 		"refresh_token": tokenDetails.RefreshToken,
@@ -217,15 +318,282 @@ func (s *FiberServer) AppleLoginHandler(c *fiber.Ctx) error {
 func (s *FiberServer) GetEvent(c *fiber.Ctx) error {
 	id := c.Params("id")
 	event, _ := s.db.GetEvent(id)
+	s.presentEvents(c, event)
 	return c.JSON(fiber.Map{
 		"data": event,
 	})
 }
 
+func (s *FiberServer) ListEventPhotos(c *fiber.Ctx) error {
+	query := database.PhotoQuery{
+		EventID:   c.Params("id"),
+		CreatedBy: c.Query("uploader"),
+		Sort:      database.PhotoSort(c.Query("sort", string(database.PhotoSortUploaded))),
+		Cursor:    c.Query("cursor"),
+		Limit:     c.QueryInt("limit", database.DefaultPhotoPageSize),
+	}
+
+	if query.Sort != database.PhotoSortUploaded && query.Sort != database.PhotoSortCaptured {
+		return ErrResp(c, 400, "`sort` must be `uploaded` or `captured`")
+	}
+	switch c.Query("order", "desc") {
+	case "asc":
+		query.Ascending = true
+	case "desc":
+	default:
+		return ErrResp(c, 400, "`order` must be `asc` or `desc`")
+	}
+	if query.CreatedBy != "" {
+		if _, err := uuid.Parse(query.CreatedBy); err != nil {
+			return ErrResp(c, 400, "Invalid `uploader`")
+		}
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return ErrResp(c, 400, "`since` must be an RFC 3339 timestamp", err)
+		}
+		query.Since = &t
+	}
+
+	page, err := s.db.ListEventPhotos(query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return ErrResp(c, 400, "Invalid `cursor`")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "List photos error", err)
+	}
+	photos := make([]*database.Photo, 0, len(page.Photos))
+	for i := range page.Photos {
+		s.signPhoto(&page.Photos[i])
+		photos = append(photos, &page.Photos[i])
+	}
+	s.countPhotoReactions(c.Locals("user_id").(string), photos)
+	s.loadPhotoTags(photos)
+
+	return c.JSON(fiber.Map{
+		"data":        page.Photos,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+const (
+	// Out of the 64 bits of a perceptual hash, see imaging.DHash
+	defaultSimilarDistance = 10
+	maxSimilarDistance     = 24
+	maxSimilarPhotos       = 50
+)
+
+// ListSimilarPhotos returns near-duplicates of a photo in the same event,
+// closest first. `max_distance` trades recall for precision.
+func (s *FiberServer) ListSimilarPhotos(c *fiber.Ctx) error {
+	distance := c.QueryInt("max_distance", defaultSimilarDistance)
+	if distance < 0 || distance > maxSimilarDistance {
+		return ErrResp(c, 400, fmt.Sprintf("`max_distance` must be between 0 and %d", maxSimilarDistance))
+	}
+
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	similar, err := s.db.ListSimilarPhotos(photo.ID, distance, maxSimilarPhotos)
+	if err != nil {
+		return ErrResp(c, 500, "List similar photos error", err)
+	}
+	photos := make([]*database.Photo, 0, len(similar))
+	for i := range similar {
+		s.signPhoto(&similar[i].Photo)
+		photos = append(photos, &similar[i].Photo)
+	}
+	s.countPhotoReactions(c.Locals("user_id").(string), photos)
+	s.loadPhotoTags(photos)
+
+	return c.JSON(fiber.Map{
+		"data": similar,
+	})
+}
+
+// DeletePhoto moves a photo to the trash, where the event owner can restore
+// it from until it is purged. Photos can be deleted by their uploader and by
+// the event owner.
+func (s *FiberServer) DeletePhoto(c *fiber.Ctx) error {
+	photo, status, err := s.findEventPhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+	userId := c.Locals("user_id").(string)
+	access := c.Locals("event_access").(*database.EventAccess)
+	if photo.CreatedBy != userId && !access.IsOwner(userId) {
+		return ErrResp(c, 403, "Only the uploader or the event owner can delete this photo")
+	}
+
+	err = s.db.TrashPhoto(photo.ID, userId)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Photo not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Delete photo error", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
+
+// UpdatePhoto changes the caption, alt text or capture time of a photo, for
+// its uploader and the event's owner and co-hosts.
+func (s *FiberServer) UpdatePhoto(c *fiber.Ctx) error {
+	var body struct {
+		Caption    *string    `json:"caption"`
+		AltText    *string    `json:"alt_text"`
+		CapturedAt *time.Time `json:"captured_at"`
+	}
+
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return ErrResp(c, 401, "Invalid authorization")
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if body.Caption != nil && utf8.RuneCountInString(*body.Caption) > maxPhotoCaptionLen {
+		return ErrResp(c, 400, "`caption` is too long")
+	}
+	if body.AltText != nil && utf8.RuneCountInString(*body.AltText) > maxPhotoAltTextLen {
+		return ErrResp(c, 400, "`alt_text` is too long")
+	}
+	if body.CapturedAt != nil && (body.CapturedAt.IsZero() || body.CapturedAt.After(time.Now().Add(24*time.Hour))) {
+		return ErrResp(c, 400, "`captured_at` must be a past RFC 3339 timestamp")
+	}
+
+	photoId := c.Params("id")
+	if _, err := uuid.Parse(photoId); err != nil {
+		return ErrResp(c, 404, "Photo not found")
+	}
+	photo, err := s.db.GetPhoto(photoId)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && photo.DeletedAt != nil) {
+		return ErrResp(c, 404, "Photo not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Get photo error", err)
+	}
+	access, err := s.db.GetEventAccess(au.UserID, photo.EventID)
+	if err != nil {
+		return ErrResp(c, 500, "Check membership error", err)
+	}
+	if !access.IsMember {
+		return ErrResp(c, 403, "Not a member of this event")
+	}
+	if photo.CreatedBy != au.UserID && !access.CanCurate(au.UserID) {
+		return ErrResp(c, 403, "Only the uploader or the event owner and co-hosts can edit this photo")
+	}
+	if access.Archived {
+		return ErrResp(c, 409, database.ErrEventArchived.Error())
+	}
+
+	err = s.db.UpdatePhoto(photo.ID, database.PhotoUpdate{
+		Caption:    body.Caption,
+		AltText:    body.AltText,
+		CapturedAt: body.CapturedAt,
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Photo not found")
+	}
+	if errors.Is(err, database.ErrInvalidPhoto) {
+		return ErrResp(c, 400, "Invalid photo details", err)
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Update photo error", err)
+	}
+
+	photo, err = s.db.GetPhoto(photo.ID)
+	if err != nil {
+		return ErrResp(c, 500, "Get photo error", err)
+	}
+	s.signPhoto(photo)
+	s.countPhotoReactions(au.UserID, []*database.Photo{photo})
+	s.loadPhotoTags([]*database.Photo{photo})
+
+	return c.JSON(fiber.Map{
+		"data": photo,
+	})
+}
+
+func (s *FiberServer) ListTrash(c *fiber.Ctx) error {
+	trashed, err := s.db.ListTrashedPhotos(c.Params("id"))
+	if err != nil {
+		return ErrResp(c, 500, "List trash error", err)
+	}
+	for i := range trashed {
+		s.signPhoto(&trashed[i].Photo)
+	}
+
+	return c.JSON(fiber.Map{
+		"data": trashed,
+	})
+}
+
+func (s *FiberServer) RestorePhoto(c *fiber.Ctx) error {
+	photo, status, err := s.findEventPhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	err = s.db.RestorePhoto(photo.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 409, "Photo is not in the trash")
+	}
+	if errors.Is(err, database.ErrDuplicatePhoto) {
+		return ErrResp(c, 409, "The same file was uploaded again since", err)
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Restore photo error", err)
+	}
+
+	photo.DeletedAt, photo.DeletedBy = nil, ""
+	s.signPhoto(photo)
+	return c.JSON(fiber.Map{
+		"data": photo,
+	})
+}
+
+// findEventPhoto loads the `photoId` photo of the `id` event, trashed or
+// not.
+func (s *FiberServer) findEventPhoto(c *fiber.Ctx) (*database.Photo, int, error) {
+	photoId := c.Params("photoId")
+	if _, err := uuid.Parse(photoId); err != nil {
+		return nil, 404, err
+	}
+	photo, err := s.db.GetPhoto(photoId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, 404, err
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	if photo.EventID != c.Params("id") {
+		return nil, 404, errors.New("photo of another event")
+	}
+	return photo, 200, nil
+}
+
+// findLivePhoto is findEventPhoto for photos not in the trash, trashed ones
+// look like they don't exist.
+func (s *FiberServer) findLivePhoto(c *fiber.Ctx) (*database.Photo, int, error) {
+	photo, status, err := s.findEventPhoto(c)
+	if err == nil && photo.DeletedAt != nil {
+		return nil, 404, database.ErrNotFound
+	}
+	return photo, status, err
+}
+
 func (s *FiberServer) GetUserEvents(c *fiber.Ctx) error {
 	userId := c.Params("id")
+	events := s.db.GetUserEvents(userId)
+	s.presentEvents(c, events...)
 	return c.JSON(fiber.Map{
-		"data": s.db.GetUserEvents(userId),
+		"data": events,
 	})
 }
 
@@ -233,6 +601,7 @@ func (s *FiberServer) CreateEvent(c *fiber.Ctx) error {
 	var body struct {
 		Name    string `json:"name"`
 		OwnerID string `json:"owner"`
+		eventDetails
 	}
 
 	if err := c.BodyParser(&body); err != nil {
@@ -249,14 +618,189 @@ func (s *FiberServer) CreateEvent(c *fiber.Ctx) error {
 			return ErrResp(c, 400, "Require "+field)
 		}
 	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+
+	einfo := database.Event{
+		Name:      body.Name,
+		OwnerID:   body.OwnerID,
+		StartsAt:  body.StartsAt,
+		EndsAt:    body.EndsAt,
+		Latitude:  body.Latitude,
+		Longitude: body.Longitude,
+	}
+	if body.Description != nil {
+		einfo.Description = *body.Description
+	}
+	if body.Timezone != nil {
+		einfo.Timezone = *body.Timezone
+	}
+	if body.VenueName != nil {
+		einfo.VenueName = *body.VenueName
+	}
+	if body.MetadataPrivacy != nil {
+		einfo.MetadataPrivacy = *body.MetadataPrivacy
+	}
+	einfo.MaxVideoDuration = body.MaxVideoDuration
+	einfo.MaxVideoSize = body.MaxVideoSize
 
-	id := s.db.CreateEvent(database.Event{
-		Name:    body.Name,
-		OwnerID: body.OwnerID,
+	var id string
+	err := s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		var err error
+		id, err = tx.CreateEvent(einfo)
+		if err != nil {
+			return err
+		}
+		return tx.AddEventMember(body.OwnerID, id)
 	})
+	if errors.Is(err, database.ErrInvalidEvent) {
+		return ErrResp(c, 400, "Invalid event details", err)
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Create event error", err)
+	}
 
 	event, _ := s.db.GetEvent(id)
+	s.presentEvents(c, event)
+
+	return c.JSON(fiber.Map{
+		"data": event,
+	})
+}
 
+// UpdateEvent changes the fields present in the body. null clears the
+// dates, the position and the text details.
+func (s *FiberServer) UpdateEvent(c *fiber.Ctx) error {
+	var body struct {
+		Name         *string `json:"name"`
+		ImageURL     *string `json:"image_url"`
+		CoverPhotoID *string `json:"cover_photo_id"`
+		eventDetails
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if body.Name != nil && strings.TrimSpace(*body.Name) == "" {
+		return ErrResp(c, 400, "`name` can't be empty")
+	}
+	if err := body.validate(); err != nil {
+		return ErrResp(c, 400, err.Error())
+	}
+	nulls := nullFields(c.Body())
+	if nulls["latitude"] != nulls["longitude"] {
+		return ErrResp(c, 400, "`latitude` and `longitude` must be cleared together")
+	}
+	// Text details are never null, clearing them empties them
+	empty := ""
+	if nulls["description"] {
+		body.Description = &empty
+	}
+	if nulls["venue_name"] {
+		body.VenueName = &empty
+	}
+	if body.ImageURL != nil && body.CoverPhotoID != nil {
+		return ErrResp(c, 400, "Use either `image_url` or `cover_photo_id`")
+	}
+	if body.ImageURL != nil && *body.ImageURL != "" {
+		u, err := url.Parse(*body.ImageURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return ErrResp(c, 400, "`image_url` must be an http(s) URL")
+		}
+	}
+
+	eventId := c.Params("id")
+	update := database.EventUpdate{
+		Name:             body.Name,
+		ImageURL:         body.ImageURL,
+		Description:      body.Description,
+		StartsAt:         body.StartsAt,
+		EndsAt:           body.EndsAt,
+		Timezone:         body.Timezone,
+		VenueName:        body.VenueName,
+		Latitude:         body.Latitude,
+		Longitude:        body.Longitude,
+		MetadataPrivacy:  body.MetadataPrivacy,
+		MaxVideoDuration: body.MaxVideoDuration,
+		MaxVideoSize:     body.MaxVideoSize,
+		ClearStartsAt:    nulls["starts_at"],
+		ClearEndsAt:      nulls["ends_at"],
+		ClearLocation:    nulls["latitude"],
+	}
+	if body.CoverPhotoID != nil {
+		if _, err := uuid.Parse(*body.CoverPhotoID); err != nil {
+			return ErrResp(c, 400, "Invalid `cover_photo_id`")
+		}
+		photo, err := s.db.GetPhoto(*body.CoverPhotoID)
+		if errors.Is(err, database.ErrNotFound) || (err == nil && (photo.EventID != eventId || photo.DeletedAt != nil)) {
+			return ErrResp(c, 400, "`cover_photo_id` is not a photo of this event")
+		}
+		if err != nil {
+			return ErrResp(c, 500, "Get photo error", err)
+		}
+		update.CoverPhotoID = &photo.ID
+	}
+
+	err := s.db.UpdateEvent(eventId, update)
+	if errors.Is(err, database.ErrInvalidEvent) {
+		return ErrResp(c, 400, "Invalid event details", err)
+	}
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+
+	event, _ := s.db.GetEvent(eventId)
+	s.presentEvents(c, event)
+	return c.JSON(fiber.Map{
+		"data": event,
+	})
+}
+
+func (s *FiberServer) DeleteEvent(c *fiber.Ctx) error {
+	var keys, exportIds []string
+	err := s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		var err error
+		if exportIds, err = tx.DeleteExports(c.Params("id"), ""); err != nil {
+			return err
+		}
+		keys, err = tx.DeleteEvent(c.Params("id"))
+		return err
+	})
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+
+	// The rows are gone at this point, a failure only leaves orphaned objects
+	keys = append(keys, imaging.RenditionKeys(keys...)...)
+	for _, id := range exportIds {
+		keys = append(keys, export.Key(id))
+	}
+	if err := s.storage.DeleteFiles(keys...); err != nil {
+		log.Printf("[DeleteEvent] %v", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "success",
+	})
+}
+
+func (s *FiberServer) ArchiveEvent(c *fiber.Ctx) error {
+	return s.setEventArchived(c, true)
+}
+
+func (s *FiberServer) UnarchiveEvent(c *fiber.Ctx) error {
+	return s.setEventArchived(c, false)
+}
+
+func (s *FiberServer) setEventArchived(c *fiber.Ctx, archived bool) error {
+	eventId := c.Params("id")
+	if err := s.db.SetEventArchived(eventId, archived); err != nil {
+		return EventErrResp(c, err)
+	}
+
+	event, _ := s.db.GetEvent(eventId)
+	s.presentEvents(c, event)
 	return c.JSON(fiber.Map{
 		"data": event,
 	})
@@ -275,8 +819,17 @@ func (s *FiberServer) LikeEvent(c *fiber.Ctx) error {
 		return ErrResp(c, 400, "Required `user_id` and `event_id`")
 	}
 
+	if err := s.db.CheckEventWritable(body.EventId); err != nil {
+		return EventErrResp(c, err)
+	}
+
+	state, err := s.db.LikeEvent(body.UserId, body.EventId)
+	if err != nil {
+		return ErrResp(c, 500, "Like event error", err)
+	}
+
 	return c.JSON(fiber.Map{
-		"message": s.db.LikeEvent(body.UserId, body.EventId),
+		"data": state,
 	})
 }
 
@@ -293,8 +846,17 @@ func (s *FiberServer) DislikeEvent(c *fiber.Ctx) error {
 		return ErrResp(c, 400, "Required `user_id` and `event_id`")
 	}
 
+	if err := s.db.CheckEventWritable(body.EventId); err != nil {
+		return EventErrResp(c, err)
+	}
+
+	state, err := s.db.DislikeEvent(body.UserId, body.EventId)
+	if err != nil {
+		return ErrResp(c, 500, "Dislike event error", err)
+	}
+
 	return c.JSON(fiber.Map{
-		"message": s.db.DislikeEvent(body.UserId, body.EventId),
+		"data": state,
 	})
 }
 
@@ -311,6 +873,10 @@ func (s *FiberServer) CreateEventInvite(c *fiber.Ctx) error {
 		return ErrResp(c, 400, "Required `event_id` and `created_by`")
 	}
 
+	if err := s.db.CheckEventWritable(body.EventId); err != nil {
+		return EventErrResp(c, err)
+	}
+
 	// Look at better ways to Create Invite
 	// token, err := generateInviteToken(body.EventId, body.CreatedBy)
 	// if err != nil {
@@ -337,18 +903,46 @@ func (s *FiberServer) VerifyEventInvite(c *fiber.Ctx) error {
 		return ErrResp(c, 400, "Required `user_id` and `invite`")
 	}
 
-	// TODO: Add better verification process
+	err := s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		return acceptInvite(tx, body.UserId, body.Invite)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Invite not found")
+	}
+	if errors.Is(err, database.ErrEventArchived) {
+		return ErrResp(c, 409, err.Error())
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Accept invite error", err)
+	}
 
 	return c.JSON(fiber.Map{
-		"message": s.db.AddEventMember(body.UserId, body.Invite),
+		"message": "success",
 	})
 }
 
+// acceptInvite adds the user to the event of the invite, its ID. Unknown
+// events return database.ErrNotFound, they are checked first: a failed
+// insert would abort the caller's transaction.
+func acceptInvite(tx database.Service, userId string, invite string) error {
+	if _, err := uuid.Parse(invite); err != nil {
+		return database.ErrNotFound
+	}
+	if err := tx.CheckEventWritable(invite); err != nil {
+		return err
+	}
+	return tx.AddEventMember(userId, invite)
+}
+
 func (s *FiberServer) UploadPhotos(c *fiber.Ctx) error {
-	form, err := c.MultipartForm()
+	form, err := multipartForm(c)
+	if errors.Is(err, errBodyTooLarge) {
+		return ErrResp(c, 413, fmt.Sprintf("Request body is larger than %d bytes", bodyLimit(c)))
+	}
 	if err != nil {
 		return ErrResp(c, 400, "Form data parse error")
 	}
+	defer form.RemoveAll()
 
 	createdByValues, creatorOk := form.Value["created_by"]
 	eventIdValues, eventOk := form.Value["event_id"]
@@ -356,7 +950,33 @@ func (s *FiberServer) UploadPhotos(c *fiber.Ctx) error {
 		return ErrResp(c, 400, "Required `created_by` and `event_id`")
 	}
 
+	// Archived events are read-only
+	settings := make(map[string]*database.EventMediaSettings)
+	for _, eventId := range slices.Compact(slices.Sorted(slices.Values(eventIdValues))) {
+		if eventId == "" {
+			continue
+		}
+		if err := s.db.CheckEventWritable(eventId); err != nil {
+			return EventErrResp(c, err)
+		}
+		if settings[eventId], err = s.db.GetEventMediaSettings(eventId); err != nil {
+			return EventErrResp(c, err)
+		}
+	}
+
 	files := form.File["photos"]
+	if len(createdByValues) < len(files) || len(eventIdValues) < len(files) {
+		return ErrResp(c, 400, "Required `created_by` and `event_id` for every photo")
+	}
+	for _, file := range files {
+		if file.Size > maxUploadFileSize {
+			return ErrResp(c, 413, fmt.Sprintf("%s is larger than %d bytes", media.SanitizeFileName(file.Filename), maxUploadFileSize))
+		}
+	}
+
+	// Check every file before uploading any
+	photos := make([]*database.Photo, 0, len(files))
+	seen := make(map[string]bool, len(files))
 	for i, file := range files {
 		createdBy := createdByValues[i]
 		eventId := eventIdValues[i]
@@ -364,18 +984,40 @@ func (s *FiberServer) UploadPhotos(c *fiber.Ctx) error {
 			return ErrResp(c, 400, "Required `created_by` and `event_id`")
 		}
 
-		fmt.Println(file.Filename, file.Size, file.Header["Content-Type"])
-		// => "photo.jpeg" 160037 "image/jpeg"
-
-		src, err := file.Open()
+		fileName := media.SanitizeFileName(file.Filename)
+		sum, fileType, err := inspectFormFile(file)
 		if err != nil {
-			return ErrResp(c, 500, "Open file error", err)
+			return ErrResp(c, 500, "Read file error", err)
+		}
+		if fileType == "" {
+			return ErrResp(c, 415, fmt.Sprintf("%s is not a supported image or video", fileName))
+		}
+		if declared := media.Normalize(file.Header.Get("Content-Type")); declared != fileType && declared != "application/octet-stream" {
+			return ErrResp(c, 415, fmt.Sprintf("%s is %s, not %s", fileName, fileType, declared))
+		}
+		if status, err := checkVideoSize(fileName, fileType, file.Size, settings[eventId]); err != nil {
+			return ErrResp(c, status, err.Error())
+		}
+		info, status, err := probeFormVideo(file, fileName, fileType, settings[eventId])
+		if status == 500 {
+			return ErrResp(c, 500, "Read file error", err)
+		}
+		if err != nil {
+			return ErrResp(c, status, err.Error())
 		}
-		defer src.Close()
 
-		fileBytes, _ := io.ReadAll(src)
-		fileName := file.Filename
-		fileType := file.Header.Get("Content-Type")
+		// Refuse exact duplicates before uploading them
+		if seen[eventId+sum] {
+			return ErrResp(c, 400, fmt.Sprintf("%s is in the request twice", fileName))
+		}
+		seen[eventId+sum] = true
+		existing, err := s.db.FindPhotoBySHA256(eventId, sum)
+		if err == nil {
+			return DuplicatePhotoResp(c, existing)
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return ErrResp(c, 500, "Find photo error", err)
+		}
 
 		photo := &database.Photo{
 			ID:        UUID().String(),
@@ -383,21 +1025,187 @@ func (s *FiberServer) UploadPhotos(c *fiber.Ctx) error {
 			FileName:  fileName,
 			FileType:  fileType,
 			EventID:   eventId,
+			SHA256:    sum,
+		}
+		if info != nil {
+			photo.DurationMs = info.DurationMs()
 		}
+		photos = append(photos, photo)
+	}
 
-		output, err := s.storage.UploadFile(fileBytes, photo.ID, fileType)
+	for i, photo := range photos {
+		location, err := s.uploadFormFile(files[i], photo, settings[photo.EventID])
+		if errors.Is(err, imaging.ErrCannotStrip) || errors.Is(err, video.ErrMalformed) {
+			s.discardUploads(photos[:i])
+			return ErrResp(c, 415, fmt.Sprintf("%s has metadata that can't be removed", photo.FileName))
+		}
 		if err != nil {
+			s.discardUploads(photos[:i])
 			return ErrResp(c, 500, "Upload file to storage error", err)
 		}
+		photo.PublicUrl = location
+	}
 
-		photo.PublicUrl = output.Location
-
-		if err := s.db.CreatePhoto(photo); err != nil {
-			return ErrResp(c, 500, err.Error())
+	// Record the whole batch or nothing
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		for _, photo := range photos {
+			if err := tx.CreatePhoto(photo); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if errors.Is(err, database.ErrDuplicatePhoto) {
+		s.discardUploads(photos)
+		return ErrResp(c, 409, err.Error())
+	}
+	if err != nil {
+		s.discardUploads(photos)
+		return ErrResp(c, 500, err.Error())
 	}
+	s.processPhotos(photos...)
 
 	return c.JSON(fiber.Map{
 		"message": "success",
 	})
 }
+
+// uploadFormFile streams one file of a multipart form to storage and
+// returns the object location. Events that ask for it get the metadata
+// stripped on the way, see stripUpload.
+func (s *FiberServer) uploadFormFile(file *multipart.FileHeader, photo *database.Photo, settings *database.EventMediaSettings) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if !stripsMetadata(settings) {
+		return s.storage.UploadFile(src, file.Size, photo.ID, photo.FileType)
+	}
+	return s.stripUpload(src, photo, settings)
+}
+
+// stripsMetadata reports whether the original of the photo is stored
+// without its metadata.
+func stripsMetadata(settings *database.EventMediaSettings) bool {
+	return settings.MetadataPrivacy != database.MetadataKeep
+}
+
+// stripUpload uploads src as the original of the photo with its metadata
+// stripped, and sets the capture time read before stripping on the photo.
+// Files that can't be stripped return imaging.ErrCannotStrip, or
+// video.ErrMalformed, and are not stored.
+func (s *FiberServer) stripUpload(src io.Reader, photo *database.Photo, settings *database.EventMediaSettings) (string, error) {
+	type result struct {
+		exif *imaging.Exif
+		err  error
+	}
+	// Stripping changes the size, the object is uploaded without knowing it
+	pr, pw := io.Pipe()
+	done := make(chan result)
+	go func() {
+		var exif *imaging.Exif
+		var err error
+		if database.MediaTypeOf(photo.FileType) == database.MediaVideo {
+			_, err = video.StripMetadata(pw, src, photo.FileType)
+		} else {
+			exif, _, err = imaging.StripMetadata(pw, src, settings.MetadataPrivacy == database.MetadataStripAll)
+		}
+		pw.CloseWithError(err)
+		done <- result{exif, err}
+	}()
+	location, err := s.storage.UploadFile(pr, -1, photo.ID, photo.FileType)
+	// Unblocks the stripping when the upload gave up early
+	pr.CloseWithError(err)
+	stripped := <-done
+	if stripped.err != nil {
+		return "", stripped.err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	photo.CapturedAt = imaging.ReadCaptureTime(stripped.exif, settings)
+	return location, nil
+}
+
+// stripStoredFile strips an original uploaded straight to storage, see
+// stripUpload. It runs before the photo is recorded: no URL to an original
+// is handed out before that, so none is served with its metadata. Files
+// that can't be stripped are refused, the caller deletes them.
+func (s *FiberServer) stripStoredFile(photo *database.Photo, settings *database.EventMediaSettings) (int, error) {
+	if !stripsMetadata(settings) {
+		return 0, nil
+	}
+	src, err := s.storage.DownloadFile(photo.ID)
+	if err != nil {
+		return 500, err
+	}
+	defer src.Close()
+
+	_, err = s.stripUpload(src, photo, settings)
+	if errors.Is(err, imaging.ErrCannotStrip) || errors.Is(err, video.ErrMalformed) {
+		return 415, fmt.Errorf("%s has metadata that can't be removed", photo.FileName)
+	}
+	if err != nil {
+		return 500, err
+	}
+	return 0, nil
+}
+
+// inspectFormFile returns the hex SHA-256 of a file of a multipart form and
+// its type, sniffed from the content. The type is "" unless it is one of
+// media.Types.
+func inspectFormFile(file *multipart.FileHeader) (string, string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	head := make([]byte, media.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	head = head[:n]
+
+	hash := sha256.New()
+	hash.Write(head)
+	if _, err := io.Copy(hash, src); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), media.Sniff(head), nil
+}
+
+// sniffStoredFile detects the type of an uploaded object from its first
+// bytes, see media.Sniff.
+func (s *FiberServer) sniffStoredFile(fileId string) (string, error) {
+	src, err := s.storage.DownloadFile(fileId)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	head := make([]byte, media.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return media.Sniff(head[:n]), nil
+}
+
+// discardUploads removes objects of a batch that will not be recorded.
+func (s *FiberServer) discardUploads(photos []*database.Photo) {
+	if len(photos) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		ids = append(ids, photo.ID)
+	}
+	if err := s.storage.DeleteFiles(ids...); err != nil {
+		log.Printf("[discardUploads] %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/queue"
 	"mercuria-backend/internal/redis"
 	"mercuria-backend/internal/storage"
 
@@ -17,21 +18,29 @@ type FiberServer struct {
 	redis redis.Service
 
 	storage storage.Service
+
+	queue queue.Service
 }
 
 func New() *FiberServer {
 	App := fiber.New(fiber.Config{
 		ServerHeader: "mercuria-backend",
 		AppName:      "mercuria-backend",
+		// Bodies are read by the handlers as they arrive rather than held in
+		// memory whole, so BodyLimit no longer applies: LimitBody takes its
+		// place. Multipart forms are parsed by the handlers too, once the
+		// request is authenticated.
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 
 	App.Use(cors.New(cors.Config{
 		AllowOrigins: "*", //@TODO For security set:
 		// os.Getenv("CLIENT_URL") and AllowCredentials: true
 		AllowCredentials: false,
-		AllowHeaders:     "Content-Type, Content-Length, Accept-Encoding, Authorization, accept, origin",
-		AllowMethods:     "POST, OPTIONS, GET, PUT, DELETE",
-		ExposeHeaders:    "Set-Cookie",
+		AllowHeaders:     "Content-Type, Content-Length, Accept-Encoding, Authorization, accept, origin, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset",
+		AllowMethods:     "POST, OPTIONS, GET, HEAD, PUT, PATCH, DELETE",
+		ExposeHeaders:    "Set-Cookie, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Offset",
 	}))
 
 	// Init postgres database
@@ -40,13 +49,17 @@ func New() *FiberServer {
 	// Init Redis - it's used to store the JWT
 	Redis := redis.New()
 
-	// Init S3 bucket to store images
+	// Init file storage (S3, MinIO, local disk or memory)
 	Storage := storage.New()
 
+	// Init job queue - slow work is left to the worker
+	Queue := queue.New()
+
 	return &FiberServer{
 		App:     App,
 		db:      DB,
 		redis:   Redis,
 		storage: Storage,
+		queue:   Queue,
 	}
 }
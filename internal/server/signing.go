@@ -0,0 +1,106 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Objects are private. Photos leave the API with presigned URLs in place of
+// their object keys, valid for downloadURLExpiry, and only for members of
+// the event; anyone else gets the photo without URLs.
+
+// Rendition shown for an event's cover photo, see imaging.Renditions
+const coverRendition = "medium"
+
+// signPhoto replaces the object keys of a photo with signed URLs.
+func (s *FiberServer) signPhoto(photo *database.Photo) {
+	expiresAt := time.Now().Add(downloadURLExpiry)
+	url, err := s.storage.PresignDownload(photo.ID, downloadURLExpiry)
+	if err != nil {
+		log.Printf("[signPhoto] %v", err)
+		hidePhoto(photo)
+		return
+	}
+	renditions := make(map[string]string, len(photo.Renditions))
+	for name, key := range photo.Renditions {
+		if renditions[name], err = s.storage.PresignDownload(key, downloadURLExpiry); err != nil {
+			log.Printf("[signPhoto] %v", err)
+			hidePhoto(photo)
+			return
+		}
+	}
+	photo.PublicUrl = url
+	photo.Renditions = renditions
+	photo.URLExpiresAt = &expiresAt
+}
+
+// hidePhoto drops the object locations of a photo.
+func hidePhoto(photo *database.Photo) {
+	photo.PublicUrl = ""
+	photo.Renditions = map[string]string{}
+	photo.URLExpiresAt = nil
+}
+
+// signAlbum signs the cover photo of an album. Albums are only shown to
+// members.
+func (s *FiberServer) signAlbum(album *database.Album) {
+	if album.Cover != nil {
+		s.signPhoto(album.Cover)
+	}
+}
+
+// signEvent signs the photos of an event for members, and sets its image to
+// the cover photo if it has one. It reports whether the user is a member.
+func (s *FiberServer) signEvent(event *database.Event, userId string) bool {
+
+	member := false
+	for _, m := range event.Members {
+		member = member || m.ID == userId
+	}
+	for i := range event.Photos {
+		if member {
+			s.signPhoto(&event.Photos[i])
+		} else {
+			hidePhoto(&event.Photos[i])
+		}
+	}
+
+	if event.CoverPhotoID == nil {
+		return member
+	}
+	event.ImageURL = ""
+	if !member {
+		return member
+	}
+	for _, photo := range event.Photos {
+		if photo.ID != *event.CoverPhotoID {
+			continue
+		}
+		event.ImageURL = photo.PublicUrl
+		if url, ok := photo.Renditions[coverRendition]; ok {
+			event.ImageURL = url
+		}
+		break
+	}
+	return member
+}
+
+// presentEvents prepares events for the user sending the request: photos
+// are signed, and counted reactions to, only for members.
+func (s *FiberServer) presentEvents(c *fiber.Ctx, events ...*database.Event) {
+	userId := ""
+	if au, err := ExtractTokenMetadata(c); err == nil && au != nil {
+		userId = au.UserID
+	}
+	joined := make([]*database.Event, 0, len(events))
+	for _, event := range events {
+		if event != nil && s.signEvent(event, userId) {
+			joined = append(joined, event)
+		}
+	}
+	s.countEventReactions(userId, joined)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/storage"
+)
+
+// presigner signs keys as "signed:<key>", and fails for the keys in broken.
+// Other storage methods are not used by signing.
+type presigner struct {
+	storage.Service
+	broken map[string]bool
+}
+
+func (p presigner) PresignDownload(fileId string, expires time.Duration) (string, error) {
+	if p.broken[fileId] {
+		return "", errors.New("presign failed")
+	}
+	return "signed:" + fileId, nil
+}
+
+func TestSignEvent(t *testing.T) {
+	cover := "p1"
+	newEvent := func() *database.Event {
+		return &database.Event{
+			ImageURL:     "old",
+			CoverPhotoID: &cover,
+			Members:      []database.User{{ID: "member"}},
+			Photos: []database.Photo{
+				{ID: "p1", PublicUrl: "p1", Renditions: map[string]string{"medium": "p1_medium", "thumb": "p1_thumb"}},
+				{ID: "p2", PublicUrl: "p2", Renditions: map[string]string{}},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		userId string
+		broken map[string]bool
+		// Signed URLs of each photo, nil when it is hidden
+		want  []map[string]string
+		image string
+	}{
+		{
+			name:   "member",
+			userId: "member",
+			want: []map[string]string{
+				{"": "signed:p1", "medium": "signed:p1_medium", "thumb": "signed:p1_thumb"},
+				{"": "signed:p2"},
+			},
+			image: "signed:p1_medium",
+		},
+		{name: "not a member", userId: "other", want: []map[string]string{nil, nil}},
+		{name: "anonymous", want: []map[string]string{nil, nil}},
+		{
+			name:   "rendition not signed",
+			userId: "member",
+			broken: map[string]bool{"p1_thumb": true},
+			want:   []map[string]string{nil, {"": "signed:p2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &FiberServer{storage: presigner{broken: tt.broken}}
+			event := newEvent()
+			s.signEvent(event, tt.userId)
+
+			for i, photo := range event.Photos {
+				var got map[string]string
+				if photo.PublicUrl != "" {
+					got = map[string]string{"": photo.PublicUrl}
+					maps.Copy(got, photo.Renditions)
+				}
+				if !maps.Equal(got, tt.want[i]) {
+					t.Errorf("photo %s URLs = %v, want %v", photo.ID, got, tt.want[i])
+				}
+				if (photo.URLExpiresAt != nil) != (tt.want[i] != nil) {
+					t.Errorf("photo %s expires at %v", photo.ID, photo.URLExpiresAt)
+				}
+			}
+			if event.ImageURL != tt.image {
+				t.Errorf("image = %q, want %q", event.ImageURL, tt.image)
+			}
+		})
+	}
+}
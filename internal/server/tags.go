@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"mercuria-backend/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Members tag members in photos. A tag can be removed by the tagged user,
+// whoever tagged them, and the owner and co-hosts of the event.
+
+func (s *FiberServer) ListPhotoTags(c *fiber.Ctx) error {
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+	return s.respondPhotoTags(c, photo.ID)
+}
+
+// TagPhoto tags the `user_id` member, or moves the `box` of their tag, and
+// notifies them of new tags.
+func (s *FiberServer) TagPhoto(c *fiber.Ctx) error {
+	var body struct {
+		UserID string           `json:"user_id"`
+		Box    *database.TagBox `json:"box"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if _, err := uuid.Parse(body.UserID); err != nil {
+		return ErrResp(c, 400, "Invalid `user_id`")
+	}
+	if body.Box != nil && !body.Box.Valid() {
+		return ErrResp(c, 400, "`box` must be within the photo, in fractions of its width and height")
+	}
+
+	photo, status, err := s.findLivePhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	userId := c.Locals("user_id").(string)
+	tag := &database.PhotoTag{
+		PhotoID:  photo.ID,
+		User:     database.User{ID: body.UserID},
+		TaggedBy: userId,
+		Box:      body.Box,
+	}
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		created, err := tx.TagPhoto(tag)
+		if err != nil || !created || body.UserID == userId {
+			return err
+		}
+		return tx.CreateNotification(&database.Notification{
+			UserID:  body.UserID,
+			Type:    database.NotificationPhotoTag,
+			Actor:   &database.User{ID: userId},
+			EventID: &photo.EventID,
+			PhotoID: &photo.ID,
+		})
+	})
+	if errors.Is(err, database.ErrInvalidTag) {
+		return ErrResp(c, 400, err.Error())
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Tag photo error", err)
+	}
+
+	return s.respondPhotoTags(c, photo.ID)
+}
+
+// UntagPhoto removes the tag of the `userId` member. Tags of trashed photos
+// can be removed too.
+func (s *FiberServer) UntagPhoto(c *fiber.Ctx) error {
+	photo, status, err := s.findEventPhoto(c)
+	if err != nil {
+		return ErrResp(c, status, "Photo not found", err)
+	}
+
+	tags, err := s.db.ListPhotoTags([]string{photo.ID})
+	if err != nil {
+		return ErrResp(c, 500, "List tags error", err)
+	}
+	var tag *database.PhotoTag
+	for i, t := range tags[photo.ID] {
+		if t.User.ID == c.Params("userId") {
+			tag = &tags[photo.ID][i]
+		}
+	}
+	if tag == nil {
+		return ErrResp(c, 404, "Tag not found")
+	}
+
+	userId := c.Locals("user_id").(string)
+	access := c.Locals("event_access").(*database.EventAccess)
+	if tag.User.ID != userId && tag.TaggedBy != userId && !access.CanCurate(userId) {
+		return ErrResp(c, 403, "Only the tagged user, who tagged them or the event owner and co-hosts can remove this tag")
+	}
+
+	err = s.db.UntagPhoto(photo.ID, tag.User.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 404, "Tag not found")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "Untag photo error", err)
+	}
+
+	return s.respondPhotoTags(c, photo.ID)
+}
+
+// ListMyPhotos lists the photos the caller is tagged in across their events.
+func (s *FiberServer) ListMyPhotos(c *fiber.Ctx) error {
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return ErrResp(c, 401, "Invalid authorization")
+	}
+
+	page, err := s.db.ListUserPhotos(database.UserPhotoQuery{
+		UserID: au.UserID,
+		Cursor: c.Query("cursor"),
+		Limit:  c.QueryInt("limit", database.DefaultPhotoPageSize),
+	})
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return ErrResp(c, 400, "Invalid `cursor`")
+	}
+	if err != nil {
+		return ErrResp(c, 500, "List photos error", err)
+	}
+	photos := make([]*database.Photo, 0, len(page.Photos))
+	for i := range page.Photos {
+		s.signPhoto(&page.Photos[i])
+		photos = append(photos, &page.Photos[i])
+	}
+	s.countPhotoReactions(au.UserID, photos)
+	s.loadPhotoTags(photos)
+
+	return c.JSON(fiber.Map{
+		"data":        page.Photos,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (s *FiberServer) respondPhotoTags(c *fiber.Ctx, photoId string) error {
+	tags, err := s.db.ListPhotoTags([]string{photoId})
+	if err != nil {
+		return ErrResp(c, 500, "List tags error", err)
+	}
+	return c.JSON(fiber.Map{
+		"data": append([]database.PhotoTag{}, tags[photoId]...),
+	})
+}
+
+// loadPhotoTags fills in the tags of photos.
+func (s *FiberServer) loadPhotoTags(photos []*database.Photo) {
+	if len(photos) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		ids = append(ids, photo.ID)
+	}
+	tags, err := s.db.ListPhotoTags(ids)
+	if err != nil {
+		log.Printf("[loadPhotoTags] %v", err)
+		return
+	}
+	for _, photo := range photos {
+		photo.Tags = tags[photo.ID]
+	}
+}
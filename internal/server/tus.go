@@ -0,0 +1,519 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/media"
+	"mercuria-backend/internal/storage"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads over tus 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation and termination extensions. Upload state lives in Redis,
+// the bytes go to an S3 multipart upload. S3 parts have a minimum size, so
+// whatever is left over after a PATCH is kept in Redis until the next one.
+// The state of finished uploads is kept as long, so clients that missed the
+// response of the last PATCH learn from HEAD that it went through.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	// Uploads are forgotten after a day without progress. The bucket should
+	// abort incomplete multipart uploads on a similar schedule.
+	tusUploadTTL = 24 * time.Hour
+	// PATCH bodies are read as they arrive, the lock is renewed after every
+	// part's worth of bytes. It outlives a part sent at 20 kB/s.
+	tusLockTTL    = 5 * time.Minute
+	tusOffsetType = "application/offset+octet-stream"
+)
+
+type tusUpload struct {
+	ID        string         `json:"id"`
+	EventID   string         `json:"event_id"`
+	CreatedBy string         `json:"created_by"`
+	FileName  string         `json:"file_name"`
+	FileType  string         `json:"file_type"`
+	Length    int64          `json:"length"`
+	Offset    int64          `json:"offset"`
+	S3Upload  string         `json:"s3_upload"`
+	Parts     []storage.Part `json:"parts"`
+	// State of the SHA-256 of the bytes received so far
+	HashState []byte `json:"hash_state"`
+	// Set once the multipart upload is assembled, so recording the photo can
+	// be retried without the S3 upload
+	Location string `json:"location,omitempty"`
+	// Set once the photo is recorded, the upload is then done
+	Recorded bool `json:"recorded,omitempty"`
+}
+
+func tusUploadKey(id string) string { return "tus:" + id }
+func tusTailKey(id string) string   { return "tus:" + id + ":tail" }
+func tusLockKey(id string) string   { return "tus:" + id + ":lock" }
+
+var (
+	// Deletes the lock KEYS[1] if it still holds the token ARGV[1]
+	tusUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+	// Extends the lock KEYS[1] to ARGV[2] milliseconds if it still holds the
+	// token ARGV[1]
+	tusRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// TusResumable answers with the protocol headers and rejects requests of
+// other protocol versions. OPTIONS requests are version discovery and pass.
+func TusResumable() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Tus-Resumable", tusVersion)
+		c.Set("Cache-Control", "no-store")
+		if c.Method() != fiber.MethodOptions && c.Get("Tus-Resumable") != tusVersion {
+			c.Set("Tus-Version", tusVersion)
+			return c.SendStatus(fiber.StatusPreconditionFailed)
+		}
+		return c.Next()
+	}
+}
+
+func (s *FiberServer) TusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(maxUploadFileSize, 10))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusCreate starts an upload. Upload-Metadata must carry `event_id`,
+// `filename` and `filetype`; the upload ID becomes the photo ID.
+func (s *FiberServer) TusCreate(c *fiber.Ctx) error {
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return ErrResp(c, 401, "Invalid authorization")
+	}
+	if c.Get("Upload-Defer-Length") != "" {
+		return ErrResp(c, 400, "Upload-Defer-Length is not supported")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return ErrResp(c, 400, "Invalid Upload-Length")
+	}
+	if length > maxUploadFileSize {
+		return ErrResp(c, 413, "Upload-Length exceeds Tus-Max-Size")
+	}
+
+	metadata, err := parseTusMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return ErrResp(c, 400, "Invalid Upload-Metadata", err)
+	}
+	eventId, fileName, fileType := metadata["event_id"], metadata["filename"], metadata["filetype"]
+	if eventId == "" || fileName == "" || fileType == "" {
+		return ErrResp(c, 400, "Upload-Metadata requires `event_id`, `filename` and `filetype`")
+	}
+	fileName, fileType = media.SanitizeFileName(fileName), media.Normalize(fileType)
+	if !media.Allowed(fileType) {
+		return ErrResp(c, 415, fileType+" is not a supported image or video type")
+	}
+	if _, err := uuid.Parse(eventId); err != nil {
+		return ErrResp(c, 400, "Invalid event id")
+	}
+
+	access, err := s.db.GetEventAccess(au.UserID, eventId)
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+	if !access.IsMember {
+		return ErrResp(c, 403, "Not a member of this event")
+	}
+	if access.Archived {
+		return ErrResp(c, 409, database.ErrEventArchived.Error())
+	}
+	settings, err := s.db.GetEventMediaSettings(eventId)
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+	if status, err := checkVideoSize(fileName, fileType, length, settings); err != nil {
+		return ErrResp(c, status, err.Error())
+	}
+
+	upload := &tusUpload{
+		ID:        UUID().String(),
+		EventID:   eventId,
+		CreatedBy: au.UserID,
+		FileName:  fileName,
+		FileType:  fileType,
+		Length:    length,
+		Parts:     []storage.Part{},
+	}
+	upload.HashState, err = hashState(sha256.New())
+	if err != nil {
+		return ErrResp(c, 500, "Create upload error", err)
+	}
+	upload.S3Upload, err = s.storage.CreateMultipartUpload(upload.ID, fileType)
+	if err != nil {
+		return ErrResp(c, 500, "Create upload error", err)
+	}
+	if err := s.saveTusUpload(upload, nil); err != nil {
+		s.storage.AbortMultipartUpload(upload.ID, upload.S3Upload)
+		return ErrResp(c, 500, "Save upload error", err)
+	}
+
+	c.Location(c.BaseURL() + "/api/v1/uploads/" + upload.ID)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+func (s *FiberServer) TusHead(c *fiber.Ctx) error {
+	upload, status, err := s.findTusUpload(c)
+	if err != nil {
+		return c.SendStatus(status)
+	}
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// TusPatch appends the body at Upload-Offset. Full S3 parts are uploaded
+// right away, and the photo is recorded once the last byte arrives.
+func (s *FiberServer) TusPatch(c *fiber.Ctx) error {
+	if c.Get("Content-Type") != tusOffsetType {
+		return ErrResp(c, 415, "Content-Type must be "+tusOffsetType)
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return ErrResp(c, 400, "Invalid Upload-Offset")
+	}
+	// The offset moves by the length the body declares
+	if c.Request().Header.ContentLength() < 0 {
+		return ErrResp(c, 411, "Content-Length required")
+	}
+
+	id := c.Params("id")
+	lock, status, err := s.lockTusUpload(id)
+	if err != nil {
+		return ErrResp(c, status, "Lock upload error", err)
+	}
+	defer s.unlockTusUpload(id, lock)
+
+	upload, status, err := s.findTusUpload(c)
+	if err != nil {
+		return ErrResp(c, status, "Upload not found", err)
+	}
+	if offset != upload.Offset {
+		return ErrResp(c, 409, "Upload-Offset does not match, HEAD the upload to resume")
+	}
+	length := int64(c.Request().Header.ContentLength())
+	if upload.Offset+length > upload.Length {
+		return ErrResp(c, 413, "Body exceeds Upload-Length")
+	}
+
+	if upload.Location == "" {
+		tail, err := s.redis.GetClient().Get(tusTailKey(id)).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return ErrResp(c, 500, "Load upload error", err)
+		}
+
+		hash := sha256.New()
+		if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+			return ErrResp(c, 500, "Load upload error", err)
+		}
+
+		// The body is read a part at a time, and what arrived before a
+		// broken connection is kept
+		body := requestBody(c)
+		data := tail
+		var read int64
+		var readErr error
+		for read < length && readErr == nil {
+			piece := make([]byte, min(length-read, storage.MinPartSize))
+			n, err := io.ReadFull(body, piece)
+			piece, readErr = piece[:n], err
+			hash.Write(piece)
+			data = append(data, piece...)
+			received := upload.Offset + read + int64(n)
+			final := received == upload.Length
+
+			// Once the first bytes are in, they must match the declared
+			// type. They are still in the tail, parts are much larger.
+			if upload.Offset+read < media.SniffLen && (received >= media.SniffLen || final) {
+				if detected := media.Sniff(data[:min(len(data), media.SniffLen)]); detected != upload.FileType {
+					s.storage.AbortMultipartUpload(upload.ID, upload.S3Upload)
+					s.redis.GetClient().Del(tusUploadKey(id), tusTailKey(id))
+					return ErrResp(c, 415, "Upload is not "+upload.FileType)
+				}
+			}
+			read = received - upload.Offset
+			// A request that took over the expired lock may be writing to
+			// the upload, nothing of this one is saved
+			if status, err := s.renewTusLock(id, lock); err != nil {
+				return ErrResp(c, status, "Renew upload lock error", err)
+			}
+			for len(data) >= storage.MinPartSize || (final && len(data) > 0) {
+				size := min(len(data), storage.MinPartSize)
+				if final && len(data) < 2*storage.MinPartSize {
+					// Keep the last part from ending up under the minimum
+					size = len(data)
+				}
+				part, err := s.storage.UploadPart(upload.ID, upload.S3Upload, int32(len(upload.Parts)+1), data[:size])
+				if err != nil {
+					return ErrResp(c, 500, "Upload part error", err)
+				}
+				upload.Parts = append(upload.Parts, *part)
+				data = data[size:]
+			}
+		}
+		if upload.HashState, err = hashState(hash); err != nil {
+			return ErrResp(c, 500, "Save upload error", err)
+		}
+		upload.Offset += read
+
+		if upload.Offset == upload.Length {
+			upload.Location, err = s.storage.CompleteMultipartUpload(upload.ID, upload.S3Upload, upload.Parts)
+			if err != nil {
+				return ErrResp(c, 500, "Complete upload error", err)
+			}
+		}
+		if err := s.saveTusUpload(upload, data); err != nil {
+			return ErrResp(c, 500, "Save upload error", err)
+		}
+		if readErr != nil {
+			c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			return ErrResp(c, 400, "Read body error", readErr)
+		}
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Location == "" || upload.Recorded {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	// The object is complete, record the photo. A failure here is retried by
+	// PATCHing an empty body at the final offset.
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+		return ErrResp(c, 500, "Load upload error", err)
+	}
+	photo := &database.Photo{
+		ID:        upload.ID,
+		PublicUrl: upload.Location,
+		FileName:  upload.FileName,
+		FileType:  upload.FileType,
+		CreatedBy: upload.CreatedBy,
+		EventID:   upload.EventID,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+	}
+	settings, err := s.db.GetEventMediaSettings(photo.EventID)
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+	status, err = s.checkStoredVideo(photo, settings)
+	if status == 500 {
+		return ErrResp(c, 500, "Check uploaded file error", err)
+	}
+	if err == nil {
+		status, err = s.stripStoredFile(photo, settings)
+		if status == 500 {
+			return ErrResp(c, 500, "Strip metadata error", err)
+		}
+	}
+	if err != nil {
+		// The upload is spent, the client has to start over
+		s.storage.DeleteFiles(upload.ID)
+		s.redis.GetClient().Del(tusUploadKey(id), tusTailKey(id))
+		return ErrResp(c, status, err.Error())
+	}
+	existing, err := s.db.FindPhotoBySHA256(photo.EventID, photo.SHA256)
+	if err == nil && existing.ID == photo.ID {
+		// Recorded by an earlier request that failed to save the state
+		return s.recordedTusUpload(c, upload)
+	}
+	if err == nil {
+		s.storage.DeleteFiles(upload.ID)
+		s.redis.GetClient().Del(tusUploadKey(id), tusTailKey(id))
+		return DuplicatePhotoResp(c, existing)
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return ErrResp(c, 500, "Find photo error", err)
+	}
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		if err := tx.CheckEventWritable(photo.EventID); err != nil {
+			return err
+		}
+		return tx.CreatePhoto(photo)
+	})
+	if errors.Is(err, database.ErrDuplicatePhoto) {
+		return ErrResp(c, 409, err.Error())
+	}
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+	s.processPhotos(photo)
+
+	return s.recordedTusUpload(c, upload)
+}
+
+// recordedTusUpload marks the upload done. A failure is not the client's
+// problem, the photo is recorded: the next PATCH finds it.
+func (s *FiberServer) recordedTusUpload(c *fiber.Ctx, upload *tusUpload) error {
+	upload.Recorded = true
+	if err := s.saveTusUpload(upload, nil); err != nil {
+		log.Printf("[TusPatch] %v", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusTerminate cancels an unfinished upload and frees its parts. Uploads
+// whose photo is recorded are only forgotten, the photo stays.
+func (s *FiberServer) TusTerminate(c *fiber.Ctx) error {
+	id := c.Params("id")
+	lock, status, err := s.lockTusUpload(id)
+	if err != nil {
+		return ErrResp(c, status, "Lock upload error", err)
+	}
+	defer s.unlockTusUpload(id, lock)
+
+	upload, status, err := s.findTusUpload(c)
+	if err != nil {
+		return ErrResp(c, status, "Upload not found", err)
+	}
+	switch {
+	case upload.Recorded:
+		// The file is the photo's now
+	case upload.Location == "":
+		if err := s.storage.AbortMultipartUpload(upload.ID, upload.S3Upload); err != nil {
+			return ErrResp(c, 500, "Abort upload error", err)
+		}
+	default:
+		if err := s.storage.DeleteFiles(upload.ID); err != nil {
+			return ErrResp(c, 500, "Delete upload error", err)
+		}
+	}
+	s.redis.GetClient().Del(tusUploadKey(upload.ID), tusTailKey(upload.ID))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// lockTusUpload keeps other requests off an upload until its lock is
+// released, or expires. It returns the random token the lock holds, only
+// the request that has it can renew or release the lock.
+func (s *FiberServer) lockTusUpload(id string) (string, int, error) {
+	token := uuid.NewString()
+	locked, err := s.redis.GetClient().SetNX(tusLockKey(id), token, tusLockTTL).Result()
+	if err != nil {
+		return "", 500, err
+	}
+	if !locked {
+		return "", 423, errors.New("upload is in use by another request")
+	}
+	return token, 200, nil
+}
+
+// renewTusLock extends a lock taken by lockTusUpload. It fails once the lock
+// expired, whether or not another request took it since.
+func (s *FiberServer) renewTusLock(id string, token string) (int, error) {
+	renewed, err := tusRenewScript.Run(s.redis.GetClient(), []string{tusLockKey(id)}, token, tusLockTTL.Milliseconds()).Int()
+	if err != nil {
+		return 500, err
+	}
+	if renewed == 0 {
+		return 409, errors.New("the lock expired, HEAD the upload to resume")
+	}
+	return 200, nil
+}
+
+// unlockTusUpload releases a lock taken by lockTusUpload, unless it expired
+// and belongs to another request now.
+func (s *FiberServer) unlockTusUpload(id string, token string) {
+	if err := tusUnlockScript.Run(s.redis.GetClient(), []string{tusLockKey(id)}, token).Err(); err != nil {
+		log.Printf("[unlockTusUpload] %v", err)
+	}
+}
+
+// findTusUpload loads the upload of the route and makes sure it belongs to
+// the caller. Uploads of other users look like they don't exist.
+func (s *FiberServer) findTusUpload(c *fiber.Ctx) (*tusUpload, int, error) {
+	au, err := ExtractTokenMetadata(c)
+	if err != nil {
+		return nil, 401, err
+	}
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, 404, err
+	}
+
+	raw, err := s.redis.GetClient().Get(tusUploadKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, 404, err
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(raw, &upload); err != nil {
+		return nil, 500, err
+	}
+	if upload.CreatedBy != au.UserID {
+		return nil, 404, errors.New("upload of another user")
+	}
+	return &upload, 200, nil
+}
+
+// saveTusUpload stores the upload state together with the bytes that did not
+// fill a whole part yet.
+func (s *FiberServer) saveTusUpload(upload *tusUpload, tail []byte) error {
+	state, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.GetClient().TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(tusUploadKey(upload.ID), state, tusUploadTTL)
+		if len(tail) > 0 {
+			pipe.Set(tusTailKey(upload.ID), tail, tusUploadTTL)
+		} else {
+			pipe.Del(tusTailKey(upload.ID))
+		}
+		return nil
+	})
+	return err
+}
+
+func hashState(h hash.Hash) ([]byte, error) {
+	return h.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value" pairs.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/media"
+	"mercuria-backend/internal/storage"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// How long a presigned PUT URL stays valid
+	uploadURLExpiry = 15 * time.Minute
+	// Intents outlive their URLs a bit so an upload that started just before
+	// the URL expired can still be confirmed
+	uploadIntentTTL      = uploadURLExpiry + 15*time.Minute
+	maxUploadIntentFiles = 50
+)
+
+// uploadIntent is kept in Redis between handing out a presigned URL and the
+// client confirming the upload.
+type uploadIntent struct {
+	PhotoID   string `json:"photo_id"`
+	EventID   string `json:"event_id"`
+	CreatedBy string `json:"created_by"`
+	FileName  string `json:"file_name"`
+	FileType  string `json:"file_type"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Location  string `json:"location"`
+}
+
+func uploadIntentKey(photoId string) string {
+	return "upload-intent:" + photoId
+}
+
+// Takes the upload intent KEYS[1] out of Redis and returns it with its
+// remaining lifetime in milliseconds
+var claimIntentScript = redis.NewScript(`
+local raw = redis.call('GET', KEYS[1])
+if not raw then return false end
+local ttl = redis.call('PTTL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return {raw, ttl}`)
+
+// claimedIntent is an upload intent a confirmation took out of Redis.
+type claimedIntent struct {
+	uploadIntent
+	ttl time.Duration
+}
+
+// CreateUploadIntents hands out presigned PUT URLs, one per file, for the
+// client to upload straight to the bucket. Files come with their SHA-256,
+// photos already in the event are refused before any byte is sent.
+func (s *FiberServer) CreateUploadIntents(c *fiber.Ctx) error {
+	var body struct {
+		Files []struct {
+			FileName string `json:"file_name"`
+			FileType string `json:"file_type"`
+			Size     int64  `json:"size"`
+			SHA256   string `json:"sha256"`
+		} `json:"files"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if len(body.Files) == 0 {
+		return ErrResp(c, 400, "Required `files`")
+	}
+	if len(body.Files) > maxUploadIntentFiles {
+		return ErrResp(c, 400, fmt.Sprintf("At most %d files per request", maxUploadIntentFiles))
+	}
+	seen := make(map[string]bool, len(body.Files))
+	for i := range body.Files {
+		file := &body.Files[i]
+		file.FileName = media.SanitizeFileName(file.FileName)
+		file.FileType = media.Normalize(file.FileType)
+		if file.FileName == "" || file.FileType == "" || file.Size <= 0 || file.SHA256 == "" {
+			return ErrResp(c, 400, "Every file requires `file_name`, `file_type`, `size` and `sha256`")
+		}
+		if !validSHA256(file.SHA256) {
+			return ErrResp(c, 400, "`sha256` must be a lowercase hex SHA-256 digest")
+		}
+		if !media.Allowed(file.FileType) {
+			return ErrResp(c, 415, fmt.Sprintf("%s: %s is not a supported image or video type", file.FileName, file.FileType))
+		}
+		if file.Size > maxUploadFileSize {
+			return ErrResp(c, 413, fmt.Sprintf("%s is larger than %d bytes", file.FileName, maxUploadFileSize))
+		}
+		if seen[file.SHA256] {
+			return ErrResp(c, 400, fmt.Sprintf("%s is in the request twice", file.FileName))
+		}
+		seen[file.SHA256] = true
+	}
+
+	eventId := c.Params("id")
+	userId := c.Locals("user_id").(string)
+	settings, err := s.db.GetEventMediaSettings(eventId)
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+
+	for _, file := range body.Files {
+		if status, err := checkVideoSize(file.FileName, file.FileType, file.Size, settings); err != nil {
+			return ErrResp(c, status, err.Error())
+		}
+		existing, err := s.db.FindPhotoBySHA256(eventId, file.SHA256)
+		if err == nil {
+			return DuplicatePhotoResp(c, existing)
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return ErrResp(c, 500, "Find photo error", err)
+		}
+	}
+
+	uploads := make([]fiber.Map, 0, len(body.Files))
+	for _, file := range body.Files {
+		photoId := UUID().String()
+		req, err := s.storage.PresignUpload(photoId, file.FileType, file.Size, file.SHA256, uploadURLExpiry)
+		if err != nil {
+			return ErrResp(c, 500, "Presign upload error", err)
+		}
+
+		intent, _ := json.Marshal(uploadIntent{
+			PhotoID:   photoId,
+			EventID:   eventId,
+			CreatedBy: userId,
+			FileName:  file.FileName,
+			FileType:  file.FileType,
+			Size:      file.Size,
+			SHA256:    file.SHA256,
+			Location:  req.Location,
+		})
+		if err := s.redis.GetClient().Set(uploadIntentKey(photoId), intent, uploadIntentTTL).Err(); err != nil {
+			return ErrResp(c, 500, "Save upload intent error", err)
+		}
+
+		uploads = append(uploads, fiber.Map{
+			"photo_id":   photoId,
+			"file_name":  file.FileName,
+			"url":        req.URL,
+			"method":     req.Method,
+			"headers":    req.Headers,
+			"expires_at": req.ExpiresAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": uploads,
+	})
+}
+
+// ConfirmUploads records the photos of finished direct uploads. It checks
+// every object actually landed in the bucket with the declared type, and
+// records all of them or none.
+func (s *FiberServer) ConfirmUploads(c *fiber.Ctx) error {
+	var body struct {
+		PhotoIDs []string `json:"photo_ids"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ErrResp(c, 400, "Body parse error")
+	}
+	if len(body.PhotoIDs) == 0 {
+		return ErrResp(c, 400, "Required `photo_ids`")
+	}
+	if len(body.PhotoIDs) > maxUploadIntentFiles {
+		return ErrResp(c, 400, fmt.Sprintf("At most %d files per request", maxUploadIntentFiles))
+	}
+
+	// A repeated ID would be recorded twice
+	photoIds := make([]string, 0, len(body.PhotoIDs))
+	seen := make(map[string]bool, len(body.PhotoIDs))
+	for _, photoId := range body.PhotoIDs {
+		if _, err := uuid.Parse(photoId); err != nil {
+			return ErrResp(c, 400, "Invalid photo id "+photoId)
+		}
+		if !seen[photoId] {
+			seen[photoId] = true
+			photoIds = append(photoIds, photoId)
+		}
+	}
+
+	eventId := c.Params("id")
+	userId := c.Locals("user_id").(string)
+	settings, err := s.db.GetEventMediaSettings(eventId)
+	if err != nil {
+		return EventErrResp(c, err)
+	}
+
+	// Intents are claimed, so of two confirmations of the same upload only
+	// one goes ahead. Failures put back the intents that are not spent, the
+	// client can confirm them again.
+	var claimed []*claimedIntent
+	release := func(spent ...string) {
+		claimed = slices.DeleteFunc(claimed, func(intent *claimedIntent) bool {
+			return slices.Contains(spent, intent.PhotoID)
+		})
+		s.releaseUploadIntents(claimed)
+	}
+
+	photos := make([]*database.Photo, 0, len(photoIds))
+	intents := make([]*claimedIntent, 0, len(photoIds))
+	var missing []string
+	for _, photoId := range photoIds {
+		intent, err := s.claimUploadIntent(photoId)
+		if errors.Is(err, redis.Nil) {
+			release()
+			return ErrResp(c, 404, "Upload not found or expired", fmt.Errorf("photo %s", photoId))
+		}
+		if err != nil {
+			release()
+			return ErrResp(c, 500, "Get upload intent error", err)
+		}
+		claimed = append(claimed, intent)
+		if intent.EventID != eventId || intent.CreatedBy != userId {
+			release()
+			return ErrResp(c, 404, "Upload not found or expired", fmt.Errorf("photo %s", photoId))
+		}
+
+		info, err := s.storage.HeadFile(photoId)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && info.Size != intent.Size) {
+			missing = append(missing, photoId)
+			continue
+		}
+		if err != nil {
+			release()
+			return ErrResp(c, 500, "Check uploaded file error", err)
+		}
+		fileType, err := s.sniffStoredFile(photoId)
+		if err != nil {
+			release()
+			return ErrResp(c, 500, "Check uploaded file error", err)
+		}
+		if fileType != intent.FileType {
+			// The intent is spent, the client has to start over
+			s.storage.DeleteFiles(photoId)
+			release(photoId)
+			return ErrResp(c, 415, fmt.Sprintf("%s is not %s", intent.FileName, intent.FileType))
+		}
+
+		photo := &database.Photo{
+			ID:        intent.PhotoID,
+			PublicUrl: intent.Location,
+			FileName:  intent.FileName,
+			FileType:  intent.FileType,
+			CreatedBy: intent.CreatedBy,
+			EventID:   intent.EventID,
+			SHA256:    intent.SHA256,
+		}
+		status, err := s.checkStoredVideo(photo, settings)
+		if status == 500 {
+			release()
+			return ErrResp(c, 500, "Check uploaded file error", err)
+		}
+		if err != nil {
+			s.storage.DeleteFiles(photoId)
+			release(photoId)
+			return ErrResp(c, status, err.Error())
+		}
+		photos = append(photos, photo)
+		intents = append(intents, intent)
+	}
+	if len(missing) > 0 {
+		release()
+		return ErrResp(c, 409, "Files not uploaded", errors.New(strings.Join(missing, ", ")))
+	}
+
+	// Identical photos confirmed since the intents were created are refused
+	// and their uploads dropped. The photo found may be this very upload,
+	// recorded by an earlier confirmation: its file is kept.
+	for _, photo := range photos {
+		existing, err := s.db.FindPhotoBySHA256(eventId, photo.SHA256)
+		if err == nil {
+			if existing.ID != photo.ID {
+				s.discardUploads([]*database.Photo{photo})
+			}
+			release(photo.ID)
+			return DuplicatePhotoResp(c, existing)
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			release()
+			return ErrResp(c, 500, "Find photo error", err)
+		}
+	}
+
+	// Stripping changes the size, the intent is updated so a confirmation
+	// that fails after this still finds the file
+	for i, photo := range photos {
+		status, err := s.stripStoredFile(photo, settings)
+		if status == 500 {
+			release()
+			return ErrResp(c, 500, "Strip metadata error", err)
+		}
+		if err != nil {
+			s.discardUploads([]*database.Photo{photo})
+			release(photo.ID)
+			return ErrResp(c, status, err.Error())
+		}
+		if !stripsMetadata(settings) {
+			continue
+		}
+		info, err := s.storage.HeadFile(photo.ID)
+		if err != nil {
+			release()
+			return ErrResp(c, 500, "Check uploaded file error", err)
+		}
+		intents[i].Size = info.Size
+	}
+
+	err = s.db.WithTx(c.UserContext(), func(tx database.Service) error {
+		for _, photo := range photos {
+			if err := tx.CreatePhoto(photo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, database.ErrDuplicatePhoto) {
+		release()
+		return ErrResp(c, 409, err.Error())
+	}
+	if err != nil {
+		release()
+		return ErrResp(c, 500, "Create photos error", err)
+	}
+	s.processPhotos(photos...)
+
+	for _, photo := range photos {
+		s.signPhoto(photo)
+	}
+	return c.JSON(fiber.Map{
+		"data": photos,
+	})
+}
+
+// claimUploadIntent takes the intent of an upload out of Redis, see
+// claimIntentScript. It returns redis.Nil when there is none.
+func (s *FiberServer) claimUploadIntent(photoId string) (*claimedIntent, error) {
+	res, err := claimIntentScript.Run(s.redis.GetClient(), []string{uploadIntentKey(photoId)}).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected reply %v", res)
+	}
+	raw, _ := values[0].(string)
+	ttl, _ := values[1].(int64)
+	intent := &claimedIntent{ttl: time.Duration(ttl) * time.Millisecond}
+	if err := json.Unmarshal([]byte(raw), &intent.uploadIntent); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// releaseUploadIntents puts claimed intents back for the rest of their
+// lifetime.
+func (s *FiberServer) releaseUploadIntents(intents []*claimedIntent) {
+	for _, intent := range intents {
+		raw, err := json.Marshal(intent.uploadIntent)
+		if err == nil {
+			err = s.redis.GetClient().Set(uploadIntentKey(intent.PhotoID), raw, intent.ttl).Err()
+		}
+		if err != nil {
+			log.Printf("[releaseUploadIntents] %s: %v", intent.PhotoID, err)
+		}
+	}
+}
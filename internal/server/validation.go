@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // IANA zones for event timezones on hosts without zoneinfo
+	"unicode/utf8"
+
+	"mercuria-backend/internal/database"
+)
+
+const (
+	maxEventDescriptionLen = 2000
+	maxEventVenueNameLen   = 200
+	maxPhotoCaptionLen     = 2000
+	maxPhotoAltTextLen     = 1000
+)
+
+// eventDetails are the optional descriptive fields of an event, shared by
+// the create and update bodies.
+type eventDetails struct {
+	Description *string    `json:"description"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	Timezone    *string    `json:"timezone"`
+	VenueName   *string    `json:"venue_name"`
+	Latitude    *float64   `json:"latitude"`
+	Longitude   *float64   `json:"longitude"`
+	// Stripped from stored originals, see database.MetadataPrivacy
+	MetadataPrivacy *database.MetadataPrivacy `json:"metadata_privacy"`
+	// Video limits of the event in seconds and bytes, within the server's
+	MaxVideoDuration *int   `json:"max_video_duration"`
+	MaxVideoSize     *int64 `json:"max_video_size"`
+}
+
+func (d eventDetails) validate() error {
+	if d.Description != nil && utf8.RuneCountInString(*d.Description) > maxEventDescriptionLen {
+		return errors.New("`description` is too long")
+	}
+	if d.VenueName != nil && utf8.RuneCountInString(*d.VenueName) > maxEventVenueNameLen {
+		return errors.New("`venue_name` is too long")
+	}
+	if d.Timezone != nil {
+		if _, err := time.LoadLocation(*d.Timezone); err != nil || *d.Timezone == "" {
+			return errors.New("`timezone` must be an IANA time zone name, e.g. `Europe/Kyiv`")
+		}
+	}
+	if d.StartsAt != nil && d.EndsAt != nil && d.EndsAt.Before(*d.StartsAt) {
+		return errors.New("`ends_at` must not be before `starts_at`")
+	}
+	if (d.Latitude == nil) != (d.Longitude == nil) {
+		return errors.New("`latitude` and `longitude` must be set together")
+	}
+	if d.Latitude != nil && (*d.Latitude < -90 || *d.Latitude > 90) {
+		return errors.New("`latitude` must be between -90 and 90")
+	}
+	if d.Longitude != nil && (*d.Longitude < -180 || *d.Longitude > 180) {
+		return errors.New("`longitude` must be between -180 and 180")
+	}
+	if d.MetadataPrivacy != nil && !d.MetadataPrivacy.Valid() {
+		return errors.New("`metadata_privacy` must be one of `keep`, `strip_location`, `strip_all`")
+	}
+	if d.MaxVideoDuration != nil && (*d.MaxVideoDuration < 1 || *d.MaxVideoDuration > int(maxVideoDuration.Seconds())) {
+		return fmt.Errorf("`max_video_duration` must be between 1 and %d seconds", int(maxVideoDuration.Seconds()))
+	}
+	if d.MaxVideoSize != nil && (*d.MaxVideoSize < 1 || *d.MaxVideoSize > videoSizeLimit()) {
+		return fmt.Errorf("`max_video_size` must be between 1 and %d bytes", videoSizeLimit())
+	}
+	return nil
+}
+
+// nullFields returns which fields of a JSON object are null. Updates take
+// null as clearing a field: decoded into a pointer, it can't be told from a
+// field that was left out.
+func nullFields(body []byte) map[string]bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	nulls := make(map[string]bool)
+	for name, value := range fields {
+		if string(value) == "null" {
+			nulls[name] = true
+		}
+	}
+	return nulls
+}
+
+// validSHA256 accepts a lowercase hex SHA-256 digest.
+func validSHA256(s string) bool {
+	raw, err := hex.DecodeString(s)
+	return err == nil && len(raw) == 32 && strings.ToLower(s) == s
+}
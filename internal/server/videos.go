@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"mercuria-backend/internal/database"
+	"mercuria-backend/internal/video"
+)
+
+// Videos are uploaded through the same routes as photos. Their size is
+// checked before any byte is accepted, their duration once the container
+// can be read: before storing form uploads, when confirming direct and
+// resumable ones. Videos whose container records no duration are only held
+// to the size limit.
+
+// videoSizeLimit is the largest video the server accepts at all.
+func videoSizeLimit() int64 {
+	return min(maxVideoFileSize, maxUploadFileSize)
+}
+
+// videoLimits returns the largest size and duration of the event's videos.
+func videoLimits(settings *database.EventMediaSettings) (int64, time.Duration) {
+	size, duration := videoSizeLimit(), maxVideoDuration
+	if settings.MaxVideoSize > 0 {
+		size = min(size, settings.MaxVideoSize)
+	}
+	if settings.MaxVideoDuration > 0 {
+		duration = min(duration, settings.MaxVideoDuration)
+	}
+	return size, duration
+}
+
+// checkVideoSize refuses videos larger than the event allows. Other files
+// pass.
+func checkVideoSize(fileName string, fileType string, size int64, settings *database.EventMediaSettings) (int, error) {
+	if database.MediaTypeOf(fileType) != database.MediaVideo {
+		return 0, nil
+	}
+	if limit, _ := videoLimits(settings); size > limit {
+		return 413, fmt.Errorf("%s is larger than the %d bytes videos of this event may be", fileName, limit)
+	}
+	return 0, nil
+}
+
+// probeVideo reads the container of a video and refuses it if it is longer
+// than the event allows, or can't be read.
+func probeVideo(fileName string, fileType string, r io.Reader, settings *database.EventMediaSettings) (*video.Info, int, error) {
+	info, err := video.Probe(r, fileType)
+	if errors.Is(err, video.ErrMalformed) || errors.Is(err, video.ErrUnsupported) {
+		return nil, 415, fmt.Errorf("%s is not a readable video", fileName)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	if _, limit := videoLimits(settings); info.Duration > limit {
+		return nil, 400, fmt.Errorf("%s is longer than the %g seconds videos of this event may be", fileName, limit.Seconds())
+	}
+	return info, 0, nil
+}
+
+// checkStoredVideo probes an uploaded object that is a video, see
+// probeVideo, and records its duration on the photo.
+func (s *FiberServer) checkStoredVideo(photo *database.Photo, settings *database.EventMediaSettings) (int, error) {
+	if database.MediaTypeOf(photo.FileType) != database.MediaVideo {
+		return 0, nil
+	}
+	src, err := s.storage.DownloadFile(photo.ID)
+	if err != nil {
+		return 500, err
+	}
+	defer src.Close()
+
+	info, status, err := probeVideo(photo.FileName, photo.FileType, src, settings)
+	if err != nil {
+		return status, err
+	}
+	photo.DurationMs = info.DurationMs()
+	return 0, nil
+}
+
+// probeFormVideo probes a file of a multipart form that is a video, see
+// probeVideo. It returns nil for other files.
+func probeFormVideo(file *multipart.FileHeader, fileName string, fileType string, settings *database.EventMediaSettings) (*video.Info, int, error) {
+	if database.MediaTypeOf(fileType) != database.MediaVideo {
+		return nil, 0, nil
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, 500, err
+	}
+	defer src.Close()
+	return probeVideo(fileName, fileType, src, settings)
+}
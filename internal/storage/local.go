@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local keeps files in a directory and has the API serve them:
+//
+//	objects/<key>          file contents
+//	meta/<key>             file type
+//	uploads/<id>/          multipart uploads, one file per part
+//	tmp/                   files being written, renamed into place when done
+type Local struct {
+	*signer
+	root string
+}
+
+type localUpload struct {
+	FileID   string `json:"file_id"`
+	FileType string `json:"file_type"`
+}
+
+func NewLocal(dir string) (*Local, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"objects", "meta", "uploads", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0o750); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{signer: newSigner(), root: root}, nil
+}
+
+func (l *Local) path(dir string, fileId string) string {
+	return filepath.Join(l.root, dir, filepath.FromSlash(fileId))
+}
+
+func (l *Local) UploadFile(body io.Reader, size int64, fileId string, fileType string) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.root, "tmp"), "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, body)
+	if err != nil {
+		return "", err
+	}
+	if size >= 0 && n != size {
+		return "", fmt.Errorf("read %d bytes, expected %d", n, size)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := l.commit(tmp.Name(), fileId, fileType); err != nil {
+		return "", err
+	}
+	return l.location(fileId), nil
+}
+
+// commit moves a complete file into place, its type first so a file is
+// never visible without one.
+func (l *Local) commit(tmpName string, fileId string, fileType string) error {
+	meta, object := l.path("meta", fileId), l.path("objects", fileId)
+	for _, path := range []string{meta, object} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(meta, []byte(fileType), 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, object)
+}
+
+func (l *Local) DownloadFile(fileId string) (io.ReadCloser, error) {
+	if err := checkKey(fileId); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(l.path("objects", fileId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) DeleteFiles(fileIds ...string) error {
+	for _, fileId := range fileIds {
+		if err := checkKey(fileId); err != nil {
+			return err
+		}
+		for _, dir := range []string{"objects", "meta"} {
+			if err := os.Remove(l.path(dir, fileId)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *Local) HeadFile(fileId string) (*FileInfo, error) {
+	if err := checkKey(fileId); err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(l.path("objects", fileId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fileType, err := os.ReadFile(l.path("meta", fileId))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &FileInfo{Size: stat.Size(), ContentType: string(fileType)}, nil
+}
+
+func (l *Local) PresignUpload(fileId string, fileType string, size int64, sha256 string, expires time.Duration) (*PresignedUpload, error) {
+	if err := checkKey(fileId); err != nil {
+		return nil, err
+	}
+	return l.presignUpload(fileId, fileType, size, sha256, expires), nil
+}
+
+func (l *Local) PresignDownload(fileId string, expires time.Duration) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	return l.sign("GET", fileId, expires, nil), nil
+}
+
+func (l *Local) ListFiles(prefix string, fn func(fileId string) error) error {
+	objects := filepath.Join(l.root, "objects")
+	return filepath.WalkDir(objects, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(objects, path)
+		if err != nil {
+			return err
+		}
+		fileId := filepath.ToSlash(rel)
+		if !strings.HasPrefix(fileId, prefix) {
+			return nil
+		}
+		return fn(fileId)
+	})
+}
+
+// MakePrivate does nothing, files are only ever served with a signature.
+func (l *Local) MakePrivate(fileId string) error {
+	return nil
+}
+
+func (l *Local) CreateMultipartUpload(fileId string, fileType string) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadId := hex.EncodeToString(id)
+
+	dir := filepath.Join(l.root, "uploads", uploadId)
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		return "", err
+	}
+	info, err := json.Marshal(localUpload{FileID: fileId, FileType: fileType})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), info, 0o640); err != nil {
+		return "", err
+	}
+	return uploadId, nil
+}
+
+// upload returns the directory of a multipart upload of fileId.
+func (l *Local) upload(fileId string, uploadId string) (string, *localUpload, error) {
+	if _, err := hex.DecodeString(uploadId); err != nil || uploadId == "" {
+		return "", nil, ErrNotFound
+	}
+	dir := filepath.Join(l.root, "uploads", uploadId)
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var upload localUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return "", nil, err
+	}
+	if upload.FileID != fileId {
+		return "", nil, ErrNotFound
+	}
+	return dir, &upload, nil
+}
+
+func partName(number int32) string {
+	return fmt.Sprintf("part-%05d", number)
+}
+
+func (l *Local) UploadPart(fileId string, uploadId string, partNumber int32, data []byte) (*Part, error) {
+	dir, _, err := l.upload(fileId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, partName(partNumber)), data, 0o640); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return &Part{Number: partNumber, ETag: hex.EncodeToString(sum[:])}, nil
+}
+
+func (l *Local) CompleteMultipartUpload(fileId string, uploadId string, parts []Part) (string, error) {
+	dir, upload, err := l.upload(fileId, uploadId)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.root, "tmp"), "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	for _, part := range parts {
+		data, err := os.ReadFile(filepath.Join(dir, partName(part.Number)))
+		if err != nil {
+			return "", fmt.Errorf("part %d: %w", part.Number, err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != part.ETag {
+			return "", fmt.Errorf("part %d: etag mismatch", part.Number)
+		}
+		if _, err := tmp.Write(data); err != nil {
+			return "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := l.commit(tmp.Name(), fileId, upload.FileType); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	return l.location(fileId), nil
+}
+
+func (l *Local) AbortMultipartUpload(fileId string, uploadId string) error {
+	dir, _, err := l.upload(fileId, uploadId)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps files in memory, for tests and throwaway setups. Like Local,
+// its files are served by the API.
+type Memory struct {
+	*signer
+	mu      sync.RWMutex
+	files   map[string]memoryFile
+	uploads map[string]*memoryUpload
+}
+
+type memoryFile struct {
+	data     []byte
+	fileType string
+}
+
+type memoryUpload struct {
+	fileId   string
+	fileType string
+	parts    map[int32][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		signer:  newSigner(),
+		files:   make(map[string]memoryFile),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (m *Memory) UploadFile(body io.Reader, size int64, fileId string, fileType string) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return "", fmt.Errorf("read %d bytes, expected %d", len(data), size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[fileId] = memoryFile{data: data, fileType: fileType}
+	return m.location(fileId), nil
+}
+
+func (m *Memory) DownloadFile(fileId string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	file, ok := m.files[fileId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Stored data is never modified, only replaced
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+func (m *Memory) DeleteFiles(fileIds ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, fileId := range fileIds {
+		delete(m.files, fileId)
+	}
+	return nil
+}
+
+func (m *Memory) HeadFile(fileId string) (*FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	file, ok := m.files[fileId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &FileInfo{Size: int64(len(file.data)), ContentType: file.fileType}, nil
+}
+
+func (m *Memory) PresignUpload(fileId string, fileType string, size int64, sha256 string, expires time.Duration) (*PresignedUpload, error) {
+	if err := checkKey(fileId); err != nil {
+		return nil, err
+	}
+	return m.presignUpload(fileId, fileType, size, sha256, expires), nil
+}
+
+func (m *Memory) PresignDownload(fileId string, expires time.Duration) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	return m.sign("GET", fileId, expires, nil), nil
+}
+
+func (m *Memory) ListFiles(prefix string, fn func(fileId string) error) error {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.files))
+	for fileId := range m.files {
+		if strings.HasPrefix(fileId, prefix) {
+			keys = append(keys, fileId)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.Sort(keys)
+	for _, fileId := range keys {
+		if err := fn(fileId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MakePrivate does nothing, files are only ever served with a signature.
+func (m *Memory) MakePrivate(fileId string) error {
+	return nil
+}
+
+func (m *Memory) CreateMultipartUpload(fileId string, fileType string) (string, error) {
+	if err := checkKey(fileId); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadId := hex.EncodeToString(id)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[uploadId] = &memoryUpload{fileId: fileId, fileType: fileType, parts: make(map[int32][]byte)}
+	return uploadId, nil
+}
+
+// upload must be called with the lock held.
+func (m *Memory) upload(fileId string, uploadId string) (*memoryUpload, error) {
+	upload, ok := m.uploads[uploadId]
+	if !ok || upload.fileId != fileId {
+		return nil, ErrNotFound
+	}
+	return upload, nil
+}
+
+func (m *Memory) UploadPart(fileId string, uploadId string, partNumber int32, data []byte) (*Part, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.upload(fileId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	upload.parts[partNumber] = bytes.Clone(data)
+	sum := sha256.Sum256(data)
+	return &Part{Number: partNumber, ETag: hex.EncodeToString(sum[:])}, nil
+}
+
+func (m *Memory) CompleteMultipartUpload(fileId string, uploadId string, parts []Part) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.upload(fileId, uploadId)
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	for _, part := range parts {
+		chunk, ok := upload.parts[part.Number]
+		if !ok {
+			return "", fmt.Errorf("part %d: %w", part.Number, ErrNotFound)
+		}
+		if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != part.ETag {
+			return "", fmt.Errorf("part %d: etag mismatch", part.Number)
+		}
+		data = append(data, chunk...)
+	}
+	m.files[fileId] = memoryFile{data: data, fileType: upload.fileType}
+	delete(m.uploads, uploadId)
+	return m.location(fileId), nil
+}
+
+func (m *Memory) AbortMultipartUpload(fileId string, uploadId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.upload(fileId, uploadId); err != nil {
+		return err
+	}
+	delete(m.uploads, uploadId)
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestMemoryFiles(t *testing.T) {
+	m := newTestMemory(t, "secret")
+	if _, err := m.UploadFile(bytes.NewReader([]byte("hello")), 6, "a/b", "text/plain"); err == nil {
+		t.Error("upload of the wrong size succeeded")
+	}
+	if _, err := m.UploadFile(bytes.NewReader([]byte("hello")), -1, "a/b", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := m.DownloadFile("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != "hello" {
+		t.Errorf("data = %q, want hello", data)
+	}
+	info, err := m.HeadFile("a/b")
+	if err != nil || info.Size != 5 || info.ContentType != "text/plain" {
+		t.Errorf("HeadFile = %+v, %v", info, err)
+	}
+
+	if err := m.DeleteFiles("a/b", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.DownloadFile("a/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryMultipartUpload(t *testing.T) {
+	m := newTestMemory(t, "secret")
+	uploadId, err := m.CreateMultipartUpload("a/b", "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Parts are put together in the order given, not uploaded
+	second, err := m.UploadPart("a/b", uploadId, 2, []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := m.UploadPart("a/b", uploadId, 1, []byte("hello "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.UploadPart("a/c", uploadId, 3, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("part of another file: err = %v, want ErrNotFound", err)
+	}
+
+	tampered := *second
+	tampered.ETag = first.ETag
+	if _, err := m.CompleteMultipartUpload("a/b", uploadId, []Part{*first, tampered}); err == nil {
+		t.Error("upload with a wrong ETag completed")
+	}
+	if _, err := m.CompleteMultipartUpload("a/b", uploadId, []Part{*first, *second}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := m.DownloadFile("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != "hello world" {
+		t.Errorf("data = %q, want hello world", data)
+	}
+	if err := m.AbortMultipartUpload("a/b", uploadId); !errors.Is(err, ErrNotFound) {
+		t.Errorf("abort of a completed upload: err = %v, want ErrNotFound", err)
+	}
+}
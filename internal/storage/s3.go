@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type s3Service struct {
+	client   *s3.Client
+	presign  *s3.PresignClient
+	uploader *manager.Uploader
+}
+
+var (
+	bucket      = os.Getenv("S3_BUCKET")
+	region      = os.Getenv("S3_REGION")
+	accessKeyId = os.Getenv("S3_ACCESS_KEY_ID")
+	accessKey   = os.Getenv("S3_SECRET_ACCESS_KEY")
+	session     = os.Getenv("S3_SESSION")
+	// Set for S3 compatible services like MinIO
+	endpoint = os.Getenv("S3_ENDPOINT")
+)
+
+func newS3() *s3Service {
+	staticProvider := credentials.NewStaticCredentialsProvider(
+		accessKeyId,
+		accessKey,
+		session,
+	)
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithRegion(region),
+		config.WithCredentialsProvider(staticProvider),
+	)
+	if err != nil {
+		log.Fatalf("load s3 config error %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			// MinIO serves buckets as paths, not subdomains
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Service{
+		client:   client,
+		presign:  s3.NewPresignClient(client),
+		uploader: manager.NewUploader(client),
+	}
+}
+
+// UploadFile streams body to the bucket in parts. Bodies implementing
+// io.ReaderAt and io.Seeker, like multipart files, are read part by part
+// without being copied in memory.
+func (s *s3Service) UploadFile(body io.Reader, size int64, fileId string, fileType string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(fileId),
+		Body:        body,
+		ContentType: aws.String(fileType),
+	}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	output, err := s.uploader.Upload(context.TODO(), input)
+	if err != nil {
+		return "", err
+	}
+	return output.Location, nil
+}
+
+func (s *s3Service) DownloadFile(fileId string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileId),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+func (s *s3Service) DeleteFiles(fileIds ...string) error {
+	// DeleteObjects accepts at most 1000 keys per request
+	for chunk := range slices.Chunk(fileIds, 1000) {
+		objects := make([]types.ObjectIdentifier, 0, len(chunk))
+		for _, id := range chunk {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(id)})
+		}
+		output, err := s.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return fmt.Errorf("delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return nil
+}
+
+func (s *s3Service) HeadFile(fileId string) (*FileInfo, error) {
+	output, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileId),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+	}, nil
+}
+
+func (s *s3Service) PresignUpload(fileId string, fileType string, size int64, sha256 string, expires time.Duration) (*PresignedUpload, error) {
+	checksum, err := hex.DecodeString(sha256)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.presign.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(fileId),
+		ContentType:    aws.String(fileType),
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(checksum)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	location.RawQuery = ""
+
+	// Host is set by the HTTP client from the URL
+	headers := req.SignedHeader.Clone()
+	headers.Del("Host")
+
+	return &PresignedUpload{
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(expires),
+		Location:  location.String(),
+	}, nil
+}
+
+func (s *s3Service) PresignDownload(fileId string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileId),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *s3Service) ListFiles(prefix string, fn func(fileId string) error) error {
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			if err := fn(aws.ToString(object.Key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MakePrivate resets the ACL of an object uploaded as public-read. Buckets
+// with ACLs disabled, and MinIO, have no public objects to begin with, so
+// that error is not one.
+func (s *s3Service) MakePrivate(fileId string) error {
+	_, err := s.client.PutObjectAcl(context.TODO(), &s3.PutObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileId),
+		ACL:    types.ObjectCannedACLPrivate,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessControlListNotSupported" {
+		return nil
+	}
+	return err
+}
+
+// CreateMultipartUpload starts an upload assembled from parts and returns
+// its upload ID.
+func (s *s3Service) CreateMultipartUpload(fileId string, fileType string) (string, error) {
+	output, err := s.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(fileId),
+		ContentType: aws.String(fileType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.UploadId), nil
+}
+
+func (s *s3Service) UploadPart(fileId string, uploadId string, partNumber int32, data []byte) (*Part, error) {
+	output, err := s.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(fileId),
+		UploadId:      aws.String(uploadId),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Part{Number: partNumber, ETag: aws.ToString(output.ETag)}, nil
+}
+
+// CompleteMultipartUpload assembles the parts into the object and returns
+// its location.
+func (s *s3Service) CompleteMultipartUpload(fileId string, uploadId string, parts []Part) (string, error) {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part.Number),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	output, err := s.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(fileId),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.Location), nil
+}
+
+func (s *s3Service) AbortMultipartUpload(fileId string, uploadId string) error {
+	_, err := s.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(fileId),
+		UploadId: aws.String(uploadId),
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesPath is where the API serves the files of backends implementing
+// Served.
+const FilesPath = "/api/v1/files/"
+
+// signer mints and checks presigned URLs pointing at the API, with an HMAC
+// over everything the URL allows.
+type signer struct {
+	secret  []byte
+	baseURL string
+}
+
+// newSigner reads the key from STORAGE_SECRET and the public URL of the API
+// from STORAGE_URL. Without a key URLs are signed with a random one, and stop
+// working when the process exits.
+func newSigner() *signer {
+	secret := []byte(os.Getenv("STORAGE_SECRET"))
+	if len(secret) == 0 {
+		log.Print("STORAGE_SECRET is not set, signed file URLs won't survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("generate storage secret: %v", err)
+		}
+	}
+	baseURL := os.Getenv("STORAGE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%s", os.Getenv("PORT"))
+	}
+	return &signer{secret: secret, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// location is the unsigned URL of a file.
+func (s *signer) location(fileId string) string {
+	segments := strings.Split(fileId, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return s.baseURL + FilesPath + strings.Join(segments, "/")
+}
+
+// sign returns the URL allowing method on a file until expires. Uploads
+// commit to the upload's type, size and checksum.
+func (s *signer) sign(method string, fileId string, expires time.Duration, upload *SignedUpload) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	if upload != nil {
+		query.Set("type", upload.FileType)
+		query.Set("size", strconv.FormatInt(upload.Size, 10))
+		query.Set("sha256", upload.SHA256)
+	}
+	query.Set("signature", s.mac(method, fileId, query))
+	return s.location(fileId) + "?" + query.Encode()
+}
+
+func (s *signer) VerifyURL(method string, fileId string, query url.Values) (*SignedUpload, error) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, ErrInvalidSignature
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(s.mac(method, fileId, query))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSignature
+	}
+
+	if !query.Has("size") {
+		return nil, nil
+	}
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	return &SignedUpload{
+		FileType: query.Get("type"),
+		Size:     size,
+		SHA256:   query.Get("sha256"),
+	}, nil
+}
+
+func (s *signer) mac(method string, fileId string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	for _, part := range []string{method, fileId, query.Get("expires"), query.Get("type"), query.Get("size"), query.Get("sha256")} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// presignUpload builds the PUT a client sends to the API.
+func (s *signer) presignUpload(fileId string, fileType string, size int64, sha256 string, expires time.Duration) *PresignedUpload {
+	return &PresignedUpload{
+		URL:       s.sign("PUT", fileId, expires, &SignedUpload{FileType: fileType, Size: size, SHA256: sha256}),
+		Method:    "PUT",
+		Headers:   http.Header{"Content-Type": {fileType}},
+		ExpiresAt: time.Now().Add(expires),
+		Location:  s.location(fileId),
+	}
+}
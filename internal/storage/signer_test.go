@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMemory(t *testing.T, secret string) *Memory {
+	t.Setenv("STORAGE_SECRET", secret)
+	t.Setenv("STORAGE_URL", "https://api.example.com/")
+	return NewMemory()
+}
+
+// splitURL returns the file key and query of a presigned URL, as the API
+// reads them.
+func splitURL(t *testing.T, rawURL string) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "api.example.com" || !strings.HasPrefix(u.EscapedPath(), FilesPath) {
+		t.Fatalf("URL %s does not point at the API", rawURL)
+	}
+	fileId, err := url.PathUnescape(strings.TrimPrefix(u.EscapedPath(), FilesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileId, u.Query()
+}
+
+func TestVerifyURL(t *testing.T) {
+	m := newTestMemory(t, "secret")
+	const fileId = "events/abc/photo 1#.jpg"
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	download, err := m.PresignDownload(fileId, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := m.PresignUpload(fileId, "image/jpeg", 1234, sum, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := m.PresignDownload(fileId, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newTestMemory(t, "other secret").PresignDownload(fileId, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(rawURL string, key string, value string) string {
+		u, _ := url.Parse(rawURL)
+		query := u.Query()
+		if value == "" {
+			query.Del(key)
+		} else {
+			query.Set(key, value)
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		fileId string
+		want   *SignedUpload
+		err    error
+	}{
+		{name: "download", method: "GET", url: download},
+		{name: "upload", method: "PUT", url: upload.URL, want: &SignedUpload{FileType: "image/jpeg", Size: 1234, SHA256: sum}},
+		{name: "download as upload", method: "PUT", url: download, err: ErrInvalidSignature},
+		{name: "upload as download", method: "GET", url: upload.URL, err: ErrInvalidSignature},
+		{name: "other file", method: "GET", url: download, fileId: "events/abc/photo 2.jpg", err: ErrInvalidSignature},
+		{name: "expired", method: "GET", url: expired, err: ErrInvalidSignature},
+		{name: "extended", method: "GET", url: with(download, "expires", later), err: ErrInvalidSignature},
+		{name: "other secret", method: "GET", url: other, err: ErrInvalidSignature},
+		{name: "no signature", method: "GET", url: with(download, "signature", ""), err: ErrInvalidSignature},
+		{name: "signature not hex", method: "GET", url: with(download, "signature", "zz"), err: ErrInvalidSignature},
+		{name: "no expiry", method: "GET", url: with(download, "expires", ""), err: ErrInvalidSignature},
+		{name: "other size", method: "PUT", url: with(upload.URL, "size", "1235"), err: ErrInvalidSignature},
+		{name: "no size", method: "PUT", url: with(upload.URL, "size", ""), err: ErrInvalidSignature},
+		{name: "other type", method: "PUT", url: with(upload.URL, "type", "image/png"), err: ErrInvalidSignature},
+		{name: "other checksum", method: "PUT", url: with(upload.URL, "sha256", strings.Repeat("0", 64)), err: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, query := splitURL(t, tt.url)
+			if id != fileId {
+				t.Fatalf("file key = %q, want %q", id, fileId)
+			}
+			if tt.fileId != "" {
+				id = tt.fileId
+			}
+			got, err := m.VerifyURL(tt.method, id, query)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("upload = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresignUploadLocation(t *testing.T) {
+	m := newTestMemory(t, "secret")
+	upload, err := m.PresignUpload("events/abc/photo.jpg", "image/jpeg", 1, "00", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://api.example.com/api/v1/files/events/abc/photo.jpg"; upload.Location != want {
+		t.Errorf("location = %q, want %q", upload.Location, want)
+	}
+	if got := upload.Headers.Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", got)
+	}
+	for _, fileId := range []string{"", "a//b", "../a", "a/./b", `a\b`} {
+		if _, err := m.PresignUpload(fileId, "image/jpeg", 1, "00", time.Hour); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("PresignUpload(%q) err = %v, want ErrInvalidKey", fileId, err)
+		}
+	}
+}
@@ -1,81 +1,128 @@
 package storage
 
 import (
-	"bytes"
-	"context"
+	"errors"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"strings"
+	"time"
 )
 
+// Service stores files by key, independently of the backend behind it. Keys
+// are slash separated paths, like object keys.
 type Service interface {
-	GetClient() *s3.Client
-	GetUploader() *manager.Uploader
-	UploadFile(fileData []byte, fileId string, fileType string) (*manager.UploadOutput, error)
+	// UploadFile stores body under fileId and returns its unsigned location;
+	// size is the exact length of body, or -1 when it is not known up front.
+	UploadFile(body io.Reader, size int64, fileId string, fileType string) (string, error)
+	DownloadFile(fileId string) (io.ReadCloser, error)
+	DeleteFiles(fileIds ...string) error
+	HeadFile(fileId string) (*FileInfo, error)
+	// PresignUpload signs a PUT of exactly size bytes whose hex SHA-256 is
+	// sha256; bodies with another checksum are refused.
+	PresignUpload(fileId string, fileType string, size int64, sha256 string, expires time.Duration) (*PresignedUpload, error)
+	PresignDownload(fileId string, expires time.Duration) (string, error)
+	// ListFiles calls fn with the key of every file starting with prefix,
+	// stopping at the first error.
+	ListFiles(prefix string, fn func(fileId string) error) error
+	// MakePrivate revokes public access to a file stored before files were
+	// private, where the backend has such a notion.
+	MakePrivate(fileId string) error
+	CreateMultipartUpload(fileId string, fileType string) (string, error)
+	UploadPart(fileId string, uploadId string, partNumber int32, data []byte) (*Part, error)
+	CompleteMultipartUpload(fileId string, uploadId string, parts []Part) (string, error)
+	AbortMultipartUpload(fileId string, uploadId string) error
+}
+
+// Served is implemented by backends whose files are read and written
+// through the API, see server.ServeFile. Their presigned URLs point there.
+type Served interface {
+	// VerifyURL checks the signature of a presigned URL for method and
+	// returns the upload it allows, nil for downloads.
+	VerifyURL(method string, fileId string, query url.Values) (*SignedUpload, error)
+}
+
+// SignedUpload is what a presigned upload URL commits the body to.
+type SignedUpload struct {
+	FileType string
+	Size     int64
+	SHA256   string
+}
+
+// Every part of a multipart upload but the last has to be at least this big.
+const MinPartSize = 5 << 20
+
+type Part struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+type FileInfo struct {
+	Size        int64
+	ContentType string
 }
 
-type service struct {
-	client   *s3.Client
-	uploader *manager.Uploader
+// PresignedUpload is a PUT request the client sends straight to the backend.
+// Headers have to be sent as is, they are part of the signature.
+type PresignedUpload struct {
+	URL       string      `json:"url"`
+	Method    string      `json:"method"`
+	Headers   http.Header `json:"headers"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	// Location is the URL of the file once uploaded
+	Location string `json:"-"`
 }
 
 var (
-	bucket          = os.Getenv("S3_BUCKET")
-	region          = os.Getenv("S3_REGION")
-	accessKeyId     = os.Getenv("S3_ACCESS_KEY_ID")
-	accessKey       = os.Getenv("S3_SECRET_ACCESS_KEY")
-	session         = os.Getenv("S3_SESSION")
-	storageInstance *service
+	ErrNotFound         = errors.New("file not found")
+	ErrInvalidKey       = errors.New("invalid file key")
+	ErrInvalidSignature = errors.New("invalid or expired signature")
 )
 
+var storageInstance Service
+
+// New returns the backend picked by STORAGE_DRIVER: `s3` (default, also
+// MinIO through S3_ENDPOINT), `local` for a directory served by the API, or
+// `memory`, which forgets everything on exit.
 func New() Service {
 	// Reuse Connection
 	if storageInstance != nil {
 		return storageInstance
 	}
 
-	staticProvider := credentials.NewStaticCredentialsProvider(
-		accessKeyId,
-		accessKey,
-		session,
-	)
-	cfg, err := config.LoadDefaultConfig(
-		context.TODO(),
-		config.WithRegion(region),
-		config.WithCredentialsProvider(staticProvider),
-	)
-	if err != nil {
-		log.Fatalf("load s3 config error %v", err)
-	}
-
-	client := s3.NewFromConfig(cfg)
-	storageInstance = &service{
-		client:   client,
-		uploader: manager.NewUploader(client),
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "s3":
+		storageInstance = newS3()
+	case "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "storage"
+		}
+		local, err := NewLocal(dir)
+		if err != nil {
+			log.Fatalf("local storage error %v", err)
+		}
+		storageInstance = local
+	case "memory":
+		storageInstance = NewMemory()
+	default:
+		log.Fatalf("unknown STORAGE_DRIVER %q", driver)
 	}
-
 	return storageInstance
 }
 
-func (s *service) GetClient() *s3.Client {
-	return s.client
-}
-
-func (s *service) GetUploader() *manager.Uploader {
-	return s.uploader
-}
-
-func (s *service) UploadFile(fileData []byte, fileId string, fileType string) (*manager.UploadOutput, error) {
-	return s.uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(fileId),
-		Body:        bytes.NewReader(fileData),
-		ContentType: aws.String(fileType),
-		ACL:         "public-read",
-	})
+// checkKey refuses keys that are not plain relative paths, which a
+// filesystem would resolve outside of its root.
+func checkKey(fileId string) error {
+	if fileId == "" || strings.Contains(fileId, "\\") {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(fileId, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
 }
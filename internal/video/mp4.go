@@ -0,0 +1,349 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// ISO base media files (MP4, QuickTime) are a tree of boxes: a 32-bit size,
+// a 4 character type, then the body. The metadata is all in the `moov` box,
+// which encoders put before or after the `mdat` box of the samples.
+
+// Start of the times of mvhd, seconds since 1904-01-01 UTC
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func probeMP4(r io.Reader) (*Info, error) {
+	var header [16]byte
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, ErrMalformed
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The box extends to the end of the file
+			if boxType != "moov" {
+				return nil, ErrMalformed
+			}
+			size = -1
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, ErrMalformed
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size >= 0 && size < headerSize {
+			return nil, ErrMalformed
+		}
+
+		if boxType != "moov" {
+			if _, err := io.CopyN(io.Discard, r, size-headerSize); err != nil {
+				return nil, ErrMalformed
+			}
+			continue
+		}
+
+		var body []byte
+		var err error
+		if size < 0 {
+			body, err = io.ReadAll(io.LimitReader(r, maxElementSize))
+		} else if size-headerSize > maxElementSize {
+			return nil, ErrMalformed
+		} else {
+			body = make([]byte, size-headerSize)
+			_, err = io.ReadFull(r, body)
+		}
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return parseMoov(body)
+	}
+}
+
+// children calls fn with the type and body of every box in b.
+func children(b []byte, fn func(boxType string, body []byte) error) error {
+	return boxes(b, func(header []byte, body []byte) error {
+		return fn(string(header[4:8]), body)
+	})
+}
+
+// boxes calls fn with the header and body of every box in b.
+func boxes(b []byte, fn func(header []byte, body []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[:4]))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return ErrMalformed
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return ErrMalformed
+		}
+		if err := fn(b[:headerSize], b[headerSize:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// XMP is also kept in a top level uuid box of this UUID
+var xmpUUID = []byte("\xBE\x7A\xCF\xCB\x97\xA9\x42\xE8\x9C\x71\x99\x94\x91\xE3\xAF\xAC")
+
+// stripMP4 blanks out the udta and meta boxes of the movie and its tracks,
+// and top level metadata boxes: they become zeroed free boxes of the same
+// size, so the sample offsets stay valid.
+func stripMP4(w io.Writer, r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	changed := false
+	for {
+		header := make([]byte, 8, 16)
+		if _, err := io.ReadFull(br, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return changed, nil
+			}
+			return false, ErrMalformed
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		switch size {
+		case 0:
+			// The box extends to the end of the file
+			size = -1
+		case 1:
+			header = header[:16]
+			if _, err := io.ReadFull(br, header[8:16]); err != nil {
+				return false, ErrMalformed
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size >= 0 && size < int64(len(header)) {
+			return false, ErrMalformed
+		}
+		bodySize := size - int64(len(header))
+		if size < 0 {
+			bodySize = -1
+		}
+
+		blank := boxType == "udta" || boxType == "meta"
+		if boxType == "uuid" {
+			id, _ := br.Peek(len(xmpUUID))
+			blank = bytes.Equal(id, xmpUUID)
+		}
+		switch {
+		case blank:
+			changed = true
+			copy(header[4:8], "free")
+			if _, err := w.Write(header); err != nil {
+				return false, err
+			}
+			if err := blankBody(w, br, bodySize); err != nil {
+				return false, err
+			}
+		case boxType == "moov":
+			body, err := readBox(br, bodySize)
+			if err != nil {
+				return false, err
+			}
+			blanked, err := blankUserData(body)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || blanked
+			if _, err := w.Write(header); err != nil {
+				return false, err
+			}
+			if _, err := w.Write(body); err != nil {
+				return false, err
+			}
+		default:
+			if _, err := w.Write(header); err != nil {
+				return false, err
+			}
+			if bodySize < 0 {
+				_, err := io.Copy(w, br)
+				return changed, err
+			}
+			if _, err := io.CopyN(w, br, bodySize); err != nil {
+				return false, ErrMalformed
+			}
+		}
+	}
+}
+
+// readBox reads a box body of the size, to the end of r when it is -1.
+func readBox(r io.Reader, size int64) ([]byte, error) {
+	if size > maxElementSize {
+		return nil, ErrMalformed
+	}
+	if size < 0 {
+		body, err := io.ReadAll(io.LimitReader(r, maxElementSize+1))
+		if err != nil || len(body) > maxElementSize {
+			return nil, ErrMalformed
+		}
+		return body, nil
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrMalformed
+	}
+	return body, nil
+}
+
+// blankBody writes as many zeros as the box body of the size has bytes,
+// to the end of r when it is -1, and skips the body.
+func blankBody(w io.Writer, r io.Reader, size int64) error {
+	if size < 0 {
+		n, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return err
+		}
+		size = n
+	} else if _, err := io.CopyN(io.Discard, r, size); err != nil {
+		return ErrMalformed
+	}
+	_, err := io.CopyN(w, zeros{}, size)
+	return err
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// blankUserData turns the udta and meta boxes of a moov body and of its
+// tracks into zeroed free boxes, in place.
+func blankUserData(moov []byte) (bool, error) {
+	changed := false
+	var blank func(header []byte, body []byte) error
+	blank = func(header []byte, body []byte) error {
+		switch string(header[4:8]) {
+		case "udta", "meta":
+			changed = true
+			copy(header[4:8], "free")
+			clear(body)
+		case "trak":
+			return boxes(body, blank)
+		}
+		return nil
+	}
+	err := boxes(moov, blank)
+	return changed, err
+}
+
+func parseMoov(moov []byte) (*Info, error) {
+	info := &Info{}
+	var found bool
+	err := children(moov, func(boxType string, body []byte) error {
+		switch boxType {
+		case "mvhd":
+			found = true
+			return parseMvhd(body, info)
+		case "trak":
+			if info.Width == 0 {
+				return parseTrak(body, info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrMalformed
+	}
+	return info, nil
+}
+
+// parseMvhd reads the creation time and duration of the movie.
+func parseMvhd(b []byte, info *Info) error {
+	if len(b) < 4 {
+		return ErrMalformed
+	}
+	var created, timescale, duration uint64
+	switch b[0] {
+	case 0:
+		if len(b) < 20 {
+			return ErrMalformed
+		}
+		created = uint64(binary.BigEndian.Uint32(b[4:8]))
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+		// All ones: unknown, as in fragmented files
+		if duration == 0xFFFFFFFF {
+			duration = 0
+		}
+	case 1:
+		if len(b) < 32 {
+			return ErrMalformed
+		}
+		created = binary.BigEndian.Uint64(b[4:12])
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+		if duration == 0xFFFFFFFFFFFFFFFF {
+			duration = 0
+		}
+	default:
+		return ErrMalformed
+	}
+
+	if timescale > 0 && duration > 0 {
+		seconds := duration / timescale
+		if seconds > uint64(time.Duration(1<<63-1)/time.Second) {
+			return ErrMalformed
+		}
+		info.Duration = time.Duration(seconds)*time.Second +
+			time.Duration(duration%timescale)*time.Second/time.Duration(timescale)
+	}
+	if created > 0 {
+		info.CreatedAt = plausible(mp4Epoch.Add(time.Duration(created) * time.Second))
+	}
+	return nil
+}
+
+// parseTrak reads the dimensions of a video track; audio tracks have none.
+func parseTrak(b []byte, info *Info) error {
+	return children(b, func(boxType string, body []byte) error {
+		if boxType != "tkhd" || len(body) < 4 {
+			return nil
+		}
+		// The matrix then the 16.16 fixed point width and height end the box
+		offset := 40
+		if body[0] == 1 {
+			offset = 52
+		}
+		if len(body) < offset+44 {
+			return ErrMalformed
+		}
+		matrix := body[offset : offset+36]
+		width := int(binary.BigEndian.Uint32(body[offset+36:]) >> 16)
+		height := int(binary.BigEndian.Uint32(body[offset+40:]) >> 16)
+		if width == 0 || height == 0 {
+			return nil
+		}
+		// Turned a quarter: the scale factors a and d of the matrix are zero
+		if binary.BigEndian.Uint32(matrix[0:4]) == 0 && binary.BigEndian.Uint32(matrix[16:20]) == 0 {
+			width, height = height, width
+		}
+		info.Width, info.Height = width, height
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoFFmpeg is returned by Poster on hosts without ffmpeg. Videos are
+// kept without a poster frame there.
+var ErrNoFFmpeg = errors.New("ffmpeg is not available")
+
+const (
+	// How far into the video the poster frame is taken, at most; the first
+	// frames are often black
+	posterOffset  = time.Second
+	posterTimeout = time.Minute
+)
+
+// ffmpegPath is FFMPEG_PATH, or ffmpeg found in PATH.
+var ffmpegPath = sync.OnceValues(func() (string, error) {
+	if path := os.Getenv("FFMPEG_PATH"); path != "" {
+		return path, nil
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", ErrNoFFmpeg
+	}
+	return path, nil
+})
+
+// Poster extracts a frame of the video file at path with ffmpeg, upright,
+// taken a second in or halfway through shorter videos.
+func Poster(ctx context.Context, path string, duration time.Duration) (image.Image, error) {
+	ffmpeg, err := ffmpegPath()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, posterTimeout)
+	defer cancel()
+
+	offset := min(posterOffset, duration/2)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-nostdin", "-v", "error",
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe", "-c:v", "png",
+		"-",
+	)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("ffmpeg: no frame")
+	}
+	return png.Decode(&stdout)
+}
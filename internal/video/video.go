@@ -0,0 +1,75 @@
+package video
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Info is what Probe reads from the container of a video. Zero fields are
+// unknown: some recorders, browsers among them, write no duration.
+type Info struct {
+	Duration time.Duration
+	// Upright dimensions, the rotation of the track is applied
+	Width  int
+	Height int
+	// When the recording was made, per the container
+	CreatedAt *time.Time
+}
+
+var (
+	ErrUnsupported = errors.New("unsupported video container")
+	ErrMalformed   = errors.New("malformed video container")
+)
+
+// Largest metadata element read into memory. Sample data is skipped
+// instead, it is never read.
+const maxElementSize = 64 << 20
+
+// Probe reads the duration and dimensions of a video of the type (MP4,
+// QuickTime or WebM) from its container, without decoding it. It reads r
+// sequentially and stops once it has found them; files whose metadata comes
+// after the samples are read through.
+func Probe(r io.Reader, fileType string) (*Info, error) {
+	switch fileType {
+	case "video/mp4", "video/quicktime":
+		return probeMP4(r)
+	case "video/webm":
+		return probeWebM(r)
+	}
+	return nil, ErrUnsupported
+}
+
+// StripMetadata copies a video of the type from r to w without the metadata
+// that may hold where it was recorded: the user data and metadata boxes of
+// MP4 and QuickTime files, which hold the position, and XMP; the tags of
+// WebM files. They are blanked out rather than removed, nothing else moves.
+// It reports whether anything was blanked out.
+func StripMetadata(w io.Writer, r io.Reader, fileType string) (bool, error) {
+	switch fileType {
+	case "video/mp4", "video/quicktime":
+		return stripMP4(w, r)
+	case "video/webm":
+		return stripWebM(w, r)
+	}
+	return false, ErrUnsupported
+}
+
+// DurationMs returns the duration in milliseconds, nil when it is unknown.
+func (i *Info) DurationMs() *int {
+	if i.Duration <= 0 {
+		return nil
+	}
+	ms := int(i.Duration.Milliseconds())
+	return &ms
+}
+
+// plausible drops the creation times recorders write when their clock is
+// not set, usually the epoch of the container.
+func plausible(t time.Time) *time.Time {
+	if t.Year() < 2000 || t.After(time.Now().Add(24*time.Hour)) {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
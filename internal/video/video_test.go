@@ -0,0 +1,203 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func box(boxType string, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(len(b)+8)), boxType...), b...)
+}
+
+// mvhd builds a version 0 movie header of the creation time and duration.
+func mvhd(created time.Time, timescale uint32, duration uint32) []byte {
+	b := make([]byte, 100)
+	binary.BigEndian.PutUint32(b[4:], uint32(created.Sub(mp4Epoch)/time.Second))
+	binary.BigEndian.PutUint32(b[12:], timescale)
+	binary.BigEndian.PutUint32(b[16:], duration)
+	return box("mvhd", b)
+}
+
+// tkhd builds a version 0 track header of the dimensions, turned a quarter
+// when rotated.
+func tkhd(width uint32, height uint32, rotated bool) []byte {
+	b := make([]byte, 84)
+	matrix := b[40:76]
+	if rotated {
+		binary.BigEndian.PutUint32(matrix[4:], 0x00010000)
+		binary.BigEndian.PutUint32(matrix[12:], 0xFFFF0000)
+	} else {
+		binary.BigEndian.PutUint32(matrix[0:], 0x00010000)
+		binary.BigEndian.PutUint32(matrix[16:], 0x00010000)
+	}
+	binary.BigEndian.PutUint32(b[76:], width<<16)
+	binary.BigEndian.PutUint32(b[80:], height<<16)
+	return box("tkhd", b)
+}
+
+var recorded = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+// testMP4 builds a movie with its location in the user data and metadata
+// boxes, its moov box before or after the samples.
+func testMP4(moovFirst bool) []byte {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	udta := box("udta", box("\xA9xyz", []byte("\x00\x12\x15\xC7+48.8584+002.2945/")))
+	meta := box("meta", []byte("com.apple.quicktime.location.ISO6709+48.8584+002.2945/"))
+	trak := box("trak", tkhd(1920, 1080, true), box("udta", []byte("track +48.8584")))
+	moov := box("moov", mvhd(recorded, 600, 9000), trak, udta, meta)
+	mdat := box("mdat", []byte("samples"))
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func element(id uint32, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(out) > 0 {
+			out = append(out, c)
+		}
+	}
+	// 8 byte size
+	out = binary.BigEndian.AppendUint64(out, uint64(len(b))|1<<56)
+	return append(out, b...)
+}
+
+func uintElement(id uint32, value uint64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, value))
+}
+
+// testWebM builds a live recording: its Segment and Cluster are of unknown
+// size, tags with a location follow the samples.
+func testWebM() []byte {
+	header := element(0x1A45DFA3, element(0x4282, []byte("webm")))
+	info := element(infoID,
+		uintElement(timecodeScaleID, 1_000_000),
+		element(durationID, binary.BigEndian.AppendUint64(nil, math.Float64bits(2500))),
+		element(dateUTCID, binary.BigEndian.AppendUint64(nil, uint64(recorded.Sub(matroskaEpoch)))),
+	)
+	tracks := element(tracksID, element(trackEntryID,
+		uintElement(trackTypeID, 1),
+		element(videoID, uintElement(pixelWidthID, 640), uintElement(pixelHeightID, 480)),
+	))
+	cluster := append([]byte("\x1F\x43\xB6\x75\x01\xFF\xFF\xFF\xFF\xFF\xFF\xFF"), element(0xA3, []byte("frame"))...)
+	tags := element(tagsID, element(0x7373, element(0x67C8,
+		element(0x45A3, []byte("RECORDING_LOCATION")),
+		element(0x4487, []byte("+48.8584+002.2945/")),
+	)))
+	segment := append([]byte("\x18\x53\x80\x67\x01\xFF\xFF\xFF\xFF\xFF\xFF\xFF"), bytes.Join([][]byte{info, tracks, cluster, tags}, nil)...)
+	return append(header, segment...)
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []byte
+		fileType string
+		want     Info
+		err      error
+	}{
+		{
+			name:     "mp4",
+			file:     testMP4(true),
+			fileType: "video/mp4",
+			want:     Info{Duration: 15 * time.Second, Width: 1080, Height: 1920, CreatedAt: &recorded},
+		},
+		{
+			name:     "mp4 with moov last",
+			file:     testMP4(false),
+			fileType: "video/quicktime",
+			want:     Info{Duration: 15 * time.Second, Width: 1080, Height: 1920, CreatedAt: &recorded},
+		},
+		{
+			name:     "webm",
+			file:     testWebM(),
+			fileType: "video/webm",
+			want:     Info{Duration: 2500 * time.Millisecond, Width: 640, Height: 480, CreatedAt: &recorded},
+		},
+		{name: "truncated mp4", file: testMP4(false)[:60], fileType: "video/mp4", err: ErrMalformed},
+		{name: "mp4 without mvhd", file: box("moov"), fileType: "video/mp4", err: ErrMalformed},
+		{name: "webm without info", file: element(segmentID, element(tracksID)), fileType: "video/webm", err: ErrMalformed},
+		{name: "unsupported", file: []byte("RIFF"), fileType: "video/x-msvideo", err: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.file), tt.fileType)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Duration != tt.want.Duration || info.Width != tt.want.Width || info.Height != tt.want.Height {
+				t.Errorf("info = %+v, want %+v", info, tt.want)
+			}
+			if info.CreatedAt == nil || !info.CreatedAt.Equal(*tt.want.CreatedAt) {
+				t.Errorf("created at = %v, want %v", info.CreatedAt, tt.want.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestDurationMs(t *testing.T) {
+	if ms := (&Info{}).DurationMs(); ms != nil {
+		t.Errorf("unknown duration = %d, want nil", *ms)
+	}
+	if ms := (&Info{Duration: 1500 * time.Millisecond}).DurationMs(); ms == nil || *ms != 1500 {
+		t.Errorf("duration = %v, want 1500", ms)
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []byte
+		fileType string
+	}{
+		{name: "mp4", file: testMP4(true), fileType: "video/mp4"},
+		{name: "mp4 with moov last", file: testMP4(false), fileType: "video/quicktime"},
+		{name: "webm", file: testWebM(), fileType: "video/webm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			changed, err := StripMetadata(&out, bytes.NewReader(tt.file), tt.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Error("changed = false")
+			}
+			if strings.Contains(out.String(), "48.8584") {
+				t.Error("the location is still there")
+			}
+			// Nothing moves, the samples stay where the index says
+			if out.Len() != len(tt.file) {
+				t.Errorf("size = %d, want %d", out.Len(), len(tt.file))
+			}
+
+			before, err := Probe(bytes.NewReader(tt.file), tt.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := Probe(bytes.NewReader(out.Bytes()), tt.fileType)
+			if err != nil {
+				t.Fatalf("probe stripped: %v", err)
+			}
+			if after.Duration != before.Duration || after.Width != before.Width || after.Height != before.Height {
+				t.Errorf("stripped = %+v, want %+v", after, before)
+			}
+		})
+	}
+}
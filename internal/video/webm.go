@@ -0,0 +1,325 @@
+package video
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// WebM is Matroska, a tree of EBML elements: a variable length ID, a
+// variable length size, then the body. The Info and Tracks elements of the
+// Segment come before its Clusters of samples.
+
+const (
+	segmentID       = 0x18538067
+	infoID          = 0x1549A966
+	tracksID        = 0x1654AE6B
+	clusterID       = 0x1F43B675
+	timecodeScaleID = 0x2AD7B1
+	durationID      = 0x4489
+	dateUTCID       = 0x4461
+	trackEntryID    = 0xAE
+	trackTypeID     = 0x83
+	videoID         = 0xE0
+	pixelWidthID    = 0xB0
+	pixelHeightID   = 0xBA
+	tagsID          = 0x1254C367
+	voidID          = 0xEC
+)
+
+// Size of elements whose end is not known up front, live recordings
+const unknownSize = -1
+
+// Start of DateUTC, nanoseconds since 2001-01-01 UTC
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func probeWebM(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	info := &Info{}
+	var haveInfo, haveTracks bool
+	for !haveInfo || !haveTracks {
+		id, size, err := readElementHeader(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case segmentID:
+			// Descend, its children follow
+			continue
+		case clusterID:
+			return finishWebM(info, haveInfo)
+		case infoID, tracksID:
+			body, err := readBody(br, size)
+			if err != nil {
+				return nil, err
+			}
+			if id == infoID {
+				haveInfo = true
+				err = parseSegmentInfo(body, info)
+			} else {
+				haveTracks = true
+				err = parseTracks(body, info)
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if size == unknownSize {
+				return nil, ErrMalformed
+			}
+			if _, err := io.CopyN(io.Discard, br, size); err != nil {
+				return nil, ErrMalformed
+			}
+		}
+	}
+	return finishWebM(info, haveInfo)
+}
+
+func finishWebM(info *Info, haveInfo bool) (*Info, error) {
+	if !haveInfo {
+		return nil, ErrMalformed
+	}
+	return info, nil
+}
+
+// stripWebM blanks out the Tags elements of the segment, where a recording
+// location goes: they become Void elements of the same size. Segments and
+// Clusters of unknown size are descended into, everything else is copied.
+func stripWebM(w io.Writer, r io.Reader) (bool, error) {
+	rec := &recorder{r: bufio.NewReader(r)}
+	changed := false
+	for {
+		rec.buf = rec.buf[:0]
+		id, size, err := readElementHeader(rec)
+		if errors.Is(err, io.EOF) && len(rec.buf) == 0 {
+			return changed, nil
+		}
+		if err != nil {
+			return false, ErrMalformed
+		}
+		header := rec.buf
+
+		switch {
+		case id == segmentID, id == clusterID && size == unknownSize:
+			if _, err := w.Write(header); err != nil {
+				return false, err
+			}
+		case size == unknownSize:
+			return false, ErrMalformed
+		case id == tagsID:
+			changed = true
+			// Void has a shorter ID, its size takes up the difference
+			sizeLen := min(8, len(header)-1)
+			extra := int64(len(header) - 1 - sizeLen)
+			void := []byte{voidID}
+			for i := sizeLen - 1; i >= 0; i-- {
+				void = append(void, byte((uint64(size+extra)|1<<(7*sizeLen))>>(8*i)))
+			}
+			if _, err := w.Write(void); err != nil {
+				return false, err
+			}
+			if _, err := io.CopyN(w, zeros{}, extra); err != nil {
+				return false, err
+			}
+			if err := blankBody(w, rec.r, size); err != nil {
+				return false, err
+			}
+		default:
+			if _, err := w.Write(header); err != nil {
+				return false, err
+			}
+			if _, err := io.CopyN(w, rec.r, size); err != nil {
+				return false, ErrMalformed
+			}
+		}
+	}
+}
+
+// recorder keeps the bytes read through it, to write headers back as they
+// were.
+type recorder struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (r *recorder) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
+
+// readVint reads an EBML variable length integer. IDs keep their length
+// marker, sizes don't; a size of all ones is unknown.
+func readVint(r io.ByteReader, keepMarker bool) (int64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		length++
+		if length > 8 {
+			return 0, ErrMalformed
+		}
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	allOnes := value == uint64(0xFF>>length)
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, ErrMalformed
+		}
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, nil
+	}
+	if value > math.MaxInt64 {
+		return 0, ErrMalformed
+	}
+	return int64(value), nil
+}
+
+func readElementHeader(r io.ByteReader) (int64, int64, error) {
+	id, err := readVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := readVint(r, false)
+	if err != nil {
+		return 0, 0, ErrMalformed
+	}
+	return id, size, nil
+}
+
+func readBody(r io.Reader, size int64) ([]byte, error) {
+	if size == unknownSize || size > maxElementSize {
+		return nil, ErrMalformed
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrMalformed
+	}
+	return body, nil
+}
+
+// elements calls fn with the ID and body of every element in b.
+func elements(b []byte, fn func(id int64, body []byte) error) error {
+	r := &byteReader{b: b}
+	for r.pos < len(b) {
+		id, size, err := readElementHeader(r)
+		if err != nil {
+			return ErrMalformed
+		}
+		if size == unknownSize || size > int64(len(b)-r.pos) {
+			return ErrMalformed
+		}
+		if err := fn(id, b[r.pos:r.pos+int(size)]); err != nil {
+			return err
+		}
+		r.pos += int(size)
+	}
+	return nil
+}
+
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, io.EOF
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func parseSegmentInfo(b []byte, info *Info) error {
+	timecodeScale := uint64(1_000_000)
+	var duration float64
+	err := elements(b, func(id int64, body []byte) error {
+		switch id {
+		case timecodeScaleID:
+			timecodeScale = readUint(body)
+		case durationID:
+			switch len(body) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(body))
+			default:
+				return ErrMalformed
+			}
+		case dateUTCID:
+			if len(body) == 8 {
+				date := int64(binary.BigEndian.Uint64(body))
+				info.CreatedAt = plausible(matroskaEpoch.Add(time.Duration(date)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ns := duration * float64(timecodeScale); ns > 0 && ns < math.MaxInt64 {
+		info.Duration = time.Duration(ns)
+	}
+	return nil
+}
+
+// parseTracks reads the dimensions of the first video track.
+func parseTracks(b []byte, info *Info) error {
+	return elements(b, func(id int64, entry []byte) error {
+		if id != trackEntryID || info.Width > 0 {
+			return nil
+		}
+		var isVideo bool
+		var width, height uint64
+		err := elements(entry, func(id int64, body []byte) error {
+			switch id {
+			case trackTypeID:
+				isVideo = readUint(body) == 1
+			case videoID:
+				return elements(body, func(id int64, body []byte) error {
+					switch id {
+					case pixelWidthID:
+						width = readUint(body)
+					case pixelHeightID:
+						height = readUint(body)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if isVideo && width > 0 && height > 0 && width <= math.MaxInt32 && height <= math.MaxInt32 {
+			info.Width, info.Height = int(width), int(height)
+		}
+		return nil
+	})
+}
+
+func readUint(b []byte) uint64 {
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}